@@ -62,6 +62,90 @@ func (m *ReloadRequest) GetZonename() string {
 	return ""
 }
 
+type KeysRequest struct {
+	Zonename             string   `protobuf:"bytes,1,opt,name=zonename,proto3" json:"zonename,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *KeysRequest) Reset()         { *m = KeysRequest{} }
+func (m *KeysRequest) String() string { return proto.CompactTextString(m) }
+func (*KeysRequest) ProtoMessage()    {}
+func (*KeysRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b1b9b0eb52f05c6a, []int{1}
+}
+func (m *KeysRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_KeysRequest.Unmarshal(m, b)
+}
+func (m *KeysRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_KeysRequest.Marshal(b, m, deterministic)
+}
+func (m *KeysRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_KeysRequest.Merge(m, src)
+}
+func (m *KeysRequest) XXX_Size() int {
+	return xxx_messageInfo_KeysRequest.Size(m)
+}
+func (m *KeysRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_KeysRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_KeysRequest proto.InternalMessageInfo
+
+func (m *KeysRequest) GetZonename() string {
+	if m != nil {
+		return m.Zonename
+	}
+	return ""
+}
+
+type RolloverRequest struct {
+	Zonename             string   `protobuf:"bytes,1,opt,name=zonename,proto3" json:"zonename,omitempty"`
+	Keytype              string   `protobuf:"bytes,2,opt,name=keytype,proto3" json:"keytype,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RolloverRequest) Reset()         { *m = RolloverRequest{} }
+func (m *RolloverRequest) String() string { return proto.CompactTextString(m) }
+func (*RolloverRequest) ProtoMessage()    {}
+func (*RolloverRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b1b9b0eb52f05c6a, []int{2}
+}
+func (m *RolloverRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RolloverRequest.Unmarshal(m, b)
+}
+func (m *RolloverRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RolloverRequest.Marshal(b, m, deterministic)
+}
+func (m *RolloverRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RolloverRequest.Merge(m, src)
+}
+func (m *RolloverRequest) XXX_Size() int {
+	return xxx_messageInfo_RolloverRequest.Size(m)
+}
+func (m *RolloverRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RolloverRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RolloverRequest proto.InternalMessageInfo
+
+func (m *RolloverRequest) GetZonename() string {
+	if m != nil {
+		return m.Zonename
+	}
+	return ""
+}
+
+func (m *RolloverRequest) GetKeytype() string {
+	if m != nil {
+		return m.Keytype
+	}
+	return ""
+}
+
 type GetZonesResponse struct {
 	Zones                []*Zone  `protobuf:"bytes,1,rep,name=zones,proto3" json:"zones,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -73,7 +157,7 @@ func (m *GetZonesResponse) Reset()         { *m = GetZonesResponse{} }
 func (m *GetZonesResponse) String() string { return proto.CompactTextString(m) }
 func (*GetZonesResponse) ProtoMessage()    {}
 func (*GetZonesResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_b1b9b0eb52f05c6a, []int{1}
+	return fileDescriptor_b1b9b0eb52f05c6a, []int{3}
 }
 func (m *GetZonesResponse) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_GetZonesResponse.Unmarshal(m, b)
@@ -111,7 +195,7 @@ func (m *GetServicesResponse) Reset()         { *m = GetServicesResponse{} }
 func (m *GetServicesResponse) String() string { return proto.CompactTextString(m) }
 func (*GetServicesResponse) ProtoMessage()    {}
 func (*GetServicesResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_b1b9b0eb52f05c6a, []int{2}
+	return fileDescriptor_b1b9b0eb52f05c6a, []int{4}
 }
 func (m *GetServicesResponse) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_GetServicesResponse.Unmarshal(m, b)
@@ -149,7 +233,7 @@ func (m *GetMonitorsResponse) Reset()         { *m = GetMonitorsResponse{} }
 func (m *GetMonitorsResponse) String() string { return proto.CompactTextString(m) }
 func (*GetMonitorsResponse) ProtoMessage()    {}
 func (*GetMonitorsResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_b1b9b0eb52f05c6a, []int{3}
+	return fileDescriptor_b1b9b0eb52f05c6a, []int{5}
 }
 func (m *GetMonitorsResponse) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_GetMonitorsResponse.Unmarshal(m, b)
@@ -176,6 +260,44 @@ func (m *GetMonitorsResponse) GetMonitors() []*Monitor {
 	return nil
 }
 
+type GetKeysResponse struct {
+	Keys                 []*Key   `protobuf:"bytes,1,rep,name=keys,proto3" json:"keys,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetKeysResponse) Reset()         { *m = GetKeysResponse{} }
+func (m *GetKeysResponse) String() string { return proto.CompactTextString(m) }
+func (*GetKeysResponse) ProtoMessage()    {}
+func (*GetKeysResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b1b9b0eb52f05c6a, []int{6}
+}
+func (m *GetKeysResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetKeysResponse.Unmarshal(m, b)
+}
+func (m *GetKeysResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetKeysResponse.Marshal(b, m, deterministic)
+}
+func (m *GetKeysResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetKeysResponse.Merge(m, src)
+}
+func (m *GetKeysResponse) XXX_Size() int {
+	return xxx_messageInfo_GetKeysResponse.Size(m)
+}
+func (m *GetKeysResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetKeysResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetKeysResponse proto.InternalMessageInfo
+
+func (m *GetKeysResponse) GetKeys() []*Key {
+	if m != nil {
+		return m.Keys
+	}
+	return nil
+}
+
 type Zone struct {
 	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -187,7 +309,7 @@ func (m *Zone) Reset()         { *m = Zone{} }
 func (m *Zone) String() string { return proto.CompactTextString(m) }
 func (*Zone) ProtoMessage()    {}
 func (*Zone) Descriptor() ([]byte, []int) {
-	return fileDescriptor_b1b9b0eb52f05c6a, []int{4}
+	return fileDescriptor_b1b9b0eb52f05c6a, []int{7}
 }
 func (m *Zone) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_Zone.Unmarshal(m, b)
@@ -225,7 +347,7 @@ func (m *Service) Reset()         { *m = Service{} }
 func (m *Service) String() string { return proto.CompactTextString(m) }
 func (*Service) ProtoMessage()    {}
 func (*Service) Descriptor() ([]byte, []int) {
-	return fileDescriptor_b1b9b0eb52f05c6a, []int{5}
+	return fileDescriptor_b1b9b0eb52f05c6a, []int{8}
 }
 func (m *Service) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_Service.Unmarshal(m, b)
@@ -263,7 +385,7 @@ func (m *Monitor) Reset()         { *m = Monitor{} }
 func (m *Monitor) String() string { return proto.CompactTextString(m) }
 func (*Monitor) ProtoMessage()    {}
 func (*Monitor) Descriptor() ([]byte, []int) {
-	return fileDescriptor_b1b9b0eb52f05c6a, []int{6}
+	return fileDescriptor_b1b9b0eb52f05c6a, []int{9}
 }
 func (m *Monitor) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_Monitor.Unmarshal(m, b)
@@ -290,14 +412,270 @@ func (m *Monitor) GetName() string {
 	return ""
 }
 
+type Key struct {
+	Zonename             string   `protobuf:"bytes,1,opt,name=zonename,proto3" json:"zonename,omitempty"`
+	Keytag               uint32   `protobuf:"varint,2,opt,name=keytag,proto3" json:"keytag,omitempty"`
+	Algorithm            uint32   `protobuf:"varint,3,opt,name=algorithm,proto3" json:"algorithm,omitempty"`
+	Keytype              string   `protobuf:"bytes,4,opt,name=keytype,proto3" json:"keytype,omitempty"`
+	State                string   `protobuf:"bytes,5,opt,name=state,proto3" json:"state,omitempty"`
+	Published            int64    `protobuf:"varint,6,opt,name=published,proto3" json:"published,omitempty"`
+	Active               int64    `protobuf:"varint,7,opt,name=active,proto3" json:"active,omitempty"`
+	Retired              int64    `protobuf:"varint,8,opt,name=retired,proto3" json:"retired,omitempty"`
+	Removed              int64    `protobuf:"varint,9,opt,name=removed,proto3" json:"removed,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Key) Reset()         { *m = Key{} }
+func (m *Key) String() string { return proto.CompactTextString(m) }
+func (*Key) ProtoMessage()    {}
+func (*Key) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b1b9b0eb52f05c6a, []int{10}
+}
+func (m *Key) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Key.Unmarshal(m, b)
+}
+func (m *Key) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Key.Marshal(b, m, deterministic)
+}
+func (m *Key) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Key.Merge(m, src)
+}
+func (m *Key) XXX_Size() int {
+	return xxx_messageInfo_Key.Size(m)
+}
+func (m *Key) XXX_DiscardUnknown() {
+	xxx_messageInfo_Key.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Key proto.InternalMessageInfo
+
+func (m *Key) GetZonename() string {
+	if m != nil {
+		return m.Zonename
+	}
+	return ""
+}
+
+func (m *Key) GetKeytag() uint32 {
+	if m != nil {
+		return m.Keytag
+	}
+	return 0
+}
+
+func (m *Key) GetAlgorithm() uint32 {
+	if m != nil {
+		return m.Algorithm
+	}
+	return 0
+}
+
+func (m *Key) GetKeytype() string {
+	if m != nil {
+		return m.Keytype
+	}
+	return ""
+}
+
+func (m *Key) GetState() string {
+	if m != nil {
+		return m.State
+	}
+	return ""
+}
+
+func (m *Key) GetPublished() int64 {
+	if m != nil {
+		return m.Published
+	}
+	return 0
+}
+
+func (m *Key) GetActive() int64 {
+	if m != nil {
+		return m.Active
+	}
+	return 0
+}
+
+func (m *Key) GetRetired() int64 {
+	if m != nil {
+		return m.Retired
+	}
+	return 0
+}
+
+func (m *Key) GetRemoved() int64 {
+	if m != nil {
+		return m.Removed
+	}
+	return 0
+}
+
+type GetRateLimitResponse struct {
+	Responses            uint64       `protobuf:"varint,1,opt,name=responses,proto3" json:"responses,omitempty"`
+	Dropped              uint64       `protobuf:"varint,2,opt,name=dropped,proto3" json:"dropped,omitempty"`
+	Slipped              uint64       `protobuf:"varint,3,opt,name=slipped,proto3" json:"slipped,omitempty"`
+	Exempted             uint64       `protobuf:"varint,4,opt,name=exempted,proto3" json:"exempted,omitempty"`
+	Entries              uint32       `protobuf:"varint,5,opt,name=entries,proto3" json:"entries,omitempty"`
+	Limited              []*RateLimit `protobuf:"bytes,6,rep,name=limited,proto3" json:"limited,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
+}
+
+func (m *GetRateLimitResponse) Reset()         { *m = GetRateLimitResponse{} }
+func (m *GetRateLimitResponse) String() string { return proto.CompactTextString(m) }
+func (*GetRateLimitResponse) ProtoMessage()    {}
+func (*GetRateLimitResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b1b9b0eb52f05c6a, []int{11}
+}
+func (m *GetRateLimitResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetRateLimitResponse.Unmarshal(m, b)
+}
+func (m *GetRateLimitResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetRateLimitResponse.Marshal(b, m, deterministic)
+}
+func (m *GetRateLimitResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetRateLimitResponse.Merge(m, src)
+}
+func (m *GetRateLimitResponse) XXX_Size() int {
+	return xxx_messageInfo_GetRateLimitResponse.Size(m)
+}
+func (m *GetRateLimitResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetRateLimitResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetRateLimitResponse proto.InternalMessageInfo
+
+func (m *GetRateLimitResponse) GetResponses() uint64 {
+	if m != nil {
+		return m.Responses
+	}
+	return 0
+}
+
+func (m *GetRateLimitResponse) GetDropped() uint64 {
+	if m != nil {
+		return m.Dropped
+	}
+	return 0
+}
+
+func (m *GetRateLimitResponse) GetSlipped() uint64 {
+	if m != nil {
+		return m.Slipped
+	}
+	return 0
+}
+
+func (m *GetRateLimitResponse) GetExempted() uint64 {
+	if m != nil {
+		return m.Exempted
+	}
+	return 0
+}
+
+func (m *GetRateLimitResponse) GetEntries() uint32 {
+	if m != nil {
+		return m.Entries
+	}
+	return 0
+}
+
+func (m *GetRateLimitResponse) GetLimited() []*RateLimit {
+	if m != nil {
+		return m.Limited
+	}
+	return nil
+}
+
+type RateLimit struct {
+	Prefix               string   `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	Category             string   `protobuf:"bytes,2,opt,name=category,proto3" json:"category,omitempty"`
+	Token                string   `protobuf:"bytes,3,opt,name=token,proto3" json:"token,omitempty"`
+	Dropped              uint64   `protobuf:"varint,4,opt,name=dropped,proto3" json:"dropped,omitempty"`
+	Slipped              uint64   `protobuf:"varint,5,opt,name=slipped,proto3" json:"slipped,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RateLimit) Reset()         { *m = RateLimit{} }
+func (m *RateLimit) String() string { return proto.CompactTextString(m) }
+func (*RateLimit) ProtoMessage()    {}
+func (*RateLimit) Descriptor() ([]byte, []int) {
+	return fileDescriptor_b1b9b0eb52f05c6a, []int{12}
+}
+func (m *RateLimit) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RateLimit.Unmarshal(m, b)
+}
+func (m *RateLimit) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RateLimit.Marshal(b, m, deterministic)
+}
+func (m *RateLimit) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RateLimit.Merge(m, src)
+}
+func (m *RateLimit) XXX_Size() int {
+	return xxx_messageInfo_RateLimit.Size(m)
+}
+func (m *RateLimit) XXX_DiscardUnknown() {
+	xxx_messageInfo_RateLimit.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RateLimit proto.InternalMessageInfo
+
+func (m *RateLimit) GetPrefix() string {
+	if m != nil {
+		return m.Prefix
+	}
+	return ""
+}
+
+func (m *RateLimit) GetCategory() string {
+	if m != nil {
+		return m.Category
+	}
+	return ""
+}
+
+func (m *RateLimit) GetToken() string {
+	if m != nil {
+		return m.Token
+	}
+	return ""
+}
+
+func (m *RateLimit) GetDropped() uint64 {
+	if m != nil {
+		return m.Dropped
+	}
+	return 0
+}
+
+func (m *RateLimit) GetSlipped() uint64 {
+	if m != nil {
+		return m.Slipped
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*ReloadRequest)(nil), "api.ReloadRequest")
+	proto.RegisterType((*KeysRequest)(nil), "api.KeysRequest")
+	proto.RegisterType((*RolloverRequest)(nil), "api.RolloverRequest")
 	proto.RegisterType((*GetZonesResponse)(nil), "api.GetZonesResponse")
 	proto.RegisterType((*GetServicesResponse)(nil), "api.GetServicesResponse")
 	proto.RegisterType((*GetMonitorsResponse)(nil), "api.GetMonitorsResponse")
+	proto.RegisterType((*GetKeysResponse)(nil), "api.GetKeysResponse")
 	proto.RegisterType((*Zone)(nil), "api.Zone")
 	proto.RegisterType((*Service)(nil), "api.Service")
 	proto.RegisterType((*Monitor)(nil), "api.Monitor")
+	proto.RegisterType((*Key)(nil), "api.Key")
+	proto.RegisterType((*GetRateLimitResponse)(nil), "api.GetRateLimitResponse")
+	proto.RegisterType((*RateLimit)(nil), "api.RateLimit")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -318,6 +696,9 @@ type RabbitDNSClient interface {
 	GetZones(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*GetZonesResponse, error)
 	GetMonitors(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*GetMonitorsResponse, error)
 	GetServices(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*GetServicesResponse, error)
+	GetKeys(ctx context.Context, in *KeysRequest, opts ...grpc.CallOption) (*GetKeysResponse, error)
+	RolloverKey(ctx context.Context, in *RolloverRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	GetRateLimit(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*GetRateLimitResponse, error)
 }
 
 type rabbitDNSClient struct {
@@ -382,6 +763,33 @@ func (c *rabbitDNSClient) GetServices(ctx context.Context, in *empty.Empty, opts
 	return out, nil
 }
 
+func (c *rabbitDNSClient) GetKeys(ctx context.Context, in *KeysRequest, opts ...grpc.CallOption) (*GetKeysResponse, error) {
+	out := new(GetKeysResponse)
+	err := c.cc.Invoke(ctx, "/api.RabbitDNS/GetKeys", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rabbitDNSClient) RolloverKey(ctx context.Context, in *RolloverRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/api.RabbitDNS/RolloverKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rabbitDNSClient) GetRateLimit(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*GetRateLimitResponse, error) {
+	out := new(GetRateLimitResponse)
+	err := c.cc.Invoke(ctx, "/api.RabbitDNS/GetRateLimit", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // RabbitDNSServer is the server API for RabbitDNS service.
 type RabbitDNSServer interface {
 	Reconfig(context.Context, *empty.Empty) (*empty.Empty, error)
@@ -390,6 +798,9 @@ type RabbitDNSServer interface {
 	GetZones(context.Context, *empty.Empty) (*GetZonesResponse, error)
 	GetMonitors(context.Context, *empty.Empty) (*GetMonitorsResponse, error)
 	GetServices(context.Context, *empty.Empty) (*GetServicesResponse, error)
+	GetKeys(context.Context, *KeysRequest) (*GetKeysResponse, error)
+	RolloverKey(context.Context, *RolloverRequest) (*empty.Empty, error)
+	GetRateLimit(context.Context, *empty.Empty) (*GetRateLimitResponse, error)
 }
 
 func RegisterRabbitDNSServer(s *grpc.Server, srv RabbitDNSServer) {
@@ -504,6 +915,60 @@ func _RabbitDNS_GetServices_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _RabbitDNS_GetKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeysRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RabbitDNSServer).GetKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.RabbitDNS/GetKeys",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RabbitDNSServer).GetKeys(ctx, req.(*KeysRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RabbitDNS_RolloverKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RolloverRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RabbitDNSServer).RolloverKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.RabbitDNS/RolloverKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RabbitDNSServer).RolloverKey(ctx, req.(*RolloverRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RabbitDNS_GetRateLimit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(empty.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RabbitDNSServer).GetRateLimit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.RabbitDNS/GetRateLimit",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RabbitDNSServer).GetRateLimit(ctx, req.(*empty.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _RabbitDNS_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "api.RabbitDNS",
 	HandlerType: (*RabbitDNSServer)(nil),
@@ -532,6 +997,18 @@ var _RabbitDNS_serviceDesc = grpc.ServiceDesc{
 			MethodName: "GetServices",
 			Handler:    _RabbitDNS_GetServices_Handler,
 		},
+		{
+			MethodName: "GetKeys",
+			Handler:    _RabbitDNS_GetKeys_Handler,
+		},
+		{
+			MethodName: "RolloverKey",
+			Handler:    _RabbitDNS_RolloverKey_Handler,
+		},
+		{
+			MethodName: "GetRateLimit",
+			Handler:    _RabbitDNS_GetRateLimit_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "rabbitdns.proto",
@@ -540,26 +1017,47 @@ var _RabbitDNS_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("rabbitdns.proto", fileDescriptor_b1b9b0eb52f05c6a) }
 
 var fileDescriptor_b1b9b0eb52f05c6a = []byte{
-	// 325 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x52, 0xcf, 0x4b, 0xf3, 0x30,
-	0x18, 0xde, 0xd8, 0xbe, 0x7d, 0xdd, 0x3b, 0x45, 0x89, 0x28, 0xa3, 0x22, 0x8e, 0x9c, 0x06, 0x42,
-	0x06, 0xdb, 0x4d, 0x05, 0x11, 0x94, 0x9d, 0xf4, 0x90, 0xdd, 0xbc, 0xb5, 0xdb, 0xbb, 0x11, 0xd8,
-	0x92, 0xda, 0x64, 0x82, 0x9e, 0xfd, 0xc3, 0xa5, 0x49, 0x5a, 0xda, 0x62, 0x15, 0xbc, 0x6d, 0xcf,
-	0xaf, 0x34, 0xcf, 0x13, 0x38, 0x4a, 0xa3, 0x38, 0x16, 0x66, 0x25, 0x35, 0x4b, 0x52, 0x65, 0x14,
-	0xe9, 0x44, 0x89, 0x08, 0xcf, 0x37, 0x4a, 0x6d, 0xb6, 0x38, 0xb1, 0x50, 0xbc, 0x5f, 0x4f, 0x70,
-	0x97, 0x98, 0x77, 0xa7, 0xa0, 0x57, 0x70, 0xc8, 0x71, 0xab, 0xa2, 0x15, 0xc7, 0xd7, 0x3d, 0x6a,
-	0x43, 0x42, 0x08, 0x3e, 0x94, 0x44, 0x19, 0xed, 0x70, 0xd8, 0x1e, 0xb5, 0xc7, 0x7d, 0x5e, 0xfc,
-	0xa7, 0x33, 0x38, 0x9e, 0xa3, 0x79, 0x51, 0x12, 0x35, 0x47, 0x9d, 0x28, 0xa9, 0x91, 0x5c, 0xc2,
-	0xbf, 0x8c, 0xd7, 0xc3, 0xf6, 0xa8, 0x33, 0x1e, 0x4c, 0xfb, 0x2c, 0x4a, 0x04, 0xcb, 0x24, 0xdc,
-	0xe1, 0xf4, 0x0e, 0x4e, 0xe6, 0x68, 0x16, 0x98, 0xbe, 0x89, 0x65, 0xc9, 0x37, 0x86, 0x40, 0x7b,
-	0xcc, 0x5b, 0x0f, 0xac, 0xd5, 0x0b, 0x79, 0xc1, 0xfa, 0x80, 0x27, 0x25, 0x85, 0x51, 0x69, 0x25,
-	0x60, 0xe7, 0xb1, 0x4a, 0x80, 0x17, 0xf2, 0x82, 0xa5, 0x21, 0x74, 0xb3, 0x0f, 0x22, 0x04, 0xba,
-	0xa5, 0x6b, 0xd9, 0xdf, 0xf4, 0x02, 0xfe, 0xfb, 0x13, 0x9b, 0x68, 0x9f, 0xf7, 0x1d, 0x3d, 0xfd,
-	0xec, 0x40, 0x9f, 0xdb, 0xce, 0x1f, 0x9e, 0x17, 0xe4, 0x16, 0x02, 0x8e, 0x4b, 0x25, 0xd7, 0x62,
-	0x43, 0xce, 0x98, 0x6b, 0x9d, 0xe5, 0xad, 0xb3, 0xc7, 0xac, 0xf5, 0xb0, 0x01, 0xa7, 0x2d, 0x72,
-	0x0d, 0x3d, 0xb7, 0xc4, 0x9f, 0xbc, 0xe0, 0xbc, 0xee, 0x9e, 0xb6, 0x87, 0xca, 0xac, 0x3f, 0x78,
-	0x6f, 0x20, 0xc8, 0x47, 0x6d, 0x3c, 0xf9, 0xd4, 0x26, 0xd6, 0xb7, 0xa7, 0x2d, 0x72, 0x0f, 0x83,
-	0xd2, 0x36, 0x8d, 0xfe, 0x61, 0xee, 0xaf, 0xaf, 0x58, 0x44, 0xe4, 0xef, 0xe3, 0xf7, 0x88, 0xfa,
-	0x4b, 0xa2, 0xad, 0xb8, 0x67, 0xb5, 0xb3, 0xaf, 0x00, 0x00, 0x00, 0xff, 0xff, 0x22, 0x4b, 0x36,
-	0xb5, 0x00, 0x03, 0x00, 0x00,
+	// 668 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x54, 0xcd, 0x6e, 0xd3, 0x4c,
+	0x14, 0x8d, 0xbf, 0x38, 0x3f, 0xbe, 0x69, 0xbf, 0x56, 0xf3, 0xf5, 0xab, 0x4c, 0x28, 0xa2, 0x9a,
+	0x55, 0x10, 0x52, 0x2a, 0xb5, 0x3b, 0x7e, 0x84, 0x10, 0xa0, 0x2c, 0x0a, 0x2c, 0xa6, 0x3b, 0x76,
+	0x4e, 0x7c, 0x9b, 0x8e, 0xea, 0x78, 0x8c, 0x67, 0x1a, 0xd5, 0xbc, 0x01, 0x0f, 0xc5, 0x9e, 0xd7,
+	0xe1, 0x0d, 0xd0, 0xfc, 0x39, 0x4e, 0x20, 0xa5, 0x62, 0x97, 0x73, 0xcf, 0x3d, 0xd7, 0x77, 0xce,
+	0x64, 0x0e, 0xec, 0x95, 0xc9, 0x74, 0xca, 0x55, 0x9a, 0xcb, 0x71, 0x51, 0x0a, 0x25, 0x48, 0x3b,
+	0x29, 0xf8, 0xf0, 0xe1, 0x5c, 0x88, 0x79, 0x86, 0x27, 0xa6, 0x34, 0xbd, 0xb9, 0x3c, 0xc1, 0x45,
+	0xa1, 0x2a, 0xdb, 0x41, 0x9f, 0xc2, 0x2e, 0xc3, 0x4c, 0x24, 0x29, 0xc3, 0xcf, 0x37, 0x28, 0x15,
+	0x19, 0x42, 0xff, 0x8b, 0xc8, 0x31, 0x4f, 0x16, 0x18, 0x07, 0xc7, 0xc1, 0x28, 0x62, 0x35, 0xa6,
+	0x4f, 0x60, 0x70, 0x8e, 0x95, 0xbc, 0x4f, 0xeb, 0x04, 0xf6, 0x98, 0xc8, 0x32, 0xb1, 0xc4, 0xf2,
+	0x1e, 0xed, 0x24, 0x86, 0xde, 0x35, 0x56, 0xaa, 0x2a, 0x30, 0xfe, 0xc7, 0x50, 0x1e, 0xd2, 0x33,
+	0xd8, 0x9f, 0xa0, 0xfa, 0x24, 0x72, 0x94, 0x0c, 0x65, 0x21, 0x72, 0x89, 0xe4, 0x31, 0x74, 0xb4,
+	0x52, 0xc6, 0xc1, 0x71, 0x7b, 0x34, 0x38, 0x8d, 0xc6, 0x49, 0xc1, 0xc7, 0xba, 0x85, 0xd9, 0x3a,
+	0x7d, 0x05, 0xff, 0x4d, 0x50, 0x5d, 0x60, 0xb9, 0xe4, 0xb3, 0x86, 0x6e, 0x04, 0x7d, 0xe9, 0x6a,
+	0x4e, 0xba, 0x63, 0xa4, 0xae, 0x91, 0xd5, 0xac, 0x1b, 0xf0, 0x41, 0xe4, 0x5c, 0x89, 0x72, 0x6d,
+	0xc0, 0xc2, 0xd5, 0xd6, 0x06, 0xb8, 0x46, 0x56, 0xb3, 0xf4, 0x04, 0xf6, 0x26, 0xa8, 0xac, 0x5b,
+	0x4e, 0x7c, 0x04, 0xe1, 0x35, 0x56, 0x5e, 0xd8, 0x37, 0xc2, 0x73, 0xac, 0x98, 0xa9, 0xd2, 0x21,
+	0x84, 0xfa, 0x04, 0x84, 0x40, 0xd8, 0x70, 0xc8, 0xfc, 0xa6, 0x8f, 0xa0, 0xe7, 0x56, 0xdc, 0x46,
+	0xbb, 0x05, 0x7e, 0x4b, 0xff, 0x08, 0xa0, 0x7d, 0x8e, 0xd5, 0x9d, 0xfe, 0x1f, 0x42, 0x57, 0x1b,
+	0x9e, 0xcc, 0x8d, 0xfd, 0xbb, 0xcc, 0x21, 0x72, 0x04, 0x51, 0x92, 0xcd, 0x45, 0xc9, 0xd5, 0xd5,
+	0x22, 0x6e, 0x1b, 0x6a, 0x55, 0x68, 0xde, 0x5a, 0xb8, 0x76, 0x6b, 0xe4, 0x00, 0x3a, 0x52, 0x25,
+	0x0a, 0xe3, 0x8e, 0xa9, 0x5b, 0xa0, 0xa7, 0x15, 0x37, 0xd3, 0x8c, 0xcb, 0x2b, 0x4c, 0xe3, 0xee,
+	0x71, 0x30, 0x6a, 0xb3, 0x55, 0x41, 0xef, 0x90, 0xcc, 0x14, 0x5f, 0x62, 0xdc, 0x33, 0x94, 0x43,
+	0xfa, 0x2b, 0x25, 0x2a, 0x5e, 0x62, 0x1a, 0xf7, 0x0d, 0xe1, 0xa1, 0x65, 0x16, 0x62, 0x89, 0x69,
+	0x1c, 0x79, 0xc6, 0x40, 0xfa, 0x3d, 0x80, 0x83, 0x09, 0x2a, 0x96, 0x28, 0x7c, 0xcf, 0x17, 0x5c,
+	0x35, 0x2e, 0x21, 0x2a, 0xdd, 0x6f, 0x69, 0x5c, 0x08, 0xd9, 0xaa, 0xa0, 0x07, 0xa6, 0xa5, 0x28,
+	0x0a, 0x4c, 0x8d, 0x0f, 0x21, 0xf3, 0x50, 0x33, 0x32, 0xe3, 0x86, 0x69, 0x5b, 0xc6, 0x41, 0x6d,
+	0x2b, 0xde, 0xea, 0x27, 0x85, 0xa9, 0x71, 0x21, 0x64, 0x35, 0xd6, 0x2a, 0xcc, 0x55, 0xc9, 0x51,
+	0x1a, 0x23, 0x76, 0x99, 0x87, 0x64, 0x04, 0xbd, 0x4c, 0x2f, 0x66, 0x8c, 0xd0, 0xff, 0x87, 0x7f,
+	0xcd, 0xff, 0x61, 0xb5, 0xb0, 0xa7, 0xe9, 0xd7, 0x00, 0xa2, 0xba, 0xac, 0x4d, 0x2a, 0x4a, 0xbc,
+	0xe4, 0xb7, 0xee, 0x0a, 0x1d, 0xd2, 0x5b, 0xcc, 0x12, 0x85, 0x73, 0x51, 0x56, 0xee, 0x05, 0xd5,
+	0x58, 0x5f, 0x86, 0x12, 0xd7, 0x98, 0x9b, 0xcd, 0x23, 0x66, 0x41, 0xf3, 0xac, 0xe1, 0xd6, 0xb3,
+	0x76, 0xd6, 0xce, 0x7a, 0xfa, 0x2d, 0xd4, 0xbb, 0xe8, 0x8c, 0x79, 0xfb, 0xf1, 0x82, 0xbc, 0x80,
+	0x3e, 0xc3, 0x99, 0xc8, 0x2f, 0xf9, 0x9c, 0x1c, 0x8e, 0x6d, 0xca, 0x8c, 0x7d, 0xca, 0x8c, 0xdf,
+	0xe9, 0x94, 0x19, 0x6e, 0xa9, 0xd3, 0x16, 0x79, 0x06, 0x5d, 0x9b, 0x3c, 0x7f, 0xa5, 0x05, 0xab,
+	0xb5, 0x4f, 0xc6, 0x5a, 0xd7, 0x8c, 0xb1, 0x3b, 0xb4, 0xcf, 0xa1, 0xef, 0x03, 0x65, 0xeb, 0x97,
+	0xff, 0x37, 0x13, 0x37, 0x73, 0x87, 0xb6, 0xc8, 0x6b, 0x18, 0x34, 0x72, 0x61, 0xab, 0x3e, 0xf6,
+	0xfa, 0xcd, 0x04, 0xa9, 0x47, 0xf8, 0x6c, 0xfa, 0xf3, 0x88, 0xcd, 0x14, 0xa3, 0x2d, 0x72, 0x06,
+	0x3d, 0x17, 0x2e, 0x64, 0xdf, 0xc7, 0x88, 0x4f, 0xe5, 0xe1, 0x81, 0x17, 0x36, 0xc3, 0x87, 0xb6,
+	0xc8, 0x4b, 0x18, 0xf8, 0x44, 0xd6, 0x69, 0x60, 0xdb, 0x36, 0x32, 0xfa, 0x0e, 0xdb, 0xde, 0xc0,
+	0x4e, 0xf3, 0x41, 0x6d, 0xdd, 0xfb, 0x81, 0xff, 0xfc, 0x2f, 0x6f, 0x8f, 0xb6, 0xa6, 0x5d, 0xd3,
+	0x7c, 0xf6, 0x73, 0x00, 0x29, 0x2c, 0xad, 0xcc, 0xa9, 0x06, 0x00, 0x00,
 }
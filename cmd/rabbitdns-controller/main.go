@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"time"
 
 	empty "github.com/golang/protobuf/ptypes/empty"
 	"github.com/rabbitdns/rabbitdns/api"
@@ -94,7 +95,29 @@ func main() {
 		Run:   getMonitors,
 	}
 
-	rootCmd.AddCommand(cmdZones, cmdServices, cmdMonitors, cmdReconfig, cmdReload, cmdVersion)
+	cmdKeys := &cobra.Command{
+		Use:   "keys ZONENAME",
+		Short: "Print DNSSEC keys of zone",
+		Long:  `print DNSSEC keys in key store of zone with their states and timings.`,
+		Args:  cobra.ExactArgs(1),
+		Run:   getKeys,
+	}
+	cmdRollover := &cobra.Command{
+		Use:   "rollover ZONENAME ksk|zsk",
+		Short: "Start DNSSEC key rollover",
+		Long:  `start ZSK pre-publish rollover or KSK double signature rollover of zone. if zone has no key of the type, new key is created.`,
+		Args:  cobra.ExactArgs(2),
+		Run:   rollover,
+	}
+	cmdRateLimit := &cobra.Command{
+		Use:   "ratelimit",
+		Short: "Print response rate limiting counters",
+		Long:  `print counters of response rate limiting and client prefixes which are limited now.`,
+		Args:  cobra.MaximumNArgs(0),
+		Run:   getRateLimit,
+	}
+
+	rootCmd.AddCommand(cmdZones, cmdServices, cmdMonitors, cmdKeys, cmdRollover, cmdRateLimit, cmdReconfig, cmdReload, cmdVersion)
 
 	if err := rootCmd.Execute(); err != nil {
 		log.WithFields(log.Fields{
@@ -199,3 +222,51 @@ func getMonitors(cb *cobra.Command, args []string) {
 		}
 	}
 }
+
+func formatTime(t int64) string {
+	if t == 0 {
+		return "-"
+	}
+	return time.Unix(t, 0).UTC().Format("20060102150405")
+}
+
+func getKeys(cb *cobra.Command, args []string) {
+	client := connect(cb)
+	message := &api.KeysRequest{Zonename: args[0]}
+	res, err := client.GetKeys(context.TODO(), message)
+	if err != nil {
+		fmt.Printf("error::%#v \n", err)
+	}
+	if res != nil {
+		for _, key := range res.Keys {
+			fmt.Printf("%s %d %d %s %s published:%s active:%s retired:%s removed:%s\n",
+				key.Zonename, key.Keytag, key.Algorithm, key.Keytype, key.State,
+				formatTime(key.Published), formatTime(key.Active), formatTime(key.Retired), formatTime(key.Removed))
+		}
+	}
+}
+
+func rollover(cb *cobra.Command, args []string) {
+	client := connect(cb)
+	message := &api.RolloverRequest{Zonename: args[0], Keytype: args[1]}
+	if _, err := client.RolloverKey(context.TODO(), message); err != nil {
+		fmt.Printf("error::%#v \n", err)
+	}
+}
+
+func getRateLimit(cb *cobra.Command, args []string) {
+	client := connect(cb)
+	message := &empty.Empty{}
+	res, err := client.GetRateLimit(context.TODO(), message)
+	if err != nil {
+		fmt.Printf("error::%#v \n", err)
+	}
+	if res != nil {
+		fmt.Printf("responses:%d dropped:%d slipped:%d exempted:%d entries:%d\n",
+			res.Responses, res.Dropped, res.Slipped, res.Exempted, res.Entries)
+		for _, limit := range res.Limited {
+			fmt.Printf("%s %s %s dropped:%d slipped:%d\n",
+				limit.Prefix, limit.Category, limit.Token, limit.Dropped, limit.Slipped)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"net"
+	"strings"
+
+	"github.com/rabbitdns/rabbitdns/lib/misc"
+)
+
+// maximum depth of ACLs which refer other ACLs
+const maxACLDepth = 8
+
+// ACLConfig is named access control list, written as [[ACLs]] tables.
+// Elements are addresses, CIDR prefixes, "key NAME" of TSIG key, names of other ACLs,
+// "any" and "none". The element which starts with "!" is negated.
+type ACLConfig struct {
+	Name     string
+	Elements []string
+}
+
+// GetACL returns the named ACL, or nil when the ACL is not written in config.
+func (c *Config) GetACL(name string) *ACLConfig {
+	for i := range c.ACLs {
+		if strings.EqualFold(c.ACLs[i].Name, name) {
+			return &c.ACLs[i]
+		}
+	}
+	return nil
+}
+
+// MatchACL checks the client by the elements in order, and the first matched element decides.
+// key is the FQDN of TSIG key which signed the request, or empty string.
+// matched is false when no element matches the client.
+func (c *Config) MatchACL(elements []string, ip net.IP, key string) (allowed bool, matched bool) {
+	return c.matchACL(elements, ip, key, 0)
+}
+
+func (c *Config) matchACL(elements []string, ip net.IP, key string, depth int) (bool, bool) {
+	if depth > maxACLDepth {
+		return false, false
+	}
+	for _, element := range elements {
+		negated, element := parseACLElement(element)
+		match := false
+		switch {
+		case element == "any":
+			match = true
+		case element == "none":
+		case strings.HasPrefix(element, "key "):
+			name := strings.TrimSpace(strings.TrimPrefix(element, "key "))
+			match = key != "" && strings.EqualFold(misc.FQDN(name), key)
+		case c.GetACL(element) != nil:
+			if allowed, ok := c.matchACL(c.GetACL(element).Elements, ip, key, depth+1); ok {
+				return allowed != negated, true
+			}
+		default:
+			match = misc.MatchPrefix(ip, element)
+		}
+		if match {
+			return !negated, true
+		}
+	}
+	return false, false
+}
+
+func parseACLElement(element string) (bool, string) {
+	element = strings.TrimSpace(element)
+	if strings.HasPrefix(element, "!") {
+		return true, strings.TrimSpace(element[1:])
+	}
+	return false, element
+}
+
+// checkACL returns syntax errors of the elements.
+func (c *Config) checkACL(elements []string, depth int) []error {
+	if depth > maxACLDepth {
+		return []error{ErrSyntaxACLLoop}
+	}
+	errs := []error{}
+	for _, element := range elements {
+		_, element := parseACLElement(element)
+		switch {
+		case element == "any", element == "none", isPrefix(element):
+		case strings.HasPrefix(element, "key "):
+			if c.GetTSIGKey(strings.TrimSpace(strings.TrimPrefix(element, "key "))) == nil {
+				errs = append(errs, ErrSyntaxUnknownTSIGKey)
+			}
+		case c.GetACL(element) != nil:
+			errs = append(errs, c.checkACL(c.GetACL(element).Elements, depth+1)...)
+		default:
+			errs = append(errs, ErrSyntaxACLElement)
+		}
+	}
+	return errs
+}
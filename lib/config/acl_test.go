@@ -0,0 +1,65 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMatchACL(t *testing.T) {
+	c := &Config{ACLs: []ACLConfig{
+		{Name: "internal", Elements: []string{"!192.0.2.128/25", "192.0.2.0/24", "key xfr"}},
+		{Name: "loop", Elements: []string{"loop"}},
+	}}
+	testcases := []struct {
+		elements []string
+		ip       string
+		key      string
+		allowed  bool
+		matched  bool
+	}{
+		{[]string{"internal"}, "192.0.2.1", "", true, true},
+		{[]string{"internal"}, "192.0.2.129", "", false, true},
+		{[]string{"internal"}, "198.51.100.1", "xfr.", true, true},
+		{[]string{"internal"}, "198.51.100.1", "", false, false},
+		{[]string{"!internal", "any"}, "192.0.2.1", "", false, true},
+		{[]string{"!internal", "any"}, "198.51.100.1", "", true, true},
+		{[]string{"none"}, "192.0.2.1", "", false, false},
+		{[]string{"loop"}, "192.0.2.1", "", false, false},
+	}
+	for _, tc := range testcases {
+		allowed, matched := c.MatchACL(tc.elements, net.ParseIP(tc.ip), tc.key)
+		if allowed != tc.allowed || matched != tc.matched {
+			t.Errorf("%v for %s %s is need to be %v %v: %v %v", tc.elements, tc.ip, tc.key, tc.allowed, tc.matched, allowed, matched)
+		}
+	}
+}
+
+func TestCheckACL(t *testing.T) {
+	c := &Config{
+		ACLs:     []ACLConfig{{Name: "internal", Elements: []string{"192.0.2.0/24"}}, {Name: "loop", Elements: []string{"loop"}}},
+		TSIGKeys: []TSIGKeyConfig{{Name: "xfr"}},
+	}
+	if errs := c.checkACL([]string{"internal", "!2001:db8::/32", "key xfr", "any", "none"}, 0); len(errs) != 0 {
+		t.Errorf("ACL elements are need to be valid: %v", errs)
+	}
+	for _, element := range []string{"unknown", "key unknown", "loop"} {
+		if errs := c.checkACL([]string{element}, 0); len(errs) == 0 {
+			t.Errorf("%s is need to be invalid", element)
+		}
+	}
+}
@@ -16,14 +16,19 @@
 package config
 
 import (
+	"crypto/tls"
+	"encoding/base64"
 	"errors"
+	"io/ioutil"
 	"net"
 	"os"
 	"os/signal"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"syscall"
 
+	"github.com/rabbitdns/rabbitdns/lib/misc"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/spf13/viper"
@@ -37,19 +42,143 @@ var (
 	ErrSyntaxUnknownUser      = errors.New("User parameter is Unknown")
 	ErrSyntaxNoCtlListen      = errors.New("CtlListens parameter is required")
 	ErrSyntaxCtlInvalidListen = errors.New("CtlListens parameter is invalid format")
+	ErrSyntaxTLSInvalidListen = errors.New("TLSListens parameter is invalid format")
+	ErrSyntaxTLSCertificate   = errors.New("TLSCertFile and TLSKeyFile parameters must be PEM encoded certificate and key pair")
+	ErrSyntaxHTTPSListen      = errors.New("HTTPSListens parameter is invalid format")
+	ErrSyntaxQUICListen       = errors.New("QUICListens parameter is invalid format")
+	ErrSyntaxDoQLimits        = errors.New("DoQMaxConns and DoQMaxStreams parameters must grater than 0")
+	ErrSyntaxDoHPath          = errors.New("DoHPath parameter must start with /")
+	ErrSyntaxProxyListen      = errors.New("ProxyListens parameter must be address in Listens or TLSListens")
+	ErrSyntaxTrustedProxies   = errors.New("TrustedProxies parameter is invalid format")
 	ErrSyntaxMinTCPQueries    = errors.New("MaxTCPQueries parameter must grater than 0")
+	ErrSyntaxMaxUDPSize       = errors.New("MaxUDPSize parameter must be between 512 and 65535")
+	ErrSyntaxCookieRotation   = errors.New("CookieRotation parameter must grater than 0")
+	ErrSyntaxRRLRate          = errors.New("RRLResponses, RRLNXDomains and RRLErrors parameters must not be negative")
+	ErrSyntaxRRLWindow        = errors.New("RRLWindow parameter must be between 1 and 3600")
+	ErrSyntaxRRLSlip          = errors.New("RRLSlip parameter must be between 0 and 10")
+	ErrSyntaxRRLPrefix        = errors.New("RRLIPv4Prefix and RRLIPv6Prefix parameters are invalid prefix length")
+	ErrSyntaxRRLMaxEntries    = errors.New("RRLMaxEntries parameter must grater than 0")
+	ErrSyntaxRRLExempt        = errors.New("RRLExempt parameter is invalid format")
+	ErrSyntaxSigValidity      = errors.New("SignatureValidity parameter must grater than 0")
+	ErrSyntaxDenialMode       = errors.New("DenialOfExistence parameter must be compact or minimal")
+	ErrSyntaxKeyAlgorithm     = errors.New("KeyAlgorithm parameter is unsupported algorithm")
+	ErrSyntaxKeyLifetime      = errors.New("KSKLifetime and ZSKLifetime parameters must not be negative")
+	ErrSyntaxKeyTiming        = errors.New("KeyPublishSafety and DSPropagationDelay parameters must grater than 0")
+	ErrSyntaxDynTransfer      = errors.New("DynamicTransfer parameter must be resolve or omit")
+	ErrSyntaxAnyResponse      = errors.New("AnyResponse parameter must be minimal or hinfo")
+	ErrSyntaxMetaQueries      = errors.New("MetaQueries parameter must be notimp or refused")
+	ErrSyntaxIdentity         = errors.New("Identity parameter must be shorter than 256 octets")
+	ErrSyntaxAllowChaos       = errors.New("AllowChaos parameter is invalid format")
+	ErrSyntaxMaxCNAMEChain    = errors.New("MaxCNAMEChain parameter must be between 1 and 64")
+	ErrSyntaxJournalSize      = errors.New("MaxJournalChanges parameter must grater than 0")
+	ErrSyntaxNoZoneName       = errors.New("Zones.Name parameter is required")
+	ErrSyntaxAllowTransfer    = errors.New("Zones.AllowTransfer parameter is invalid format")
+	ErrSyntaxPrimaries        = errors.New("Zones.Primaries parameter is invalid format")
+	ErrSyntaxAlsoNotify       = errors.New("Zones.AlsoNotify parameter is invalid format")
+	ErrSyntaxAllowNotify      = errors.New("Zones.AllowNotify parameter is invalid format")
+	ErrSyntaxAllowUpdate      = errors.New("Zones.AllowUpdate parameter is invalid format")
+	ErrSyntaxTSIGKeyName      = errors.New("TSIGKeys.Name parameter is required")
+	ErrSyntaxTSIGAlgorithm    = errors.New("TSIGKeys.Algorithm parameter must be hmac-sha256 or hmac-sha512")
+	ErrSyntaxTSIGSecret       = errors.New("TSIGKeys.SecretFile parameter must be file of base64 encoded secret")
+	ErrSyntaxUnknownTSIGKey   = errors.New("Zones parameter refers unknown TSIG key")
+	ErrSyntaxAllowQuery       = errors.New("Zones.AllowQuery parameter is invalid format")
+	ErrSyntaxZoneAnyResponse  = errors.New("Zones.AnyResponse parameter must be minimal or hinfo")
+	ErrSyntaxZoneMetaQueries  = errors.New("Zones.MetaQueries parameter must be notimp or refused")
+	ErrSyntaxACLName          = errors.New("ACLs.Name parameter is required and must not be any or none")
+	ErrSyntaxACLElement       = errors.New("ACLs.Elements parameter has unknown element")
+	ErrSyntaxACLLoop          = errors.New("ACLs refer each other too deeply")
 )
 
+// ZoneConfig is per zone settings, written as [[Zones]] tables.
+type ZoneConfig struct {
+	Name string
+	// ACL elements of clients which are allowed to query the zone, everyone when it is empty
+	AllowQuery []string
+	// ACL elements of clients which are allowed to transfer the zone
+	AllowTransfer []string
+	// addresses of primary servers, the zone is served as secondary when it is set
+	Primaries []string
+	// addresses which are notified of zone changes in addition to NS hosts
+	AlsoNotify []string
+	// ACL elements of clients which are allowed to notify in addition to primaries
+	AllowNotify []string
+	// ACL elements of clients which are allowed to send dynamic update
+	AllowUpdate []string
+	// TSIG keys which are required to transfer, update or query the zone
+	TransferKeys []string
+	UpdateKeys   []string
+	QueryKeys    []string
+	// TSIG key to sign SOA queries and transfers to primary servers
+	PrimaryKey string
+	// policies of ANY and meta type queries, global ones are used when they are empty
+	AnyResponse string
+	MetaQueries string
+}
+
+// TSIGKeyConfig is shared secret key, written as [[TSIGKeys]] tables.
+type TSIGKeyConfig struct {
+	Name string
+	// hmac-sha256 or hmac-sha512
+	Algorithm string
+	// file which has base64 encoded secret
+	SecretFile string
+}
+
 type Config struct {
 	Listens             []string
 	User                string
 	CtlListens          []string
+	TLSListens          []string
+	TLSCertFile         string
+	TLSKeyFile          string
+	HTTPSListens        []string
+	DoHPath             string
+	ProxyListens        []string
+	TrustedProxies      []string
+	QUICListens         []string
+	DoQMaxConns         int
+	DoQMaxStreams       int
 	LogLevel            string
 	MaxTCPQueries       int
+	MaxUDPSize          int
+	Cookies             bool
+	RequireCookies      bool
+	CookieRotation      int
+	RRLResponses        int
+	RRLNXDomains        int
+	RRLErrors           int
+	RRLWindow           int
+	RRLSlip             int
+	RRLIPv4Prefix       int
+	RRLIPv6Prefix       int
+	RRLMaxEntries       int
+	RRLExempt           []string
 	ZonesDir            string
 	ServicesDir         string
 	MonitorsDir         string
 	StateFile           string
+	KeysDir             string
+	JournalDir          string
+	MaxJournalChanges   int
+	SignatureValidity   int
+	DenialOfExistence   string
+	KeyAlgorithm        string
+	KSKLifetime         int
+	ZSKLifetime         int
+	KeyPublishSafety    int
+	DSPropagationDelay  int
+	DynamicTransfer     string
+	AnyResponse         string
+	MetaQueries         string
+	Identity            string
+	HideVersion         bool
+	HideIdentity        bool
+	AllowChaos          []string
+	NSID                bool
+	MaxCNAMEChain       int
+	Zones               []ZoneConfig
+	TSIGKeys            []TSIGKeyConfig
+	ACLs                []ACLConfig
 	MinimumResponse     bool
 	AutoZoneReload      bool
 	AutoServiceReconfig bool
@@ -85,12 +214,57 @@ func LoadConfig(configFile string, ch chan *Config) {
 		v.SetDefault("Listens", []string{"0.0.0.0:53", "[::]:53"})
 		v.SetDefault("User", "rabbitdns")
 		v.SetDefault("CtlListens", []string{"127.0.0.1:8053", "[::1]:8053"})
+		v.SetDefault("TLSListens", []string{})
+		v.SetDefault("TLSCertFile", "")
+		v.SetDefault("TLSKeyFile", "")
+		v.SetDefault("HTTPSListens", []string{})
+		v.SetDefault("DoHPath", "/dns-query")
+		v.SetDefault("ProxyListens", []string{})
+		v.SetDefault("TrustedProxies", []string{})
+		v.SetDefault("QUICListens", []string{})
+		v.SetDefault("DoQMaxConns", 1000)
+		v.SetDefault("DoQMaxStreams", 100)
 		v.SetDefault("LogLevel", "info")
 		v.SetDefault("MaxTCPQueries", 1000)
+		v.SetDefault("MaxUDPSize", 1232)
+		v.SetDefault("Cookies", true)
+		v.SetDefault("RequireCookies", false)
+		v.SetDefault("CookieRotation", 86400)
+		v.SetDefault("RRLResponses", 0)
+		v.SetDefault("RRLNXDomains", 0)
+		v.SetDefault("RRLErrors", 0)
+		v.SetDefault("RRLWindow", 15)
+		v.SetDefault("RRLSlip", 2)
+		v.SetDefault("RRLIPv4Prefix", 24)
+		v.SetDefault("RRLIPv6Prefix", 56)
+		v.SetDefault("RRLMaxEntries", 100000)
+		v.SetDefault("RRLExempt", []string{})
 		v.SetDefault("ZonesDir", "zones")
 		v.SetDefault("ServicesDir", "services")
 		v.SetDefault("MonitorsDir", "monitors")
 		v.SetDefault("StateFile", "/tmp/rabbitdns-state.dat")
+		v.SetDefault("KeysDir", "keys")
+		v.SetDefault("JournalDir", "journal")
+		v.SetDefault("MaxJournalChanges", 100)
+		v.SetDefault("SignatureValidity", 1209600)
+		v.SetDefault("DenialOfExistence", "compact")
+		v.SetDefault("KeyAlgorithm", "ECDSAP256SHA256")
+		v.SetDefault("KSKLifetime", 0)
+		v.SetDefault("ZSKLifetime", 2592000)
+		v.SetDefault("KeyPublishSafety", 86400)
+		v.SetDefault("DSPropagationDelay", 172800)
+		v.SetDefault("DynamicTransfer", "resolve")
+		v.SetDefault("AnyResponse", "minimal")
+		v.SetDefault("MetaQueries", "notimp")
+		v.SetDefault("Identity", "")
+		v.SetDefault("HideVersion", false)
+		v.SetDefault("HideIdentity", false)
+		v.SetDefault("AllowChaos", []string{})
+		v.SetDefault("NSID", false)
+		v.SetDefault("MaxCNAMEChain", 16)
+		v.SetDefault("Zones", []ZoneConfig{})
+		v.SetDefault("TSIGKeys", []TSIGKeyConfig{})
+		v.SetDefault("ACLs", []ACLConfig{})
 		v.SetDefault("MinimumResponse", false)
 		v.SetDefault("AutoZoneReload", true)
 		v.SetDefault("AutoServiceReconfig", true)
@@ -160,8 +334,288 @@ func (c *Config) Check() error {
 			syntaxError.Add(ErrSyntaxCtlInvalidListen)
 		}
 	}
+	for _, listen := range c.TLSListens {
+		_, err := net.ResolveTCPAddr("tcp", listen)
+		if err != nil {
+			syntaxError.Add(ErrSyntaxTLSInvalidListen)
+		}
+	}
+	for _, listen := range c.HTTPSListens {
+		_, err := net.ResolveTCPAddr("tcp", listen)
+		if err != nil {
+			syntaxError.Add(ErrSyntaxHTTPSListen)
+		}
+	}
+	if !strings.HasPrefix(c.DoHPath, "/") {
+		syntaxError.Add(ErrSyntaxDoHPath)
+	}
+	for _, listen := range c.ProxyListens {
+		if !contains(c.Listens, listen) && !contains(c.TLSListens, listen) {
+			syntaxError.Add(ErrSyntaxProxyListen)
+		}
+	}
+	for _, prefix := range c.TrustedProxies {
+		if !isPrefix(prefix) {
+			syntaxError.Add(ErrSyntaxTrustedProxies)
+		}
+	}
+	for _, listen := range c.QUICListens {
+		_, err := net.ResolveUDPAddr("udp", listen)
+		if err != nil {
+			syntaxError.Add(ErrSyntaxQUICListen)
+		}
+	}
+	if c.DoQMaxConns <= 0 || c.DoQMaxStreams <= 0 {
+		syntaxError.Add(ErrSyntaxDoQLimits)
+	}
+	if len(c.TLSListens) > 0 || len(c.HTTPSListens) > 0 || len(c.QUICListens) > 0 {
+		if _, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile); err != nil {
+			syntaxError.Add(ErrSyntaxTLSCertificate)
+		}
+	}
 	if c.MaxTCPQueries == 0 {
 		syntaxError.Add(ErrSyntaxMinTCPQueries)
 	}
+	if c.MaxUDPSize < 512 || c.MaxUDPSize > 65535 {
+		syntaxError.Add(ErrSyntaxMaxUDPSize)
+	}
+	if c.CookieRotation <= 0 {
+		syntaxError.Add(ErrSyntaxCookieRotation)
+	}
+	if c.RRLResponses < 0 || c.RRLNXDomains < 0 || c.RRLErrors < 0 {
+		syntaxError.Add(ErrSyntaxRRLRate)
+	}
+	if c.RRLWindow < 1 || c.RRLWindow > 3600 {
+		syntaxError.Add(ErrSyntaxRRLWindow)
+	}
+	if c.RRLSlip < 0 || c.RRLSlip > 10 {
+		syntaxError.Add(ErrSyntaxRRLSlip)
+	}
+	if c.RRLIPv4Prefix < 0 || c.RRLIPv4Prefix > 32 || c.RRLIPv6Prefix < 0 || c.RRLIPv6Prefix > 128 {
+		syntaxError.Add(ErrSyntaxRRLPrefix)
+	}
+	if c.RRLMaxEntries <= 0 {
+		syntaxError.Add(ErrSyntaxRRLMaxEntries)
+	}
+	for _, prefix := range c.RRLExempt {
+		if !isPrefix(prefix) {
+			syntaxError.Add(ErrSyntaxRRLExempt)
+		}
+	}
+	if c.SignatureValidity <= 0 {
+		syntaxError.Add(ErrSyntaxSigValidity)
+	}
+	switch c.DenialOfExistence {
+	case "compact", "minimal":
+	default:
+		syntaxError.Add(ErrSyntaxDenialMode)
+	}
+	switch c.KeyAlgorithm {
+	case "RSASHA256", "RSASHA512", "ECDSAP256SHA256", "ECDSAP384SHA384", "ED25519":
+	default:
+		syntaxError.Add(ErrSyntaxKeyAlgorithm)
+	}
+	if c.KSKLifetime < 0 || c.ZSKLifetime < 0 {
+		syntaxError.Add(ErrSyntaxKeyLifetime)
+	}
+	if c.KeyPublishSafety <= 0 || c.DSPropagationDelay <= 0 {
+		syntaxError.Add(ErrSyntaxKeyTiming)
+	}
+	switch c.DynamicTransfer {
+	case "resolve", "omit":
+	default:
+		syntaxError.Add(ErrSyntaxDynTransfer)
+	}
+	if !isAnyResponse(c.AnyResponse) {
+		syntaxError.Add(ErrSyntaxAnyResponse)
+	}
+	if !isMetaQueries(c.MetaQueries) {
+		syntaxError.Add(ErrSyntaxMetaQueries)
+	}
+	if len(c.Identity) > 255 {
+		syntaxError.Add(ErrSyntaxIdentity)
+	}
+	if len(c.checkACL(c.AllowChaos, 0)) > 0 {
+		syntaxError.Add(ErrSyntaxAllowChaos)
+	}
+	if c.MaxCNAMEChain < 1 || c.MaxCNAMEChain > 64 {
+		syntaxError.Add(ErrSyntaxMaxCNAMEChain)
+	}
+	if c.MaxJournalChanges <= 0 {
+		syntaxError.Add(ErrSyntaxJournalSize)
+	}
+	for _, zone := range c.Zones {
+		if zone.Name == "" {
+			syntaxError.Add(ErrSyntaxNoZoneName)
+		}
+		if len(c.checkACL(zone.AllowQuery, 0)) > 0 {
+			syntaxError.Add(ErrSyntaxAllowQuery)
+		}
+		if len(c.checkACL(zone.AllowTransfer, 0)) > 0 {
+			syntaxError.Add(ErrSyntaxAllowTransfer)
+		}
+		for _, primary := range zone.Primaries {
+			if !isAddress(primary) {
+				syntaxError.Add(ErrSyntaxPrimaries)
+			}
+		}
+		for _, addr := range zone.AlsoNotify {
+			if !isAddress(addr) {
+				syntaxError.Add(ErrSyntaxAlsoNotify)
+			}
+		}
+		if len(c.checkACL(zone.AllowNotify, 0)) > 0 {
+			syntaxError.Add(ErrSyntaxAllowNotify)
+		}
+		if len(c.checkACL(zone.AllowUpdate, 0)) > 0 {
+			syntaxError.Add(ErrSyntaxAllowUpdate)
+		}
+		for _, keys := range [][]string{zone.TransferKeys, zone.UpdateKeys, zone.QueryKeys} {
+			for _, key := range keys {
+				if c.GetTSIGKey(key) == nil {
+					syntaxError.Add(ErrSyntaxUnknownTSIGKey)
+				}
+			}
+		}
+		if zone.PrimaryKey != "" && c.GetTSIGKey(zone.PrimaryKey) == nil {
+			syntaxError.Add(ErrSyntaxUnknownTSIGKey)
+		}
+		if zone.AnyResponse != "" && !isAnyResponse(zone.AnyResponse) {
+			syntaxError.Add(ErrSyntaxZoneAnyResponse)
+		}
+		if zone.MetaQueries != "" && !isMetaQueries(zone.MetaQueries) {
+			syntaxError.Add(ErrSyntaxZoneMetaQueries)
+		}
+	}
+	for _, acl := range c.ACLs {
+		switch strings.ToLower(acl.Name) {
+		case "", "any", "none":
+			syntaxError.Add(ErrSyntaxACLName)
+		}
+		for _, err := range c.checkACL(acl.Elements, 0) {
+			syntaxError.Add(err)
+		}
+	}
+	for _, key := range c.TSIGKeys {
+		if key.Name == "" {
+			syntaxError.Add(ErrSyntaxTSIGKeyName)
+		}
+		switch strings.ToLower(key.Algorithm) {
+		case "hmac-sha256", "hmac-sha512":
+		default:
+			syntaxError.Add(ErrSyntaxTSIGAlgorithm)
+		}
+		if _, err := key.Secret(); err != nil {
+			syntaxError.Add(ErrSyntaxTSIGSecret)
+		}
+	}
 	return syntaxError.Return()
 }
+
+// GetZoneConfig returns settings of the zone.
+// When the zone is not written in config, it returns empty settings.
+func (c *Config) GetZoneConfig(name string) *ZoneConfig {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for i := range c.Zones {
+		if strings.ToLower(strings.TrimSuffix(c.Zones[i].Name, ".")) == name {
+			return &c.Zones[i]
+		}
+	}
+	return &ZoneConfig{Name: name}
+}
+
+// IsSecondary reports whether the zone is transferred from primary servers.
+func (z *ZoneConfig) IsSecondary() bool {
+	return len(z.Primaries) > 0
+}
+
+// GetAnyResponse returns how ANY queries of the zone are answered.
+// minimal answers one RRset of the name, hinfo answers synthesized HINFO (RFC 8482).
+func (c *Config) GetAnyResponse(zoneName string) string {
+	if zone := c.GetZoneConfig(zoneName); zone.AnyResponse != "" {
+		return zone.AnyResponse
+	}
+	return c.AnyResponse
+}
+
+// GetMetaQueries returns the rcode, notimp or refused, which is answered to
+// meta and obsolete type queries of the zone.
+func (c *Config) GetMetaQueries(zoneName string) string {
+	if zone := c.GetZoneConfig(zoneName); zone.MetaQueries != "" {
+		return zone.MetaQueries
+	}
+	return c.MetaQueries
+}
+
+// GetTSIGKey returns the TSIG key, or nil when the key is not written in config.
+func (c *Config) GetTSIGKey(name string) *TSIGKeyConfig {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for i := range c.TSIGKeys {
+		if strings.ToLower(strings.TrimSuffix(c.TSIGKeys[i].Name, ".")) == name {
+			return &c.TSIGKeys[i]
+		}
+	}
+	return nil
+}
+
+// TSIGSecrets returns base64 encoded secrets indexed by FQDN of key names.
+func (c *Config) TSIGSecrets() (map[string]string, error) {
+	secrets := map[string]string{}
+	for _, key := range c.TSIGKeys {
+		secret, err := key.Secret()
+		if err != nil {
+			return nil, err
+		}
+		secrets[strings.ToLower(strings.TrimSuffix(key.Name, "."))+"."] = secret
+	}
+	return secrets, nil
+}
+
+// Secret reads base64 encoded secret from SecretFile.
+func (k *TSIGKeyConfig) Secret() (string, error) {
+	data, err := ioutil.ReadFile(k.SecretFile)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(data))
+	if _, err := base64.StdEncoding.DecodeString(secret); err != nil || secret == "" {
+		return "", ErrSyntaxTSIGSecret
+	}
+	return secret, nil
+}
+
+// IsProxyListen reports whether the listener reads PROXY protocol header from trusted proxies.
+func (c *Config) IsProxyListen(listen string) bool {
+	return contains(c.ProxyListens, listen)
+}
+
+func isAnyResponse(s string) bool {
+	return s == "minimal" || s == "hinfo"
+}
+
+func isMetaQueries(s string) bool {
+	return s == "notimp" || s == "refused"
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrefix checks s is IP address or CIDR prefix.
+func isPrefix(s string) bool {
+	if _, _, err := net.ParseCIDR(s); err == nil {
+		return true
+	}
+	return net.ParseIP(s) != nil
+}
+
+// isAddress checks s is IP address with optional port.
+func isAddress(s string) bool {
+	host, _, err := net.SplitHostPort(misc.HostPort(s, "53"))
+	return err == nil && net.ParseIP(host) != nil
+}
@@ -14,3 +14,77 @@
 // limitations under the License.
 
 package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestGetZoneConfig(t *testing.T) {
+	c := &Config{Zones: []ZoneConfig{{Name: "example.jp", AllowTransfer: []string{"192.0.2.0/24"}}}}
+	zone := c.GetZoneConfig("Example.JP.")
+	if len(zone.AllowTransfer) != 1 {
+		t.Errorf("zone config need to be matched by case insensitive FQDN")
+	}
+	zone = c.GetZoneConfig("example.com.")
+	if len(zone.AllowTransfer) != 0 {
+		t.Errorf("zone config of unknown zone need to be empty")
+	}
+	if zone.IsSecondary() {
+		t.Errorf("zone without primaries need to be primary zone")
+	}
+	c.Zones[0].Primaries = []string{"192.0.2.53"}
+	if !c.GetZoneConfig("example.jp").IsSecondary() {
+		t.Errorf("zone with primaries need to be secondary zone")
+	}
+}
+
+func TestQueryPolicy(t *testing.T) {
+	c := &Config{AnyResponse: "minimal", MetaQueries: "notimp", Zones: []ZoneConfig{{Name: "example.jp", AnyResponse: "hinfo", MetaQueries: "refused"}}}
+	if c.GetAnyResponse("example.jp.") != "hinfo" || c.GetMetaQueries("example.jp.") != "refused" {
+		t.Errorf("zone policies need to override global ones")
+	}
+	if c.GetAnyResponse("example.com.") != "minimal" || c.GetMetaQueries("example.com.") != "notimp" {
+		t.Errorf("global policies need to be used for zone without policies")
+	}
+}
+
+func TestIsAddress(t *testing.T) {
+	for _, addr := range []string{"192.0.2.1", "192.0.2.1:10053", "2001:db8::1", "[2001:db8::1]:53"} {
+		if !isAddress(addr) {
+			t.Errorf("%s is need to be valid address", addr)
+		}
+	}
+	for _, addr := range []string{"ns1.example.jp", "192.0.2.0/24", ""} {
+		if isAddress(addr) {
+			t.Errorf("%s is need to be invalid address", addr)
+		}
+	}
+}
+
+func TestTSIGSecrets(t *testing.T) {
+	f, err := ioutil.TempFile("", "tsig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("c2VjcmV0\n")
+	f.Close()
+
+	c := &Config{TSIGKeys: []TSIGKeyConfig{{Name: "Transfer.Key", Algorithm: "hmac-sha256", SecretFile: f.Name()}}}
+	if c.GetTSIGKey("transfer.key.") == nil {
+		t.Errorf("TSIG key need to be matched by case insensitive FQDN")
+	}
+	secrets, err := c.TSIGSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secrets["transfer.key."] != "c2VjcmV0" {
+		t.Errorf("secret need to be indexed by FQDN of key name: %v", secrets)
+	}
+	c.TSIGKeys[0].SecretFile = f.Name() + ".notfound"
+	if _, err := c.TSIGSecrets(); err == nil {
+		t.Errorf("missing secret file need to be error")
+	}
+}
@@ -15,7 +15,12 @@
 
 package misc
 
-import "strings"
+import (
+	"bytes"
+	"strings"
+
+	"github.com/miekg/dns"
+)
 
 func FQDN(dn string) string {
 	bs := []byte(dn)
@@ -34,6 +39,30 @@ func Labels(dn string) []string {
 	return labels[:len(labels)-1]
 }
 
+// IsDomainName checks dn is domain name in presentation format
+// whose labels and name are not too long.
 func IsDomainName(dn string) bool {
-	return false
+	if dn == "" {
+		return false
+	}
+	_, ok := dns.IsDomainName(dn)
+	return ok
+}
+
+// CanonicalCompare compares two domain names in canonical DNS name order (RFC 4034 section 6.1).
+func CanonicalCompare(a, b string) int {
+	al := dns.SplitDomainName(strings.ToLower(a))
+	bl := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(al)-1, len(bl)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := bytes.Compare(UnescapeLabel(al[i]), UnescapeLabel(bl[j])); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(al) < len(bl):
+		return -1
+	case len(al) > len(bl):
+		return 1
+	}
+	return 0
 }
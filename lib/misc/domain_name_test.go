@@ -15,7 +15,10 @@
 
 package misc
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestFQDN(t *testing.T) {
 	if FQDN("") != "." {
@@ -31,3 +34,42 @@ func TestFQDN(t *testing.T) {
 		t.Fatalf("example.jp.  FQDN is \"example.jp.\"")
 	}
 }
+
+func TestIsDomainName(t *testing.T) {
+	for _, dn := range []string{"example.jp", "mail.example.jp.", "."} {
+		if !IsDomainName(dn) {
+			t.Errorf("%s need to be domain name", dn)
+		}
+	}
+	for _, dn := range []string{"", "a..example.jp", strings.Repeat("a", 64) + ".example.jp."} {
+		if IsDomainName(dn) {
+			t.Errorf("%s need not to be domain name", dn)
+		}
+	}
+}
+
+func TestCanonicalCompare(t *testing.T) {
+	// RFC 4034 section 6.1 example
+	names := []string{
+		"example.",
+		"a.example.",
+		"yljkjljk.a.example.",
+		"Z.a.example.",
+		"zABC.a.EXAMPLE.",
+		"z.example.",
+		"\\001.z.example.",
+		"*.z.example.",
+		"\\200.z.example.",
+	}
+	for i := 0; i < len(names)-1; i++ {
+		if CanonicalCompare(names[i], names[i+1]) >= 0 {
+			t.Errorf("%s need to be sorted before %s", names[i], names[i+1])
+		}
+		if CanonicalCompare(names[i+1], names[i]) <= 0 {
+			t.Errorf("%s need to be sorted after %s", names[i+1], names[i])
+		}
+	}
+	if CanonicalCompare("WWW.example.jp.", "www.EXAMPLE.jp.") != 0 {
+		t.Errorf("canonical compare need to be case insensitive")
+	}
+}
@@ -15,6 +15,11 @@
 
 package misc
 
+import (
+	"net"
+	"strings"
+)
+
 func IpFamily(s string) int {
 	for i := 0; i < len(s); i++ {
 		switch s[i] {
@@ -26,3 +31,81 @@ func IpFamily(s string) int {
 	}
 	return 0
 }
+
+// AddrIP returns IP address of the peer address.
+func AddrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// MatchPrefix checks ip is matched to IP address or CIDR prefix.
+func MatchPrefix(ip net.IP, prefix string) bool {
+	if ip == nil {
+		return false
+	}
+	if _, network, err := net.ParseCIDR(prefix); err == nil {
+		return network.Contains(ip)
+	}
+	if addr := net.ParseIP(prefix); addr != nil {
+		return addr.Equal(ip)
+	}
+	return false
+}
+
+// HostPort appends default port to the address when it has no port.
+func HostPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+}
+
+// MaskIP returns the network address of ip with the prefix length of its family.
+func MaskIP(ip net.IP, v4Len, v6Len int) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(v4Len, 32))
+	}
+	return ip.Mask(net.CIDRMask(v6Len, 128))
+}
+
+// ForwardedIP returns the client address of HTTP request from X-Forwarded-For headers.
+// Addresses are trusted from the peer while they match the trusted proxies,
+// and the first untrusted one from the right is the client.
+func ForwardedIP(peer net.IP, forwardedFor []string, trusted []string) net.IP {
+	isTrusted := func(ip net.IP) bool {
+		for _, prefix := range trusted {
+			if MatchPrefix(ip, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	if !isTrusted(peer) {
+		return peer
+	}
+	addrs := []string{}
+	for _, header := range forwardedFor {
+		addrs = append(addrs, strings.Split(header, ",")...)
+	}
+	client := peer
+	for i := len(addrs) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(addrs[i]))
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !isTrusted(ip) {
+			break
+		}
+	}
+	return client
+}
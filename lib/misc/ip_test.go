@@ -0,0 +1,90 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package misc
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAddrIP(t *testing.T) {
+	udp := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+	if !AddrIP(udp).Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("ip of udp address is need to be 192.0.2.1")
+	}
+	tcp := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53}
+	if !AddrIP(tcp).Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("ip of tcp address is need to be 2001:db8::1")
+	}
+}
+
+func TestMatchPrefix(t *testing.T) {
+	ip := net.ParseIP("192.0.2.10")
+	if !MatchPrefix(ip, "192.0.2.0/24") {
+		t.Errorf("192.0.2.10 is need to match 192.0.2.0/24")
+	}
+	if MatchPrefix(ip, "198.51.100.0/24") {
+		t.Errorf("192.0.2.10 is need to not match 198.51.100.0/24")
+	}
+	if !MatchPrefix(ip, "192.0.2.10") {
+		t.Errorf("192.0.2.10 is need to match 192.0.2.10")
+	}
+	if MatchPrefix(nil, "0.0.0.0/0") {
+		t.Errorf("nil is need to not match any prefix")
+	}
+}
+
+func TestHostPort(t *testing.T) {
+	if HostPort("192.0.2.1", "53") != "192.0.2.1:53" {
+		t.Errorf("default port is need to be appended: %s", HostPort("192.0.2.1", "53"))
+	}
+	if HostPort("192.0.2.1:10053", "53") != "192.0.2.1:10053" {
+		t.Errorf("port is need to be kept: %s", HostPort("192.0.2.1:10053", "53"))
+	}
+	if HostPort("2001:db8::1", "53") != "[2001:db8::1]:53" {
+		t.Errorf("ipv6 address is need to be bracketed: %s", HostPort("2001:db8::1", "53"))
+	}
+}
+
+func TestMaskIP(t *testing.T) {
+	if ip := MaskIP(net.ParseIP("192.0.2.10"), 24, 56); ip.String() != "192.0.2.0" {
+		t.Errorf("192.0.2.10/24 is need to be 192.0.2.0: %s", ip)
+	}
+	if ip := MaskIP(net.ParseIP("2001:db8:1:2ff::1"), 24, 56); ip.String() != "2001:db8:1:200::" {
+		t.Errorf("2001:db8:1:2ff::1/56 is need to be 2001:db8:1:200::: %s", ip)
+	}
+}
+
+func TestForwardedIP(t *testing.T) {
+	trusted := []string{"127.0.0.1", "10.0.0.0/8"}
+	testcases := []struct {
+		peer         string
+		forwardedFor []string
+		client       string
+	}{
+		{"192.0.2.1", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"127.0.0.1", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"127.0.0.1", []string{"203.0.113.1, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"127.0.0.1", []string{"203.0.113.1", "10.0.0.2"}, "203.0.113.1"},
+		{"127.0.0.1", []string{"unknown, 10.0.0.2"}, "10.0.0.2"},
+		{"127.0.0.1", nil, "127.0.0.1"},
+	}
+	for _, tc := range testcases {
+		if ip := ForwardedIP(net.ParseIP(tc.peer), tc.forwardedFor, trusted); ip.String() != tc.client {
+			t.Errorf("client of %s %v is need to be %s: %s", tc.peer, tc.forwardedFor, tc.client, ip)
+		}
+	}
+}
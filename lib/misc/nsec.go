@@ -0,0 +1,140 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package misc
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// TypeNXNAME is used by compact denial of existence to signal non-existent name.
+const TypeNXNAME = 128
+
+const maxLabelLength = 63
+const maxNameLength = 255
+
+// NSECTypes returns the type bitmap of node for NSEC.
+// DYN* private types are replaced by static types which they generate.
+func (t *Tree) NSECTypes() []uint16 {
+	exist := map[uint16]bool{
+		dns.TypeRRSIG: true,
+		dns.TypeNSEC:  true,
+	}
+	for rrtype := range t.Resources {
+		if static, ok := DynamicStaticMap[rrtype]; ok {
+			exist[static] = true
+		} else {
+			exist[rrtype] = true
+		}
+	}
+	types := []uint16{}
+	for rrtype := range exist {
+		types = append(types, rrtype)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// UnescapeLabel converts presentation format label into wire octets.
+func UnescapeLabel(label string) []byte {
+	b := []byte{}
+	for i := 0; i < len(label); i++ {
+		if label[i] == '\\' && i+1 < len(label) {
+			if i+3 < len(label) && isDigit(label[i+1]) && isDigit(label[i+2]) && isDigit(label[i+3]) {
+				n, _ := strconv.Atoi(label[i+1 : i+4])
+				b = append(b, byte(n))
+				i += 3
+			} else {
+				b = append(b, label[i+1])
+				i++
+			}
+			continue
+		}
+		b = append(b, label[i])
+	}
+	return b
+}
+
+// EscapeLabel converts wire octets into presentation format label.
+func EscapeLabel(b []byte) string {
+	var s strings.Builder
+	for _, c := range b {
+		switch {
+		case c == '.' || c == '\\' || c == '(' || c == ')' || c == ';' || c == ' ' || c == '@' || c == '"':
+			s.WriteByte('\\')
+			s.WriteByte(c)
+		case c < '!' || c > '~':
+			s.WriteString("\\" + leftPad(strconv.Itoa(int(c))))
+		default:
+			s.WriteByte(c)
+		}
+	}
+	return s.String()
+}
+
+// SuccessorName returns the immediate successor of name in canonical order (RFC 4471).
+func SuccessorName(name string) string {
+	return "\\000." + strings.ToLower(FQDN(name))
+}
+
+// PredecessorName returns a name which sorts just before name and after every
+// name which sorts before name in the same zone (RFC 4470 section 5).
+func PredecessorName(name, zoneName string) string {
+	name = strings.ToLower(FQDN(name))
+	if name == strings.ToLower(FQDN(zoneName)) {
+		return name
+	}
+	labels := dns.SplitDomainName(name)
+	parent := FQDN(strings.Join(labels[1:], "."))
+	first := UnescapeLabel(labels[0])
+	last := first[len(first)-1]
+	if last == 0 {
+		if len(first) == 1 {
+			return parent
+		}
+		return EscapeLabel(first[:len(first)-1]) + "." + parent
+	}
+	first[len(first)-1] = last - 1
+	parentLength := nameLength(parent)
+	for len(first) < maxLabelLength && 1+len(first)+1+parentLength <= maxNameLength {
+		first = append(first, 0xff)
+	}
+	return EscapeLabel(first) + "." + parent
+}
+
+// nameLength returns length of name in wire format.
+func nameLength(name string) int {
+	buf := make([]byte, maxNameLength+1)
+	n, err := dns.PackDomainName(name, buf, 0, nil, false)
+	if err != nil {
+		return maxNameLength
+	}
+	return n
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func leftPad(s string) string {
+	for len(s) < 3 {
+		s = "0" + s
+	}
+	return s
+}
@@ -0,0 +1,75 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package misc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSuccessorName(t *testing.T) {
+	if SuccessorName("WWW.example.jp") != "\\000.www.example.jp." {
+		t.Errorf("successor of www.example.jp. is need to be \\000.www.example.jp.")
+	}
+}
+
+func TestPredecessorName(t *testing.T) {
+	pre := PredecessorName("www.example.jp.", "example.jp.")
+	if !strings.HasPrefix(pre, "wwv\\255") || !strings.HasSuffix(pre, ".example.jp.") {
+		t.Errorf("predecessor of www.example.jp. is invalid: %s", pre)
+	}
+	if len(UnescapeLabel(dns.SplitDomainName(pre)[0])) != 63 {
+		t.Errorf("predecessor label need to be filled up to 63 octets: %s", pre)
+	}
+	if PredecessorName("\\000.www.example.jp.", "example.jp.") != "www.example.jp." {
+		t.Errorf("predecessor of \\000.www.example.jp. is need to be www.example.jp.")
+	}
+	if PredecessorName("example.jp.", "example.jp.") != "example.jp." {
+		t.Errorf("predecessor of zone apex is need to be zone apex")
+	}
+	if _, ok := dns.IsDomainName(PredecessorName("www.example.jp.", "example.jp.")); !ok {
+		t.Errorf("predecessor need to be valid domain name")
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if EscapeLabel([]byte{'a', 0, '.', 0xff}) != "a\\000\\.\\255" {
+		t.Errorf("escape label error: %s", EscapeLabel([]byte{'a', 0, '.', 0xff}))
+	}
+	if string(UnescapeLabel("a\\000\\.\\255")) != string([]byte{'a', 0, '.', 0xff}) {
+		t.Errorf("unescape label error")
+	}
+}
+
+func TestNSECTypes(t *testing.T) {
+	root := NewTree()
+	rr, _ := dns.NewRR("www.example.jp. 300 IN AAAA 2001:db8::1")
+	node := root.AddRR(rr)
+	dyn, _ := dns.NewRR("www.example.jp. 300 IN DYNA service")
+	node = root.AddRR(dyn)
+	types := node.NSECTypes()
+	expect := []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeRRSIG, dns.TypeNSEC}
+	if len(types) != len(expect) {
+		t.Fatalf("type bitmap error: %v", types)
+	}
+	for i := range expect {
+		if types[i] != expect[i] {
+			t.Errorf("type bitmap error: %v", types)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package misc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrProxyHeader = errors.New("invalid PROXY protocol header")
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maximum length of PROXY protocol v1 header
+const proxyV1MaxLength = 107
+
+// ReadProxyHeader reads PROXY protocol v1 or v2 header from the stream,
+// and returns the source address. It returns nil for LOCAL and UNKNOWN connections.
+func ReadProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		return readProxyV1(r)
+	case proxyV2Signature[0]:
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(header[:12], proxyV2Signature) {
+			return nil, ErrProxyHeader
+		}
+		body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+		addr, _, err := ParseProxyHeader(append(header, body...))
+		return addr, err
+	}
+	return nil, ErrProxyHeader
+}
+
+// readProxyV1 reads the human readable header, such as "PROXY TCP4 192.0.2.1 192.0.2.2 10053 53\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	line := []byte{}
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLength {
+			return nil, ErrProxyHeader
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, ErrProxyHeader
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, ErrProxyHeader
+	}
+	if len(fields) != 6 {
+		return nil, ErrProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, ErrProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// ParseProxyHeader parses PROXY protocol v2 header at the head of the buffer,
+// and returns the source address and the length of the header.
+// The address is nil for LOCAL command and unspecified address family.
+func ParseProxyHeader(buf []byte) (net.Addr, int, error) {
+	if len(buf) < 16 || !bytes.Equal(buf[:12], proxyV2Signature) || buf[12]>>4 != 2 {
+		return nil, 0, ErrProxyHeader
+	}
+	length := 16 + int(binary.BigEndian.Uint16(buf[14:16]))
+	if len(buf) < length {
+		return nil, 0, ErrProxyHeader
+	}
+	switch buf[12] & 0xF {
+	case 0:
+		// LOCAL command, such as health check of the proxy
+		return nil, length, nil
+	case 1:
+	default:
+		return nil, 0, ErrProxyHeader
+	}
+	body := buf[16:length]
+	var ip net.IP
+	var port uint16
+	switch buf[13] >> 4 {
+	case 1:
+		if len(body) < 12 {
+			return nil, 0, ErrProxyHeader
+		}
+		ip = net.IP(append([]byte{}, body[0:4]...))
+		port = binary.BigEndian.Uint16(body[8:10])
+	case 2:
+		if len(body) < 36 {
+			return nil, 0, ErrProxyHeader
+		}
+		ip = net.IP(append([]byte{}, body[0:16]...))
+		port = binary.BigEndian.Uint16(body[32:34])
+	default:
+		return nil, length, nil
+	}
+	if buf[13]&0xF == 2 {
+		return &net.UDPAddr{IP: ip, Port: int(port)}, length, nil
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, length, nil
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package misc
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func proxyV2Header(command, family byte, addrs []byte) []byte {
+	buf := append([]byte{}, proxyV2Signature...)
+	buf = append(buf, 0x20|command, family, 0, byte(len(addrs)))
+	return append(buf, addrs...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	r := bufio.NewReader(bytes.NewBufferString("PROXY TCP4 192.0.2.1 198.51.100.1 10053 53\r\nquery"))
+	addr, err := ReadProxyHeader(r)
+	if err != nil || addr.String() != "192.0.2.1:10053" {
+		t.Errorf("source address of v1 header is need to be 192.0.2.1:10053: %v %v", addr, err)
+	}
+	if rest, _ := ioutil.ReadAll(r); string(rest) != "query" {
+		t.Errorf("data after header is need to be kept: %s", rest)
+	}
+	addr, err = ReadProxyHeader(bufio.NewReader(bytes.NewBufferString("PROXY UNKNOWN\r\n")))
+	if err != nil || addr != nil {
+		t.Errorf("address of UNKNOWN is need to be nil: %v %v", addr, err)
+	}
+	v4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0x27, 0x45, 0, 53}
+	addr, err = ReadProxyHeader(bufio.NewReader(bytes.NewBuffer(proxyV2Header(1, 0x11, v4))))
+	if err != nil || addr.String() != "192.0.2.1:10053" {
+		t.Errorf("source address of v2 header is need to be 192.0.2.1:10053: %v %v", addr, err)
+	}
+	for _, header := range []string{"GET / HTTP/1.1\r\n", "PROXY TCP4 192.0.2.1\r\n", "\r\n\r\n\x00\r\nQUIX\n\x21\x11\x00\x00"} {
+		if _, err := ReadProxyHeader(bufio.NewReader(bytes.NewBufferString(header))); err == nil {
+			t.Errorf("%q is need to be invalid header", header)
+		}
+	}
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	v6 := append(append(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::53")...), 0x27, 0x45, 0, 53)
+	buf := append(proxyV2Header(1, 0x22, v6), "query"...)
+	addr, n, err := ParseProxyHeader(buf)
+	if err != nil || addr.String() != "[2001:db8::1]:10053" {
+		t.Errorf("source address is need to be [2001:db8::1]:10053: %v %v", addr, err)
+	}
+	if _, ok := addr.(*net.UDPAddr); !ok {
+		t.Errorf("source address of DGRAM is need to be UDP address: %T", addr)
+	}
+	if string(buf[n:]) != "query" {
+		t.Errorf("header length is need to be %d: %d", len(buf)-5, n)
+	}
+	if addr, _, err := ParseProxyHeader(proxyV2Header(0, 0, nil)); err != nil || addr != nil {
+		t.Errorf("address of LOCAL command is need to be nil: %v %v", addr, err)
+	}
+	if _, _, err := ParseProxyHeader(proxyV2Header(1, 0x12, []byte{192, 0, 2})); err == nil {
+		t.Errorf("short address is need to be invalid")
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package misc
+
+import "testing"
+
+func TestSerialLess(t *testing.T) {
+	if !SerialLess(1, 2) {
+		t.Errorf("1 is need to be less than 2")
+	}
+	if SerialLess(2, 1) || SerialLess(1, 1) {
+		t.Errorf("2 and 1 is need to be not less than 1")
+	}
+	if !SerialLess(4294967295, 0) {
+		t.Errorf("serial number need to wrap around")
+	}
+	if SerialLess(0, 4294967295) {
+		t.Errorf("0 is need to be greater than 4294967295")
+	}
+}
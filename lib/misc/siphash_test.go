@@ -0,0 +1,36 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package misc
+
+import "testing"
+
+func TestSipHash24(t *testing.T) {
+	// test vector of the SipHash paper
+	var key [16]byte
+	data := make([]byte, 15)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if h := SipHash24(key, data); h != 0xa129ca6149be45e5 {
+		t.Errorf("SipHash-2-4 error: %x", h)
+	}
+	if SipHash24(key, []byte{}) != 0x726fdb47dd0e0e31 {
+		t.Errorf("SipHash-2-4 of empty data error: %x", SipHash24(key, []byte{}))
+	}
+}
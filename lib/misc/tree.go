@@ -17,6 +17,8 @@ package misc
 
 import (
 	"errors"
+	"sort"
+	"sync"
 
 	"github.com/miekg/dns"
 )
@@ -31,7 +33,11 @@ type Tree struct {
 	Parent     *Tree
 	Children   map[string]*Tree
 	Resources  map[uint16][]dns.RR
+	Signatures map[uint16][]dns.RR
 	Auth       bool
+	sigMutex   sync.RWMutex
+	// Paramaters are replaced by zone loading while queries are served
+	paramMutex sync.RWMutex
 }
 
 func NewTree() *Tree {
@@ -39,6 +45,7 @@ func NewTree() *Tree {
 		Paramaters: map[string]interface{}{},
 		Children:   map[string]*Tree{},
 		Resources:  map[uint16][]dns.RR{},
+		Signatures: map[uint16][]dns.RR{},
 		Auth:       false,
 	}
 }
@@ -60,6 +67,7 @@ func (t *Tree) AddNode(labels []string) *Tree {
 			Paramaters: map[string]interface{}{},
 			Children:   map[string]*Tree{},
 			Resources:  map[uint16][]dns.RR{},
+			Signatures: map[uint16][]dns.RR{},
 			Auth:       t.Auth,
 		}
 		t.Children[last] = child
@@ -83,6 +91,31 @@ func (t *Tree) SearchNode(labels []string, strict bool) *Tree {
 	return t
 }
 
+// ClosestEncloser returns the deepest existing node among the name and its ancestors
+// (RFC 4592 section 3.3.1), and whether the node is the name itself.
+// Nodes which don't exist, such as nodes left without RRs, are skipped.
+func (t *Tree) ClosestEncloser(labels []string) (*Tree, bool) {
+	if len(labels) == 0 {
+		return t, true
+	}
+
+	last := labels[len(labels)-1]
+	labels = labels[:len(labels)-1]
+	if v, ok := t.Children[last]; ok && v.Exists() {
+		return v.ClosestEncloser(labels)
+	}
+	return t, false
+}
+
+// Wildcard returns the wildcard child of the node which is source of synthesis,
+// or nil when it doesn't exist.
+func (t *Tree) Wildcard() *Tree {
+	if v, ok := t.Children["*"]; ok && v.Exists() {
+		return v
+	}
+	return nil
+}
+
 func (t *Tree) DeleteNode(labels []string, force bool) error {
 	last := labels[len(labels)-1]
 	labels = labels[:len(labels)-1]
@@ -100,20 +133,74 @@ func (t *Tree) DeleteNode(labels []string, force bool) error {
 	return nil
 }
 
+// Exists reports whether the name of the node exists (RFC 4592 section 2.2.2).
+// The node exists when it owns RRs, or it is an empty non-terminal
+// which has descendants owning RRs.
+func (t *Tree) Exists() bool {
+	if len(t.Resources) > 0 {
+		return true
+	}
+	for _, child := range t.Children {
+		if child.Exists() {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEmptyNonTerminal reports whether the node owns no RR but has descendants owning RRs.
+func (t *Tree) IsEmptyNonTerminal() bool {
+	return len(t.Resources) == 0 && t.Exists()
+}
+
+// Walk calls f for the node and all descendants in depth first order.
+func (t *Tree) Walk(f func(node *Tree)) {
+	f(t)
+	labels := make([]string, 0, len(t.Children))
+	for label := range t.Children {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		t.Children[label].Walk(f)
+	}
+}
+
 func (t *Tree) Set(name string, value interface{}) {
+	t.paramMutex.Lock()
 	t.Paramaters[name] = value
+	t.paramMutex.Unlock()
 }
 func (t *Tree) Get(name string) (interface{}, bool) {
+	t.paramMutex.RLock()
 	v, ok := t.Paramaters[name]
+	t.paramMutex.RUnlock()
 	return v, ok
 }
 func (t *Tree) Delete(name string) {
+	t.paramMutex.Lock()
 	delete(t.Paramaters, name)
+	t.paramMutex.Unlock()
 }
 func (t *Tree) DeleteAll() {
+	t.paramMutex.Lock()
 	for name, _ := range t.Paramaters {
 		delete(t.Paramaters, name)
 	}
+	t.paramMutex.Unlock()
+}
+
+// Replace sets and deletes parameters at once,
+// so that readers don't see a part of the changes.
+func (t *Tree) Replace(values map[string]interface{}, deletes ...string) {
+	t.paramMutex.Lock()
+	for _, name := range deletes {
+		delete(t.Paramaters, name)
+	}
+	for name, value := range values {
+		t.Paramaters[name] = value
+	}
+	t.paramMutex.Unlock()
 }
 
 // for RR
@@ -136,3 +223,26 @@ func (t *Tree) GetRR(rrType uint16) ([]dns.RR, bool) {
 func (t *Tree) DeleteRR(rrType uint16, rr dns.RR) {
 	delete(t.Resources, rrType)
 }
+
+// for RRSIG, indexed by covered type
+func (t *Tree) AddSig(sig *dns.RRSIG) *Tree {
+	labels := Labels(sig.Header().Name)
+	sigNode := t.AddNode(labels)
+	sigNode.sigMutex.Lock()
+	sigNode.Signatures[sig.TypeCovered] = append(sigNode.Signatures[sig.TypeCovered], sig)
+	sigNode.sigMutex.Unlock()
+	return sigNode
+}
+
+func (t *Tree) SetSig(covered uint16, sigs []dns.RR) {
+	t.sigMutex.Lock()
+	t.Signatures[covered] = sigs
+	t.sigMutex.Unlock()
+}
+
+func (t *Tree) GetSig(covered uint16) ([]dns.RR, bool) {
+	t.sigMutex.RLock()
+	v, ok := t.Signatures[covered]
+	t.sigMutex.RUnlock()
+	return v, ok
+}
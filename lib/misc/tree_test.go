@@ -69,6 +69,122 @@ func TestTreeAddNode(t *testing.T) {
 
 }
 
+func TestTreeReplace(t *testing.T) {
+	node := NewTree()
+	node.Set("DNSSEC", "keys")
+	node.Set("ZoneTree", "old")
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			node.Replace(map[string]interface{}{"ZoneTree": i}, "DNSSEC")
+			node.Set("DNSSEC", "keys")
+		}
+		close(done)
+	}()
+	for i := 0; i < 1000; i++ {
+		node.Get("ZoneTree")
+		node.Get("DNSSEC")
+	}
+	<-done
+
+	node.Replace(map[string]interface{}{"ZoneTree": "new", "state": 1}, "DNSSEC")
+	if v, _ := node.Get("ZoneTree"); v != "new" {
+		t.Errorf("ZoneTree need to be replaced")
+	}
+	if _, ok := node.Get("state"); !ok {
+		t.Errorf("state need to be set")
+	}
+	if _, ok := node.Get("DNSSEC"); ok {
+		t.Errorf("DNSSEC need to be deleted")
+	}
+}
+
+func TestTreeWalk(t *testing.T) {
+	root := NewTree()
+	root.AddNode([]string{"www", "example", "jp"})
+	root.AddNode([]string{"mail", "example", "jp"})
+	labels := []string{}
+	root.Walk(func(node *Tree) {
+		labels = append(labels, node.Label)
+	})
+	expect := []string{"", "jp.", "example.jp.", "mail.example.jp.", "www.example.jp."}
+	if len(labels) != len(expect) {
+		t.Fatalf("walk need to visit all nodes: %v", labels)
+	}
+	for i := range expect {
+		if labels[i] != expect[i] {
+			t.Errorf("walk order error: %v", labels)
+		}
+	}
+}
+
+func TestTreeExists(t *testing.T) {
+	root := NewTree()
+	rr, _ := dns.NewRR("a.sub.example.jp. 300 IN A 192.0.2.1")
+	root.AddRR(rr)
+	root.AddNode([]string{"empty", "example", "jp"})
+
+	testcases := []struct {
+		labels []string
+		exist  bool
+		ent    bool
+	}{
+		{[]string{"a", "sub", "example", "jp"}, true, false},
+		{[]string{"sub", "example", "jp"}, true, true},
+		{[]string{"example", "jp"}, true, true},
+		{[]string{"empty", "example", "jp"}, false, false},
+	}
+	for _, tc := range testcases {
+		node := root.SearchNode(tc.labels, true)
+		if node == nil {
+			t.Fatalf("node %v is not found", tc.labels)
+		}
+		if node.Exists() != tc.exist {
+			t.Errorf("%s: Exists need to be %v", node.Label, tc.exist)
+		}
+		if node.IsEmptyNonTerminal() != tc.ent {
+			t.Errorf("%s: IsEmptyNonTerminal need to be %v", node.Label, tc.ent)
+		}
+	}
+}
+
+func TestTreeClosestEncloser(t *testing.T) {
+	root := NewTree()
+	for _, s := range []string{
+		"*.example.jp. 300 IN A 192.0.2.1",
+		"a.sub.example.jp. 300 IN A 192.0.2.2",
+		"*.sub.example.jp. 300 IN A 192.0.2.3",
+	} {
+		rr, _ := dns.NewRR(s)
+		root.AddRR(rr)
+	}
+	root.AddNode([]string{"empty", "example", "jp"})
+
+	testcases := []struct {
+		name     string
+		ce       string
+		exact    bool
+		wildcard bool
+	}{
+		{"a.sub.example.jp.", "a.sub.example.jp.", true, false},
+		{"sub.example.jp.", "sub.example.jp.", true, true},
+		{"b.a.example.jp.", "example.jp.", false, true},
+		{"b.sub.example.jp.", "sub.example.jp.", false, true},
+		{"b.a.sub.example.jp.", "a.sub.example.jp.", false, false},
+		{"x.empty.example.jp.", "example.jp.", false, true},
+	}
+	for _, tc := range testcases {
+		ce, exact := root.ClosestEncloser(Labels(tc.name))
+		if ce.Label != tc.ce || exact != tc.exact {
+			t.Errorf("%s: closest encloser need to be %s(%v), but %s(%v)", tc.name, tc.ce, tc.exact, ce.Label, exact)
+		}
+		if (ce.Wildcard() != nil) != tc.wildcard {
+			t.Errorf("%s: wildcard of %s need to be %v", tc.name, ce.Label, tc.wildcard)
+		}
+	}
+}
+
 func TestingLoadZoneFile(t *testing.T) {
 	var zoneData = `$ORIGIN www.example.com.
 $TTL 300
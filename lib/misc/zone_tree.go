@@ -61,11 +61,10 @@ func (t *Tree) VerifyNode() error {
 			if len(cname) > 1 {
 				return ErrVerifyNodeDupulicateCNAME
 			}
-			if len(t.Resources) > 2 {
-				return ErrVerifyNodeOtherRRInCNAMENode
-			}
 			for rrtype := range t.Resources {
-				if rrtype != dns.TypeCNAME && rrtype != dns.TypeDNAME {
+				switch rrtype {
+				case dns.TypeCNAME, dns.TypeDNAME, dns.TypeNSEC:
+				default:
 					return ErrVerifyNodeOtherRRInCNAMENode
 				}
 			}
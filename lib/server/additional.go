@@ -0,0 +1,145 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/miekg/dns"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
+)
+
+// SVCB and HTTPS types (RFC 9460), which are read as unknown types (RFC 3597)
+// since miekg/dns doesn't support them.
+const (
+	typeSVCB  uint16 = 64
+	typeHTTPS uint16 = 65
+)
+
+// room for OPT with cookie which is added when the response is written
+const optReserve = 11 + 4 + 40
+
+// additionalTarget returns the name whose addresses are useful for the RR.
+func additionalTarget(rr dns.RR) (string, bool) {
+	switch rr := rr.(type) {
+	case *dns.NS:
+		return rr.Ns, true
+	case *dns.MX:
+		return rr.Mx, rr.Mx != "."
+	case *dns.SRV:
+		return rr.Target, rr.Target != "."
+	case *dns.RFC3597:
+		switch rr.Hdr.Rrtype {
+		case typeSVCB, typeHTTPS:
+			return svcbTarget(rr)
+		}
+	}
+	return "", false
+}
+
+// svcbTarget returns TargetName of SVCB or HTTPS RR in RFC 3597 format.
+// "." of ServiceMode means the owner name (RFC 9460 section 2.5).
+func svcbTarget(rr *dns.RFC3597) (string, bool) {
+	rdata, err := hex.DecodeString(rr.Rdata)
+	if err != nil || len(rdata) < 3 {
+		return "", false
+	}
+	target, _, err := dns.UnpackDomainName(rdata, 2)
+	if err != nil {
+		return "", false
+	}
+	if target == "." {
+		if rdata[0] == 0 && rdata[1] == 0 {
+			// AliasMode with "." means the service doesn't exist
+			return "", false
+		}
+		return rr.Hdr.Name, true
+	}
+	return target, true
+}
+
+// compressedLen returns the length of the message in wire format with name compression.
+func compressedLen(m *dns.Msg) int {
+	compress := m.Compress
+	m.Compress = true
+	l := m.Len()
+	m.Compress = compress
+	return l
+}
+
+// inSection reports whether the section has RR of the name and type.
+func inSection(section []dns.RR, name string, rrtype uint16) bool {
+	for _, rr := range section {
+		if rr.Header().Rrtype == rrtype && strings.EqualFold(rr.Header().Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// addAdditional adds A and AAAA RRs of names which are targets of NS, MX, SRV,
+// SVCB and HTTPS RRs in answer and authority sections, including RRs generated
+// by DYN*, into additional section (RFC 1034 section 4.3.2 step 6).
+// Addresses are taken from authoritative data of the zone, or glue for NS.
+// Glue of NS in authority section is always added so that truncation of
+// referral sets TC bit, other addresses are added while the response
+// fits in the payload size of UDP.
+func (s *worker) addAdditional(w dns.ResponseWriter, m *dns.Msg, req *dns.Msg, zoneName string, zoneTree *Tree) {
+	budget := 0
+	if s.listener.Net == "udp" {
+		budget = s.udpSize(req) - optReserve
+	}
+	added := map[string]bool{}
+	add := func(rr dns.RR, glue bool) {
+		target, ok := additionalTarget(rr)
+		if !ok || !dns.IsSubDomain(zoneName, target) || added[strings.ToLower(target)] {
+			return
+		}
+		added[strings.ToLower(target)] = true
+		node, exact := zoneTree.ClosestEncloser(Labels(target))
+		if !exact || (!glue && !node.Auth) {
+			return
+		}
+		for _, rrtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			if inSection(m.Answer, target, rrtype) {
+				continue
+			}
+			rrs, ok := node.GetRR(rrtype)
+			if !ok {
+				if rrs, _, _ = s.dynamicRRs(w, req, node, target, rrtype); len(rrs) == 0 {
+					continue
+				}
+			}
+			if !glue && budget > 0 {
+				extra := m.Extra
+				m.Extra = append(m.Extra[:len(extra):len(extra)], rrs...)
+				fit := compressedLen(m) <= budget
+				m.Extra = extra
+				if !fit {
+					continue
+				}
+			}
+			m.Extra = append(m.Extra, rrs...)
+		}
+	}
+	for _, rr := range m.Answer {
+		add(rr, false)
+	}
+	for _, rr := range m.Ns {
+		add(rr, true)
+	}
+}
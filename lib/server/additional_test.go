@@ -0,0 +1,156 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// svcbRdata returns RDATA of SVCB or HTTPS RR in RFC 3597 format without SvcParams.
+func svcbRdata(priority uint16, target string) string {
+	buf := make([]byte, 2+255)
+	buf[0], buf[1] = byte(priority>>8), byte(priority)
+	off, err := dns.PackDomainName(target, buf, 2, nil, false)
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("\\# %d %s", off, hex.EncodeToString(buf[:off]))
+}
+
+func TestAdditional(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.config.MinimumResponse = false
+	s.addService("dyn-a", dns.TypeA, "dynhost.example.jp. 60 IN A 192.0.2.100")
+	zone := `
+example.jp. 3600 IN SOA ns1.example.jp. root.example.jp. 1 3600 900 1814400 300
+example.jp. 3600 IN NS ns1.example.jp.
+ns1.example.jp. 300 IN A 192.0.2.53
+ns1.example.jp. 300 IN AAAA 2001:db8::53
+mail.example.jp. 300 IN MX 10 mx1.example.jp.
+mail.example.jp. 300 IN MX 20 mx.example.com.
+mx1.example.jp. 300 IN A 192.0.2.25
+null.example.jp. 300 IN MX 0 .
+_sip._udp.example.jp. 300 IN SRV 0 0 5060 sip.example.jp.
+sip.example.jp. 300 IN AAAA 2001:db8::5060
+dynmx.example.jp. 300 IN MX 10 dynhost.example.jp.
+dynhost.example.jp. 300 IN DYNA dyn-a
+svc.example.jp. 300 IN TYPE65 ` + svcbRdata(1, "svc1.example.jp.") + `
+svc1.example.jp. 300 IN A 192.0.2.80
+self.example.jp. 300 IN TYPE65 ` + svcbRdata(1, ".") + `
+self.example.jp. 300 IN A 192.0.2.81
+sub.example.jp. 3600 IN NS ns.sub.example.jp.
+sub.example.jp. 3600 IN NS ns.example.com.
+ns.sub.example.jp. 3600 IN A 192.0.2.54
+`
+	for i := 0; i < 10; i++ {
+		zone += fmt.Sprintf("many.example.jp. 300 IN MX 10 mx%d.example.jp.\n", i+10)
+		zone += fmt.Sprintf("mx%d.example.jp. 300 IN A 192.0.2.%d\n", i+10, i+10)
+		zone += fmt.Sprintf("mx%d.example.jp. 300 IN AAAA 2001:db8::%d\n", i+10, i+10)
+	}
+	s.loadZone(t, "example.jp.", zone)
+	w := s.worker("udp")
+	nsAddrs := []string{"ns1.example.jp. A 192.0.2.53", "ns1.example.jp. AAAA 2001:db8::53"}
+
+	runQueryTests(t, w, []queryTest{
+		{
+			// addresses of out of zone target and "." are not added
+			qname: "mail.example.jp.", qtype: dns.TypeMX, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"mail.example.jp. MX 10 mx1.example.jp.", "mail.example.jp. MX 20 mx.example.com."},
+			ns:     []string{"example.jp. NS ns1.example.jp."},
+			extra:  append([]string{"mx1.example.jp. A 192.0.2.25"}, nsAddrs...),
+		},
+		{
+			qname: "null.example.jp.", qtype: dns.TypeMX, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"null.example.jp. MX 0 ."},
+			ns:     []string{"example.jp. NS ns1.example.jp."},
+			extra:  nsAddrs,
+		},
+		{
+			qname: "_sip._udp.example.jp.", qtype: dns.TypeSRV, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"_sip._udp.example.jp. SRV 0 0 5060 sip.example.jp."},
+			ns:     []string{"example.jp. NS ns1.example.jp."},
+			extra:  append([]string{"sip.example.jp. AAAA 2001:db8::5060"}, nsAddrs...),
+		},
+		{
+			// target generated by DYN*
+			qname: "dynmx.example.jp.", qtype: dns.TypeMX, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"dynmx.example.jp. MX 10 dynhost.example.jp."},
+			ns:     []string{"example.jp. NS ns1.example.jp."},
+			extra:  append([]string{"dynhost.example.jp. A 192.0.2.100"}, nsAddrs...),
+		},
+		{
+			qname: "svc.example.jp.", qtype: 65, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"svc.example.jp. TYPE65"},
+			ns:     []string{"example.jp. NS ns1.example.jp."},
+			extra:  append([]string{"svc1.example.jp. A 192.0.2.80"}, nsAddrs...),
+		},
+		{
+			// "." of ServiceMode is the owner name
+			qname: "self.example.jp.", qtype: 65, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"self.example.jp. TYPE65"},
+			ns:     []string{"example.jp. NS ns1.example.jp."},
+			extra:  append([]string{"self.example.jp. A 192.0.2.81"}, nsAddrs...),
+		},
+		{
+			// addresses in answer section are not repeated
+			qname: "ns1.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"ns1.example.jp. A 192.0.2.53"},
+			ns:     []string{"example.jp. NS ns1.example.jp."},
+			extra:  []string{"ns1.example.jp. AAAA 2001:db8::53"},
+		},
+		{
+			// glue of referral
+			qname: "www.sub.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: false,
+			ns:    []string{"sub.example.jp. NS ns.sub.example.jp.", "sub.example.jp. NS ns.example.com."},
+			extra: []string{"ns.sub.example.jp. A 192.0.2.54"},
+		},
+	})
+
+	// additional data is added while it fits in the payload size without TC bit
+	for _, size := range []uint16{0, 1232} {
+		req := new(dns.Msg)
+		req.SetQuestion("many.example.jp.", dns.TypeMX)
+		limit := dns.MinMsgSize
+		if size > 0 {
+			req.SetEdns0(size, false)
+			limit = int(size)
+		}
+		tw := &testWriter{}
+		w.ServeDNS(tw, req)
+		m := tw.msg
+		if m.Truncated || len(m.Answer) != 10 {
+			t.Errorf("size %d: answer need to be complete without TC bit: %d answers", size, len(m.Answer))
+		}
+		if len(m.Extra) < 2 || (size == 0 && len(m.Extra) >= 10*2+2) {
+			t.Errorf("size %d: addresses need to be added within the payload size: %v", size, m.Extra)
+		}
+		if l := m.Len(); l > limit {
+			t.Errorf("size %d: response need to fit in %d octets, but %d", size, limit, l)
+		}
+	}
+	tw := &testWriter{}
+	req := new(dns.Msg)
+	req.SetQuestion("many.example.jp.", dns.TypeMX)
+	s.worker("tcp").ServeDNS(tw, req)
+	if len(tw.msg.Extra) != 10*2+2 {
+		t.Errorf("all addresses need to be added over TCP, but %d", len(tw.msg.Extra))
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rabbitdns/rabbitdns/lib/config"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrCookieFormat = errors.New("COOKIE option is invalid format.")
+)
+
+const (
+	cookieVersion    = 1
+	clientCookieSize = 8
+	serverCookieSize = 16
+	// validity of server cookie timestamp (RFC 9018 section 4.3)
+	cookieExpire = 3600
+	cookieFuture = 300
+)
+
+// cookieManager makes and validates server cookies (RFC 7873, RFC 9018)
+// by the secret shared across workers.
+// The secret is rotated periodically, and cookies made by the previous secret are still valid.
+type cookieManager struct {
+	config   *config.Config
+	mutex    sync.RWMutex
+	secret   [16]byte
+	previous [16]byte
+	rotated  time.Time
+}
+
+func NewCookieManager(c *config.Config) (*cookieManager, error) {
+	m := &cookieManager{config: c}
+	if err := m.rotate(time.Now()); err != nil {
+		return nil, err
+	}
+	m.previous = m.secret
+	return m, nil
+}
+
+func (m *cookieManager) rotate(now time.Time) error {
+	var secret [16]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return err
+	}
+	m.previous = m.secret
+	m.secret = secret
+	m.rotated = now
+	return nil
+}
+
+// secrets returns the current and the previous secrets, and rotates them when it is the time.
+func (m *cookieManager) secrets(now time.Time) ([16]byte, [16]byte) {
+	rotation := time.Duration(m.config.CookieRotation) * time.Second
+	m.mutex.RLock()
+	due := now.Sub(m.rotated) >= rotation
+	m.mutex.RUnlock()
+	if due {
+		m.mutex.Lock()
+		if now.Sub(m.rotated) >= rotation {
+			if err := m.rotate(now); err != nil {
+				log.WithFields(log.Fields{
+					"Type":  "lib/server/cookieManager",
+					"Func":  "secrets",
+					"Error": err,
+				}).Warn("failed to rotate cookie secret")
+			}
+		}
+		m.mutex.Unlock()
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.secret, m.previous
+}
+
+// serverCookie returns Version | Reserved | Timestamp | Hash (RFC 9018 section 4).
+func serverCookie(secret [16]byte, client []byte, ip net.IP, timestamp uint32) []byte {
+	cookie := make([]byte, 8, serverCookieSize)
+	cookie[0] = cookieVersion
+	binary.BigEndian.PutUint32(cookie[4:8], timestamp)
+	data := append(append([]byte{}, client...), cookie...)
+	if ip4 := ip.To4(); ip4 != nil {
+		data = append(data, ip4...)
+	} else {
+		data = append(data, ip.To16()...)
+	}
+	hash := make([]byte, 8)
+	binary.LittleEndian.PutUint64(hash, SipHash24(secret, data))
+	return append(cookie, hash...)
+}
+
+// Make returns a new server cookie for the client.
+func (m *cookieManager) Make(client []byte, ip net.IP, now time.Time) []byte {
+	secret, _ := m.secrets(now)
+	return serverCookie(secret, client, ip, uint32(now.Unix()))
+}
+
+// Verify checks the server cookie is made by us for the client, and is not expired.
+func (m *cookieManager) Verify(client []byte, server []byte, ip net.IP, now time.Time) bool {
+	if len(server) != serverCookieSize || server[0] != cookieVersion {
+		return false
+	}
+	timestamp := binary.BigEndian.Uint32(server[4:8])
+	if SerialLess(timestamp, uint32(now.Unix()-cookieExpire)) || SerialLess(uint32(now.Unix()+cookieFuture), timestamp) {
+		return false
+	}
+	secret, previous := m.secrets(now)
+	return bytes.Equal(server, serverCookie(secret, client, ip, timestamp)) ||
+		bytes.Equal(server, serverCookie(previous, client, ip, timestamp))
+}
+
+// requestCookie returns client and server cookies of the request.
+func requestCookie(req *dns.Msg) ([]byte, []byte, bool, error) {
+	opt := req.IsEdns0()
+	if opt == nil {
+		return nil, nil, false, nil
+	}
+	for _, o := range opt.Option {
+		cookie, ok := o.(*dns.EDNS0_COOKIE)
+		if !ok {
+			continue
+		}
+		data, err := hex.DecodeString(cookie.Cookie)
+		if err != nil {
+			return nil, nil, true, ErrCookieFormat
+		}
+		// client cookie only, or with 8 to 32 octets server cookie
+		if len(data) != clientCookieSize && (len(data) < clientCookieSize+8 || len(data) > clientCookieSize+32) {
+			return nil, nil, true, ErrCookieFormat
+		}
+		return data[:clientCookieSize], data[clientCookieSize:], true, nil
+	}
+	return nil, nil, false, nil
+}
+
+// checkCookie validates DNS cookie of the request (RFC 7873 section 5.2).
+// When RequireCookies is set, UDP clients without cookie get TC bit,
+// and clients with invalid server cookie get BADCOOKIE.
+// It returns false when the response is made.
+func (s *worker) checkCookie(w dns.ResponseWriter, m *dns.Msg, req *dns.Msg) bool {
+	if !s.config.Cookies {
+		return true
+	}
+	client, server, ok, err := requestCookie(req)
+	if err != nil {
+		m.Rcode = dns.RcodeFormatError
+		return false
+	}
+	strict := s.config.RequireCookies && s.listener.Net == "udp"
+	if !ok {
+		if strict {
+			m.Truncated = true
+			return false
+		}
+		return true
+	}
+	if strict && !s.cookieManager.Verify(client, server, AddrIP(w.RemoteAddr()), time.Now()) {
+		m.Rcode = dns.RcodeBadCookie
+		return false
+	}
+	return true
+}
+
+// setCookie adds client cookie and new server cookie to OPT of the response.
+func (s *worker) setCookie(w dns.ResponseWriter, m *dns.Msg, req *dns.Msg) {
+	opt := m.IsEdns0()
+	if !s.config.Cookies || opt == nil {
+		return
+	}
+	client, _, ok, err := requestCookie(req)
+	if !ok || err != nil {
+		return
+	}
+	server := s.cookieManager.Make(client, AddrIP(w.RemoteAddr()), time.Now())
+	opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{
+		Code:   dns.EDNS0COOKIE,
+		Cookie: hex.EncodeToString(append(append([]byte{}, client...), server...)),
+	})
+}
@@ -0,0 +1,166 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestCookieVerify(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.config.CookieRotation = 600
+	m, err := NewCookieManager(s.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := []byte("01234567")
+	ip := net.ParseIP("198.51.100.1")
+	now := m.rotated
+	cookie := m.Make(client, ip, now)
+	if len(cookie) != serverCookieSize || cookie[0] != cookieVersion {
+		t.Fatalf("server cookie need to be %d octets of version %d: %x", serverCookieSize, cookieVersion, cookie)
+	}
+	tampered := append([]byte{}, cookie...)
+	tampered[15] ^= 1
+
+	testcases := []struct {
+		name   string
+		client []byte
+		server []byte
+		ip     net.IP
+		at     time.Duration
+		valid  bool
+	}{
+		{name: "valid", client: client, server: cookie, ip: ip, valid: true},
+		{name: "other client cookie", client: []byte("76543210"), server: cookie, ip: ip},
+		{name: "other client address", client: client, server: cookie, ip: net.ParseIP("198.51.100.2")},
+		{name: "tampered", client: client, server: tampered, ip: ip},
+		{name: "short", client: client, server: cookie[:8], ip: ip},
+		{name: "unknown version", client: client, server: append([]byte{2}, cookie[1:]...), ip: ip},
+		{name: "not expired", client: client, server: cookie, ip: ip, at: (cookieExpire - 1) * time.Second, valid: true},
+		{name: "expired", client: client, server: cookie, ip: ip, at: (cookieExpire + 1) * time.Second},
+		{name: "near future", client: client, server: cookie, ip: ip, at: -(cookieFuture - 1) * time.Second, valid: true},
+		{name: "future", client: client, server: cookie, ip: ip, at: -(cookieFuture + 1) * time.Second},
+	}
+	for _, tc := range testcases {
+		// cookie is made before the secret is rotated
+		if valid := m.Verify(tc.client, tc.server, tc.ip, now.Add(tc.at)); valid != tc.valid {
+			t.Errorf("%s: cookie need to be valid %v", tc.name, tc.valid)
+		}
+	}
+
+	// cookie made by the previous secret is valid
+	before := m.rotated.Add(590 * time.Second)
+	cookie = m.Make(client, ip, before)
+	if !m.Verify(client, cookie, ip, before.Add(10*time.Second)) {
+		t.Errorf("cookie made by the previous secret need to be valid")
+	}
+	if !m.rotated.Equal(before.Add(10 * time.Second)) {
+		t.Errorf("secret need to be rotated after %d seconds", s.config.CookieRotation)
+	}
+	if !m.Verify(client, m.Make(client, ip, m.rotated), ip, m.rotated) {
+		t.Errorf("cookie made by the current secret need to be valid")
+	}
+	if m.Verify(client, cookie, ip, before.Add(610*time.Second)) {
+		t.Errorf("cookie made by the secret before the previous one need to be invalid")
+	}
+}
+
+func TestCheckCookie(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.config.Cookies = true
+	s.config.RequireCookies = true
+	s.addService("dyn-a", dns.TypeA, "dyn.example.jp. 60 IN A 192.0.2.100")
+	s.loadZone(t, "example.jp.", denialTestZone)
+	cm, err := NewCookieManager(s.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp := NewWorker(s.config, s.zoneManager, s.serviceManager, nil, nil, cm, NewRRLManager(s.config), nil, "127.0.0.1:0", "udp")
+	tcp := NewWorker(s.config, s.zoneManager, s.serviceManager, nil, nil, cm, NewRRLManager(s.config), nil, "127.0.0.1:0", "tcp")
+	client := []byte("01234567")
+	valid := cm.Make(client, net.ParseIP("198.51.100.1"), time.Now())
+	exchange := func(w *worker, cookie []byte) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion("www.example.jp.", dns.TypeA)
+		if cookie != nil {
+			req.SetEdns0(1232, false)
+			opt := req.IsEdns0()
+			opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: hex.EncodeToString(cookie)})
+		}
+		tw := &testWriter{}
+		w.ServeDNS(tw, req)
+		return tw.msg
+	}
+	responseCookie := func(m *dns.Msg) []byte {
+		if opt := m.IsEdns0(); opt != nil {
+			for _, o := range opt.Option {
+				if o, ok := o.(*dns.EDNS0_COOKIE); ok {
+					data, _ := hex.DecodeString(o.Cookie)
+					return data
+				}
+			}
+		}
+		return nil
+	}
+
+	testcases := []struct {
+		name    string
+		w       *worker
+		require bool
+		cookie  []byte
+		rcode   int
+		tc      bool
+		answer  bool
+	}{
+		{name: "no cookie", w: udp, require: true, rcode: dns.RcodeSuccess, tc: true},
+		{name: "client cookie only", w: udp, require: true, cookie: client, rcode: dns.RcodeBadCookie},
+		{name: "invalid server cookie", w: udp, require: true, cookie: append(append([]byte{}, client...), make([]byte, 16)...), rcode: dns.RcodeBadCookie},
+		{name: "valid server cookie", w: udp, require: true, cookie: append(append([]byte{}, client...), valid...), rcode: dns.RcodeSuccess, answer: true},
+		{name: "malformed cookie", w: udp, require: true, cookie: client[:5], rcode: dns.RcodeFormatError},
+		{name: "no cookie over TCP", w: tcp, require: true, rcode: dns.RcodeSuccess, answer: true},
+		{name: "invalid server cookie over TCP", w: tcp, require: true, cookie: append(append([]byte{}, client...), make([]byte, 16)...), rcode: dns.RcodeSuccess, answer: true},
+		{name: "no cookie not required", w: udp, rcode: dns.RcodeSuccess, answer: true},
+		{name: "invalid server cookie not required", w: udp, cookie: append(append([]byte{}, client...), make([]byte, 16)...), rcode: dns.RcodeSuccess, answer: true},
+	}
+	for _, tc := range testcases {
+		s.config.RequireCookies = tc.require
+		m := exchange(tc.w, tc.cookie)
+		if m.Rcode != tc.rcode || m.Truncated != tc.tc || (len(m.Answer) > 0) != tc.answer {
+			t.Errorf("%s: response need to be %s TC %v with answer %v: %v", tc.name, dns.RcodeToString[tc.rcode], tc.tc, tc.answer, m)
+			continue
+		}
+		if tc.cookie == nil || tc.rcode == dns.RcodeFormatError {
+			if responseCookie(m) != nil {
+				t.Errorf("%s: response need not to have cookie", tc.name)
+			}
+			continue
+		}
+		// new server cookie is returned with client cookie
+		got := responseCookie(m)
+		if len(got) != clientCookieSize+serverCookieSize || string(got[:clientCookieSize]) != string(client) ||
+			!cm.Verify(client, got[clientCookieSize:], net.ParseIP("198.51.100.1"), time.Now()) {
+			t.Errorf("%s: response need to have valid server cookie: %x", tc.name, got)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrSignRRset = errors.New("failed to sign RRset.")
+)
+
+const signatureInceptionOffset = 3600
+
+func isDNSSECOK(req *dns.Msg) bool {
+	if opt := req.IsEdns0(); opt != nil {
+		return opt.Do()
+	}
+	return false
+}
+
+// signRRset creates RRSIGs of rrset by active keys.
+func (s *worker) signRRset(keys *zoneKeys, zoneName string, rrset []dns.RR) ([]dns.RR, error) {
+	now := time.Now().Unix()
+	hdr := rrset[0].Header()
+	sigs := []dns.RR{}
+	for _, key := range keys.signers(hdr.Rrtype) {
+		sig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Name: hdr.Name, Rrtype: dns.TypeRRSIG, Class: hdr.Class, Ttl: hdr.Ttl},
+			Algorithm:  key.DNSKEY.Algorithm,
+			SignerName: zoneName,
+			KeyTag:     key.KeyTag,
+			Inception:  uint32(now - signatureInceptionOffset),
+			Expiration: uint32(now + int64(s.config.SignatureValidity)),
+		}
+		if err := sig.Sign(key.Signer, rrset); err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs, nil
+}
+
+// validSigs checks cached RRSIGs are made by current keys and not near expiration.
+func (s *worker) validSigs(keys *zoneKeys, rrtype uint16, sigs []dns.RR) bool {
+	signers := keys.signers(rrtype)
+	if len(sigs) != len(signers) {
+		return false
+	}
+	refresh := uint32(time.Now().Unix() + int64(s.config.SignatureValidity/4))
+	for i, rr := range sigs {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok {
+			return false
+		}
+		if sig.KeyTag != signers[i].KeyTag || sig.Algorithm != signers[i].DNSKEY.Algorithm {
+			return false
+		}
+		if sig.Expiration < refresh {
+			return false
+		}
+	}
+	return true
+}
+
+// getSigs returns RRSIGs of rrset.
+// RRSIGs of static RRsets in zone tree are cached in tree node,
+// dynamic or synthesized RRsets are signed each time.
+func (s *worker) getSigs(keys *zoneKeys, zoneName string, zoneTree *Tree, rrset []dns.RR) ([]dns.RR, error) {
+	hdr := rrset[0].Header()
+	node := zoneTree.SearchNode(Labels(hdr.Name), true)
+	if node == nil {
+		return s.signRRset(keys, zoneName, rrset)
+	}
+	rrs, ok := node.GetRR(hdr.Rrtype)
+	if !ok || len(rrs) != len(rrset) || rrs[0] != rrset[0] {
+		return s.signRRset(keys, zoneName, rrset)
+	}
+	if sigs, ok := node.GetSig(hdr.Rrtype); ok && s.validSigs(keys, hdr.Rrtype, sigs) {
+		return sigs, nil
+	}
+	sigs, err := s.signRRset(keys, zoneName, rrset)
+	if err != nil {
+		return nil, err
+	}
+	node.SetSig(hdr.Rrtype, sigs)
+	return sigs, nil
+}
+
+// needSign reports whether the RRset is authoritative data of the zone.
+// NS of delegation and glue are not signed.
+func needSign(zoneName string, zoneTree *Tree, rr dns.RR) bool {
+	hdr := rr.Header()
+	switch hdr.Rrtype {
+	case dns.TypeRRSIG, dns.TypeOPT, dns.TypeTSIG:
+		return false
+	}
+	if !dns.IsSubDomain(zoneName, hdr.Name) {
+		return false
+	}
+	node := zoneTree.SearchNode(Labels(hdr.Name), false)
+	if hdr.Rrtype == dns.TypeNSEC && !node.Auth {
+		// NSEC at delegation point is parent side data
+		return node.FindZoneCut() == node && strings.EqualFold(node.Label, hdr.Name)
+	}
+	return isAuth(node, zoneName, hdr.Rrtype)
+}
+
+// splitRRsets groups RRs in the section by owner, type and class.
+func splitRRsets(section []dns.RR) [][]dns.RR {
+	rrsets := [][]dns.RR{}
+	index := map[string]int{}
+	for _, rr := range section {
+		hdr := rr.Header()
+		key := strings.ToLower(hdr.Name) + "/" + dns.Type(hdr.Rrtype).String() + "/" + dns.Class(hdr.Class).String()
+		if i, ok := index[key]; ok {
+			rrsets[i] = append(rrsets[i], rr)
+		} else {
+			index[key] = len(rrsets)
+			rrsets = append(rrsets, []dns.RR{rr})
+		}
+	}
+	return rrsets
+}
+
+// signSection appends RRSIGs just after each RRset in the section.
+func (s *worker) signSection(keys *zoneKeys, zoneName string, zoneTree *Tree, section []dns.RR) ([]dns.RR, error) {
+	if len(section) == 0 {
+		return section, nil
+	}
+	result := []dns.RR{}
+	for _, rrset := range splitRRsets(section) {
+		result = append(result, rrset...)
+		if !needSign(zoneName, zoneTree, rrset[0]) {
+			continue
+		}
+		sigs, err := s.getSigs(keys, zoneName, zoneTree, rrset)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, sigs...)
+	}
+	return result, nil
+}
+
+// signResponse adds RRSIGs to answer, authority and additional sections.
+func (s *worker) signResponse(m *dns.Msg, zoneName string, zoneTree *Tree, keys *zoneKeys) error {
+	var err error
+	if m.Answer, err = s.signSection(keys, zoneName, zoneTree, m.Answer); err != nil {
+		goto ERROR
+	}
+	if m.Ns, err = s.signSection(keys, zoneName, zoneTree, m.Ns); err != nil {
+		goto ERROR
+	}
+	if m.Extra, err = s.signSection(keys, zoneName, zoneTree, m.Extra); err != nil {
+		goto ERROR
+	}
+	return nil
+ERROR:
+	log.WithFields(log.Fields{
+		"Type":     "lib/server/Worker",
+		"Func":     "signResponse",
+		"Error":    err,
+		"zonename": zoneName,
+	}).Warn(ErrSignRRset)
+	return ErrSignRRset
+}
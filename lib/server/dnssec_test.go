@@ -0,0 +1,110 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const signedTestZone = `
+example.jp. 3600 IN SOA ns.example.jp. root.example.jp. 1 3600 900 1814400 900
+example.jp. 3600 IN NS ns.example.jp.
+ns.example.jp. 300 IN A 192.0.2.53
+www.example.jp. 300 IN A 192.0.2.1
+www.example.jp. 300 IN A 192.0.2.2
+dyn.example.jp. 300 IN DYNA dyn-a
+`
+
+// verifySigs checks every RRset in the section is followed by RRSIG made by the DNSKEYs.
+func verifySigs(t *testing.T, name string, section []dns.RR, dnskeys []dns.RR) {
+	t.Helper()
+	for _, rrset := range splitRRsets(section) {
+		if rrset[0].Header().Rrtype != dns.TypeRRSIG {
+			continue
+		}
+		sig := rrset[0].(*dns.RRSIG)
+		covered := []dns.RR{}
+		for _, rr := range section {
+			if rr.Header().Rrtype == sig.TypeCovered && rr.Header().Name == sig.Hdr.Name {
+				covered = append(covered, rr)
+			}
+		}
+		verified := false
+		for _, rr := range dnskeys {
+			key := rr.(*dns.DNSKEY)
+			if key.KeyTag() == sig.KeyTag && sig.Verify(key, covered) == nil {
+				verified = true
+			}
+		}
+		if !verified || !sig.ValidityPeriod(time.Now()) {
+			t.Errorf("%s: RRSIG of %s %s need to be verified by DNSKEY", name, sig.Hdr.Name, dns.Type(sig.TypeCovered))
+		}
+	}
+}
+
+func TestSignResponse(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.addService("dyn-a", dns.TypeA, "dyn.example.jp. 60 IN A 192.0.2.100")
+	s.signZone(t, "example.jp.")
+	s.loadZone(t, "example.jp.", signedTestZone)
+	w := s.worker("udp")
+
+	runQueryTests(t, w, []queryTest{
+		{
+			qname: "www.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"www.example.jp. A 192.0.2.1", "www.example.jp. A 192.0.2.2"},
+		},
+		{
+			qname: "www.example.jp.", qtype: dns.TypeA, do: true, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"www.example.jp. A 192.0.2.1", "www.example.jp. A 192.0.2.2", "www.example.jp. RRSIG A"},
+		},
+		{
+			qname: "dyn.example.jp.", qtype: dns.TypeA, do: true, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"dyn.example.jp. A 192.0.2.100", "dyn.example.jp. RRSIG A"},
+		},
+		{
+			qname: "example.jp.", qtype: dns.TypeDNSKEY, do: true, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"example.jp. DNSKEY", "example.jp. DNSKEY", "example.jp. RRSIG DNSKEY"},
+		},
+	})
+
+	keys, err := s.keyManager.LoadKeys("example.jp.")
+	if err != nil || keys == nil {
+		t.Fatalf("keys of the zone need to be loaded: %v", err)
+	}
+	for _, tc := range []struct {
+		qname  string
+		qtype  uint16
+		signer []*signingKey
+	}{
+		{"www.example.jp.", dns.TypeA, keys.ZSK},
+		{"dyn.example.jp.", dns.TypeA, keys.ZSK},
+		{"example.jp.", dns.TypeDNSKEY, keys.KSK},
+	} {
+		name := tc.qname + "/" + dns.Type(tc.qtype).String()
+		m := query(w, tc.qname, tc.qtype, true)
+		for _, rr := range m.Answer {
+			if sig, ok := rr.(*dns.RRSIG); ok && sig.KeyTag != tc.signer[0].KeyTag {
+				t.Errorf("%s: RRSIG need to be made by key %d, but %d", name, tc.signer[0].KeyTag, sig.KeyTag)
+			}
+		}
+		verifySigs(t, name, m.Answer, keys.DNSKEYs())
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rabbitdns/rabbitdns/lib/config"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrDoHRequest = errors.New("invalid DNS over HTTPS request.")
+)
+
+const (
+	dohContentType = "application/dns-message"
+	dohTimeout     = 10 * time.Second
+)
+
+// dohServer serves DNS over HTTPS (RFC 8484) by the query path of the worker.
+type dohServer struct {
+	config *config.Config
+	worker *worker
+	server *http.Server
+}
+
+func NewDoHServer(c *config.Config, worker *worker, tlsConfig *tls.Config) *dohServer {
+	s := &dohServer{config: c, worker: worker}
+	mux := http.NewServeMux()
+	mux.Handle(c.DoHPath, s)
+	s.server = &http.Server{
+		Addr:         worker.listener.Addr,
+		Handler:      mux,
+		TLSConfig:    tlsConfig,
+		ReadTimeout:  dohTimeout,
+		WriteTimeout: dohTimeout,
+	}
+	return s
+}
+
+func (s *dohServer) Run() {
+	go func() {
+		if err := s.server.ListenAndServeTLS("", ""); err != nil {
+			log.WithFields(log.Fields{
+				"Type":  "lib/server/dohServer",
+				"Func":  "Run",
+				"Error": err,
+				"addr":  s.server.Addr,
+			}).Fatal(ErrServ)
+		}
+	}()
+}
+
+// readRequest returns DNS message of GET ?dns= or POST application/dns-message request.
+func readRequest(r *http.Request) ([]byte, int) {
+	switch r.Method {
+	case http.MethodGet:
+		buf, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		if err != nil {
+			return nil, http.StatusBadRequest
+		}
+		return buf, http.StatusOK
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dohContentType {
+			return nil, http.StatusUnsupportedMediaType
+		}
+		buf, err := ioutil.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize))
+		if err != nil {
+			return nil, http.StatusBadRequest
+		}
+		return buf, http.StatusOK
+	}
+	return nil, http.StatusMethodNotAllowed
+}
+
+func (s *dohServer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	buf, status := readRequest(r)
+	var req *dns.Msg
+	if status == http.StatusOK {
+		var err error
+		if req, err = unpackRequest(buf); err != nil {
+			status = http.StatusBadRequest
+		}
+	}
+	if status != http.StatusOK {
+		log.WithFields(log.Fields{
+			"Type":   "lib/server/dohServer",
+			"Func":   "ServeHTTP",
+			"client": r.RemoteAddr,
+			"status": status,
+		}).Debug(ErrDoHRequest)
+		http.Error(rw, http.StatusText(status), status)
+		return
+	}
+
+	w := &dohWriter{
+		tsigState: tsigState{secrets: s.worker.listener.TsigSecret},
+		local:     s.server.Addr,
+		remote:    s.remoteAddr(r),
+	}
+	w.verify(buf, req)
+	s.worker.ServeDNS(w, req)
+	if w.msg == nil {
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", dohContentType)
+	rw.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", w.maxAge))
+	rw.Write(w.msg)
+}
+
+// remoteAddr returns the client address, which is in X-Forwarded-For when the peer is trusted proxy.
+func (s *dohServer) remoteAddr(r *http.Request) net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+	if ip := ForwardedIP(addr.IP, r.Header["X-Forwarded-For"], s.config.TrustedProxies); !ip.Equal(addr.IP) {
+		return &net.TCPAddr{IP: ip}
+	}
+	return addr
+}
+
+// maxAge returns the freshness lifetime of the response, which is minimum TTL of the answer.
+// Negative responses use TTL of the authority section (RFC 8484 section 5.1).
+func maxAge(m *dns.Msg) uint32 {
+	rrs := m.Answer
+	if len(rrs) == 0 {
+		rrs = m.Ns
+	}
+	if len(rrs) == 0 {
+		return 0
+	}
+	ttl := rrs[0].Header().Ttl
+	for _, rr := range rrs[1:] {
+		if rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	if soa, ok := rrs[0].(*dns.SOA); ok && len(m.Answer) == 0 && soa.Minttl < ttl {
+		ttl = soa.Minttl
+	}
+	return ttl
+}
+
+// dohWriter is dns.ResponseWriter which keeps the response for HTTP.
+type dohWriter struct {
+	tsigState
+	local  string
+	remote net.Addr
+	msg    []byte
+	maxAge uint32
+}
+
+func (w *dohWriter) LocalAddr() net.Addr {
+	addr, _ := net.ResolveTCPAddr("tcp", w.local)
+	return addr
+}
+
+func (w *dohWriter) RemoteAddr() net.Addr {
+	return w.remote
+}
+
+func (w *dohWriter) WriteMsg(m *dns.Msg) error {
+	data, err := w.pack(m)
+	if err != nil {
+		return err
+	}
+	w.msg = data
+	w.maxAge = maxAge(m)
+	return nil
+}
+
+func (w *dohWriter) Write(b []byte) (int, error) {
+	w.msg = append([]byte{}, b...)
+	return len(b), nil
+}
+
+func (w *dohWriter) Close() error { return nil }
+func (w *dohWriter) Hijack()      {}
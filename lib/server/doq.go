@@ -0,0 +1,184 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/rabbitdns/rabbitdns/lib/config"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrDoQRequest = errors.New("invalid DNS over QUIC request.")
+)
+
+// error codes of DNS over QUIC (RFC 9250 section 4.3)
+const (
+	doqProtocolError quic.ApplicationErrorCode = 0x2
+	doqExcessiveLoad quic.ApplicationErrorCode = 0x4
+)
+
+const (
+	doqIdleTimeout = 30 * time.Second
+	doqTimeout     = 10 * time.Second
+)
+
+// doqServer serves DNS over QUIC (RFC 9250) by the query path of the worker.
+// Each query arrives on its own bidirectional stream.
+type doqServer struct {
+	config    *config.Config
+	worker    *worker
+	tlsConfig *tls.Config
+	conns     int64
+}
+
+func NewDoQServer(c *config.Config, worker *worker, tlsConfig *tls.Config) *doqServer {
+	return &doqServer{config: c, worker: worker, tlsConfig: tlsConfig}
+}
+
+func (s *doqServer) Run() {
+	listener, err := quic.ListenAddr(s.worker.listener.Addr, s.tlsConfig, &quic.Config{
+		MaxIncomingStreams:    int64(s.config.DoQMaxStreams),
+		MaxIncomingUniStreams: -1,
+		MaxIdleTimeout:        doqIdleTimeout,
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Type":  "lib/server/doqServer",
+			"Func":  "Run",
+			"Error": err,
+			"addr":  s.worker.listener.Addr,
+		}).Fatal(ErrServ)
+	}
+	go s.serve(listener)
+}
+
+func (s *doqServer) serve(listener *quic.Listener) {
+	for {
+		conn, err := listener.Accept(context.Background())
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Type":  "lib/server/doqServer",
+				"Func":  "serve",
+				"Error": err,
+			}).Warn(ErrServ)
+			return
+		}
+		if atomic.AddInt64(&s.conns, 1) > int64(s.config.DoQMaxConns) {
+			atomic.AddInt64(&s.conns, -1)
+			conn.CloseWithError(doqExcessiveLoad, "too many connections")
+			continue
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *doqServer) serveConn(conn quic.Connection) {
+	defer atomic.AddInt64(&s.conns, -1)
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			// closed by client or idle timeout
+			return
+		}
+		go s.serveStream(conn, stream)
+	}
+}
+
+// serveStream reads the query of 2-octet length prefix, and writes the responses to the stream.
+// Malformed query and non-zero message ID are protocol errors (RFC 9250 section 4.2.1).
+func (s *doqServer) serveStream(conn quic.Connection, stream quic.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(doqTimeout))
+	var length uint16
+	if err := binary.Read(stream, binary.BigEndian, &length); err != nil {
+		s.protocolError(conn, err)
+		return
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		s.protocolError(conn, err)
+		return
+	}
+	req, err := unpackRequest(buf)
+	if err != nil || req.Id != 0 {
+		s.protocolError(conn, ErrDoQRequest)
+		return
+	}
+	w := &doqWriter{
+		tsigState: tsigState{secrets: s.worker.listener.TsigSecret},
+		conn:      conn,
+		stream:    stream,
+	}
+	w.verify(buf, req)
+	s.worker.ServeDNS(w, req)
+}
+
+func (s *doqServer) protocolError(conn quic.Connection, err error) {
+	log.WithFields(log.Fields{
+		"Type":   "lib/server/doqServer",
+		"Func":   "serveStream",
+		"Error":  err,
+		"client": conn.RemoteAddr().String(),
+	}).Debug(ErrDoQRequest)
+	conn.CloseWithError(doqProtocolError, ErrDoQRequest.Error())
+}
+
+// doqWriter is dns.ResponseWriter which writes messages with 2-octet length prefix to the stream.
+type doqWriter struct {
+	tsigState
+	conn   quic.Connection
+	stream quic.Stream
+}
+
+func (w *doqWriter) LocalAddr() net.Addr {
+	return w.conn.LocalAddr()
+}
+
+func (w *doqWriter) RemoteAddr() net.Addr {
+	return w.conn.RemoteAddr()
+}
+
+func (w *doqWriter) WriteMsg(m *dns.Msg) error {
+	data, err := w.pack(m)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (w *doqWriter) Write(b []byte) (int, error) {
+	buf := make([]byte, 2, 2+len(b))
+	binary.BigEndian.PutUint16(buf, uint16(len(b)))
+	if _, err := w.stream.Write(append(buf, b...)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (w *doqWriter) Close() error { return w.stream.Close() }
+func (w *doqWriter) Hijack()      {}
@@ -0,0 +1,135 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// block length of response padding (RFC 8467 section 4.1)
+const paddingBlockSize = 468
+
+// checkEdns0 validates OPT of the request (RFC 6891).
+// It returns false when the error response is made.
+func (s *worker) checkEdns0(m *dns.Msg, req *dns.Msg) bool {
+	opts := 0
+	for _, rr := range req.Extra {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			opts++
+		}
+	}
+	if opts > 1 {
+		m.Rcode = dns.RcodeFormatError
+		return false
+	}
+	if opt := req.IsEdns0(); opt != nil && opt.Version() != 0 {
+		m.Rcode = dns.RcodeBadVers
+		return false
+	}
+	return true
+}
+
+// setEdns0 adds OPT which advertises our UDP payload size, when the request has OPT.
+func (s *worker) setEdns0(w dns.ResponseWriter, m *dns.Msg, req *dns.Msg) {
+	opt := req.IsEdns0()
+	if opt == nil {
+		return
+	}
+	m.SetEdns0(uint16(s.config.MaxUDPSize), opt.Do())
+	s.setCookie(w, m, req)
+	s.setNSID(m, opt)
+}
+
+// setNSID adds identity of the server as NSID, when the request has NSID option (RFC 5001).
+// Identity is not exposed until it is configured.
+func (s *worker) setNSID(m *dns.Msg, opt *dns.OPT) {
+	if !s.config.NSID || s.config.HideIdentity || s.config.Identity == "" {
+		return
+	}
+	for _, o := range opt.Option {
+		if o.Option() == dns.EDNS0NSID {
+			nsid := &dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: hex.EncodeToString([]byte(s.config.Identity))}
+			m.IsEdns0().Option = append(m.IsEdns0().Option, nsid)
+			return
+		}
+	}
+}
+
+// udpSize returns the payload size which the response over UDP needs to fit in.
+func (s *worker) udpSize(req *dns.Msg) int {
+	size := dns.MinMsgSize
+	if opt := req.IsEdns0(); opt != nil && int(opt.UDPSize()) > size {
+		size = int(opt.UDPSize())
+	}
+	if size > s.config.MaxUDPSize {
+		size = s.config.MaxUDPSize
+	}
+	if t := req.IsTsig(); t != nil {
+		// room for TSIG which is added when the response is written
+		tsig := &dns.TSIG{
+			Hdr:       dns.RR_Header{Name: t.Hdr.Name, Rrtype: dns.TypeTSIG, Class: dns.ClassANY},
+			Algorithm: t.Algorithm,
+			MACSize:   64,
+			MAC:       strings.Repeat("00", 64),
+		}
+		size -= dns.Len(tsig)
+	}
+	return size
+}
+
+// truncate trims sections of UDP response in the order of answer, authority
+// and additional, and sets TC bit.
+// Lack of additional data doesn't set TC bit (RFC 2181 section 9),
+// except for glue of referral.
+func (s *worker) truncate(m *dns.Msg, req *dns.Msg) {
+	if s.listener.Net != "udp" {
+		return
+	}
+	if m.Truncated {
+		// TC is already set by cookie check
+		return
+	}
+	answers, authorities := len(m.Answer), len(m.Ns)
+	referral := !m.Authoritative && answers == 0 && authorities > 0
+	m.Truncate(s.udpSize(req))
+	if m.Truncated && !referral && len(m.Answer) == answers && len(m.Ns) == authorities {
+		m.Truncated = false
+	}
+}
+
+// pad adds padding option to responses on encrypted transports
+// by the block-length padding strategy (RFC 7830, RFC 8467 section 4.1).
+func (s *worker) pad(m *dns.Msg) {
+	opt := m.IsEdns0()
+	if opt == nil || !s.encrypted() {
+		return
+	}
+	// option code and length are 4 octets
+	if rest := (m.Len() + 4) % paddingBlockSize; rest != 0 {
+		opt.Option = append(opt.Option, &dns.EDNS0_PADDING{Padding: make([]byte, paddingBlockSize-rest)})
+	}
+}
+
+// writeMsg writes the response with OPT, and truncates it to the size the client can receive.
+func (s *worker) writeMsg(w dns.ResponseWriter, m *dns.Msg, req *dns.Msg) error {
+	s.setEdns0(w, m, req)
+	s.truncate(m, req)
+	s.pad(m)
+	return w.WriteMsg(m)
+}
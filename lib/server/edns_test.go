@@ -0,0 +1,210 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestUDPSize(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	w := s.worker("udp")
+	testcases := []struct {
+		size   uint16
+		tsig   bool
+		expect int
+	}{
+		{0, false, 512},
+		{256, false, 512},
+		{1000, false, 1000},
+		{4096, false, 1232},
+		{4096, true, 1232 - dns.Len(&dns.TSIG{
+			Hdr:       dns.RR_Header{Name: "key.", Rrtype: dns.TypeTSIG, Class: dns.ClassANY},
+			Algorithm: dns.HmacSHA256,
+			MACSize:   64,
+			MAC:       strings.Repeat("00", 64),
+		})},
+	}
+	for _, tc := range testcases {
+		req := new(dns.Msg)
+		req.SetQuestion("www.example.jp.", dns.TypeA)
+		if tc.size > 0 {
+			req.SetEdns0(tc.size, false)
+		}
+		if tc.tsig {
+			req.SetTsig("key.", dns.HmacSHA256, 300, 0)
+		}
+		if size := w.udpSize(req); size != tc.expect {
+			t.Errorf("size %d tsig %v: payload size need to be %d, but %d", tc.size, tc.tsig, tc.expect, size)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	// glue of referral is added by additional section processing
+	s.config.MinimumResponse = false
+	zone := `
+example.jp. 3600 IN SOA ns.example.jp. root.example.jp. 1 3600 900 1814400 900
+example.jp. 3600 IN NS ns.example.jp.
+ns.example.jp. 300 IN A 192.0.2.53
+`
+	for i := 0; i < 40; i++ {
+		zone += fmt.Sprintf("txt.example.jp. 300 IN TXT \"%s%02d\"\n", strings.Repeat("x", 30), i)
+	}
+	for i := 0; i < 10; i++ {
+		zone += fmt.Sprintf("sub.example.jp. 3600 IN NS ns%d.sub.example.jp.\n", i)
+		zone += fmt.Sprintf("ns%d.sub.example.jp. 3600 IN A 192.0.2.%d\n", i, i)
+		zone += fmt.Sprintf("ns%d.sub.example.jp. 3600 IN AAAA 2001:db8::%d\n", i, i)
+	}
+	s.loadZone(t, "example.jp.", zone)
+	udp, tcp := s.worker("udp"), s.worker("tcp")
+
+	testcases := []struct {
+		w         *worker
+		qname     string
+		size      uint16
+		tc        bool
+		limit     int
+		answers   int
+		referrals int
+	}{
+		// answer is dropped
+		{w: udp, qname: "txt.example.jp.", tc: true, limit: 512},
+		{w: udp, qname: "txt.example.jp.", size: 4096, tc: true, limit: 1232},
+		{w: tcp, qname: "txt.example.jp.", tc: false, answers: 40},
+		// glue of referral is dropped
+		{w: udp, qname: "www.sub.example.jp.", tc: true, limit: 512, referrals: 10},
+		{w: udp, qname: "www.sub.example.jp.", size: 1232, tc: false, limit: 1232, referrals: 10},
+	}
+	for i, tc := range testcases {
+		req := new(dns.Msg)
+		req.SetQuestion(tc.qname, dns.TypeTXT)
+		if tc.size > 0 {
+			req.SetEdns0(tc.size, false)
+		}
+		tw := &testWriter{}
+		tc.w.ServeDNS(tw, req)
+		m := tw.msg
+		if m.Truncated != tc.tc {
+			t.Errorf("case %d: TC bit need to be %v: %v", i, tc.tc, m)
+		}
+		if tc.limit > 0 && m.Len() > tc.limit {
+			t.Errorf("case %d: response need to fit in %d octets, but %d", i, tc.limit, m.Len())
+		}
+		if tc.answers > 0 && len(m.Answer) != tc.answers {
+			t.Errorf("case %d: answer need to have %d RRs, but %d", i, tc.answers, len(m.Answer))
+		}
+		if tc.referrals > 0 && len(m.Ns) != tc.referrals {
+			t.Errorf("case %d: authority need to have %d RRs, but %d", i, tc.referrals, len(m.Ns))
+		}
+	}
+
+	// lack of additional data of authoritative answer doesn't set TC bit
+	req := new(dns.Msg)
+	req.SetQuestion("mail.example.jp.", dns.TypeMX)
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Authoritative = true
+	m.Answer = []dns.RR{mustRR("mail.example.jp. 300 IN MX 10 mx.example.jp.")}
+	for i := 0; i < 40; i++ {
+		m.Extra = append(m.Extra, mustRR(fmt.Sprintf("mx.example.jp. 300 IN AAAA 2001:db8::%d", i)))
+	}
+	udp.truncate(m, req)
+	if m.Truncated || len(m.Answer) != 1 || len(m.Extra) == 0 || len(m.Extra) == 40 || m.Len() > 512 {
+		t.Errorf("additional data need to be trimmed without TC bit: %v", m)
+	}
+	m.Extra = nil
+	m.Truncated = true
+	udp.truncate(m, req)
+	if !m.Truncated {
+		t.Errorf("TC bit set by cookie check need to be kept")
+	}
+}
+
+func TestCheckEdns0(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.config.Identity = "ns1.example.jp"
+	s.addService("dyn-a", dns.TypeA, "dyn.example.jp. 60 IN A 192.0.2.100")
+	s.loadZone(t, "example.jp.", denialTestZone)
+	w := s.worker("udp")
+	nsid := hex.EncodeToString([]byte("ns1.example.jp"))
+
+	testcases := []struct {
+		name    string
+		opts    int
+		version uint8
+		nsid    bool
+		hide    bool
+		rcode   int
+		expect  string
+	}{
+		{name: "no OPT", opts: 0, rcode: dns.RcodeSuccess},
+		{name: "OPT", opts: 1, rcode: dns.RcodeSuccess},
+		{name: "multiple OPTs", opts: 2, rcode: dns.RcodeFormatError},
+		{name: "version 1", opts: 1, version: 1, rcode: dns.RcodeBadVers},
+		{name: "NSID", opts: 1, nsid: true, rcode: dns.RcodeSuccess, expect: nsid},
+		{name: "NSID of hidden identity", opts: 1, nsid: true, hide: true, rcode: dns.RcodeSuccess},
+	}
+	for _, tc := range testcases {
+		s.config.NSID = true
+		s.config.HideIdentity = tc.hide
+		req := new(dns.Msg)
+		req.SetQuestion("www.example.jp.", dns.TypeA)
+		for i := 0; i < tc.opts; i++ {
+			opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+			opt.SetUDPSize(1232)
+			opt.SetVersion(tc.version)
+			if tc.nsid {
+				opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+			}
+			req.Extra = append(req.Extra, opt)
+		}
+		tw := &testWriter{}
+		w.ServeDNS(tw, req)
+		m := tw.msg
+		if m.Rcode != tc.rcode {
+			t.Errorf("%s: rcode need to be %s, but %s", tc.name, dns.RcodeToString[tc.rcode], dns.RcodeToString[m.Rcode])
+		}
+		if tc.rcode != dns.RcodeSuccess && len(m.Answer) > 0 {
+			t.Errorf("%s: error response need not to have answer: %v", tc.name, m.Answer)
+		}
+		opt := m.IsEdns0()
+		if (opt != nil) != (tc.opts > 0) {
+			t.Errorf("%s: response need to have OPT only when the request has OPT: %v", tc.name, m)
+			continue
+		}
+		got := ""
+		if opt != nil {
+			for _, o := range opt.Option {
+				if o, ok := o.(*dns.EDNS0_NSID); ok {
+					got = o.Nsid
+				}
+			}
+		}
+		if got != tc.expect {
+			t.Errorf("%s: NSID need to be %q, but %q", tc.name, tc.expect, got)
+		}
+	}
+}
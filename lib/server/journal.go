@@ -0,0 +1,275 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
+)
+
+var (
+	ErrReadJournal  = errors.New("failed to read journal.")
+	ErrWriteJournal = errors.New("failed to write journal.")
+)
+
+// journalDelta is the difference between two versions of the zone.
+type journalDelta struct {
+	OldSOA  *dns.SOA
+	NewSOA  *dns.SOA
+	Deleted []dns.RR
+	Added   []dns.RR
+}
+
+// RRs returns the delta in IXFR response format (RFC 1995 section 4).
+func (d *journalDelta) RRs() []dns.RR {
+	rrs := []dns.RR{d.OldSOA}
+	rrs = append(rrs, d.Deleted...)
+	rrs = append(rrs, d.NewSOA)
+	return append(rrs, d.Added...)
+}
+
+func journalPath(dir string, origin string) string {
+	return filepath.Join(dir, strings.TrimSuffix(strings.ToLower(origin), ".")+".jnl")
+}
+
+// zoneRecords returns RRs of the zone except SOA.
+// Dynamic RRs are returned separately, because they are resolved at transfer time.
+func zoneRecords(zoneTree *Tree, origin string, signed *signedZone) (static []dns.RR, dynamic []dns.RR) {
+	apex := zoneTree.SearchNode(Labels(origin), true)
+	if apex == nil {
+		return
+	}
+	apex.Walk(func(node *Tree) {
+		types := []int{}
+		for rrtype := range node.Resources {
+			types = append(types, int(rrtype))
+		}
+		sort.Ints(types)
+		for _, t := range types {
+			rrtype := uint16(t)
+			if _, ok := DynamicStaticMap[rrtype]; ok {
+				dynamic = append(dynamic, node.Resources[rrtype]...)
+				continue
+			}
+			if node != apex || rrtype != dns.TypeSOA {
+				static = append(static, node.Resources[rrtype]...)
+			}
+			if signed != nil {
+				sigs, _ := node.GetSig(rrtype)
+				static = append(static, sigs...)
+			}
+		}
+	})
+	if signed != nil {
+		for _, nsec3 := range signed.nsec3 {
+			static = append(static, nsec3)
+			static = append(static, signed.nsec3Sigs[nsec3Hash(nsec3.Header().Name)]...)
+		}
+	}
+	return
+}
+
+func zoneSOA(zoneTree *Tree, origin string) *dns.SOA {
+	apex := zoneTree.SearchNode(Labels(origin), true)
+	if apex == nil {
+		return nil
+	}
+	rrs, ok := apex.GetRR(dns.TypeSOA)
+	if !ok {
+		return nil
+	}
+	soa, _ := rrs[0].(*dns.SOA)
+	return soa
+}
+
+// diffRecords returns RRs which exist only in old and only in new.
+func diffRecords(old, new []dns.RR) (deleted []dns.RR, added []dns.RR) {
+	oldSet := map[string]bool{}
+	for _, rr := range old {
+		oldSet[strings.ToLower(rr.String())] = true
+	}
+	newSet := map[string]bool{}
+	for _, rr := range new {
+		key := strings.ToLower(rr.String())
+		newSet[key] = true
+		if !oldSet[key] {
+			added = append(added, rr)
+		}
+	}
+	for _, rr := range old {
+		if !newSet[strings.ToLower(rr.String())] {
+			deleted = append(deleted, rr)
+		}
+	}
+	return
+}
+
+// readJournal reads deltas of the zone, which are saved as sequence of IXFR format RRs.
+func readJournal(dir string, origin string) ([]*journalDelta, error) {
+	f, err := os.Open(journalPath(dir, origin))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	deltas := []*journalDelta{}
+	var delta *journalDelta
+	for x := range dns.ParseZone(f, origin, "") {
+		if x.Error != nil {
+			return nil, ErrReadJournal
+		}
+		if soa, ok := x.RR.(*dns.SOA); ok {
+			if delta == nil || delta.NewSOA != nil {
+				delta = &journalDelta{OldSOA: soa}
+				deltas = append(deltas, delta)
+			} else {
+				delta.NewSOA = soa
+			}
+			continue
+		}
+		if delta == nil {
+			return nil, ErrReadJournal
+		}
+		if delta.NewSOA == nil {
+			delta.Deleted = append(delta.Deleted, x.RR)
+		} else {
+			delta.Added = append(delta.Added, x.RR)
+		}
+	}
+	if delta != nil && delta.NewSOA == nil {
+		return nil, ErrReadJournal
+	}
+	return deltas, nil
+}
+
+// zoneJournal is the journal of the zone in memory, indexed by serial of the start of deltas.
+// It is replaced as a whole when the zone is reloaded.
+type zoneJournal struct {
+	deltas []*journalDelta
+	index  map[uint32]int
+}
+
+func newZoneJournal(deltas []*journalDelta) *zoneJournal {
+	j := &zoneJournal{deltas: deltas, index: map[uint32]int{}}
+	for i, delta := range deltas {
+		j.index[delta.OldSOA.Serial] = i
+	}
+	return j
+}
+
+// loadJournal reads the journal file of the zone.
+// Broken journal is ignored, clients fall back to AXFR.
+func loadJournal(dir string, origin string) *zoneJournal {
+	deltas, err := readJournal(dir, origin)
+	if err != nil {
+		deltas = nil
+	}
+	return newZoneJournal(deltas)
+}
+
+// writeJournal saves deltas.
+func writeJournal(dir string, origin string, deltas []*journalDelta) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := journalPath(dir, origin)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, delta := range deltas {
+		for _, rr := range delta.RRs() {
+			w.WriteString(rr.String() + "\n")
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// appendJournal records the difference between old and new zone data,
+// and returns the journal which includes it. Old deltas over MaxJournalChanges are dropped.
+// When the difference can't be represented by IXFR, the journal is cleared
+// and clients fall back to AXFR.
+func (m *zoneManager) appendJournal(origin string, journal *zoneJournal, oldTree *Tree, oldSigned *signedZone, newTree *Tree, newSigned *signedZone) (*zoneJournal, error) {
+	oldSOA, newSOA := zoneSOA(oldTree, origin), zoneSOA(newTree, origin)
+	if oldSOA == nil || newSOA == nil || oldSOA.Serial == newSOA.Serial {
+		return journal, nil
+	}
+	oldStatic, oldDynamic := zoneRecords(oldTree, origin, oldSigned)
+	newStatic, newDynamic := zoneRecords(newTree, origin, newSigned)
+	deltas := append([]*journalDelta{}, journal.deltas...)
+	if len(deltas) > 0 && deltas[len(deltas)-1].NewSOA.Serial != oldSOA.Serial {
+		// history has a gap
+		deltas = nil
+	}
+	dynDeleted, dynAdded := diffRecords(oldDynamic, newDynamic)
+	switch {
+	case !SerialLess(oldSOA.Serial, newSOA.Serial):
+		// serial is rewound
+		deltas = nil
+	case m.config.DynamicTransfer == "resolve" && len(dynDeleted)+len(dynAdded) > 0:
+		// resolved dynamic RRs can't be tracked by journal
+		deltas = nil
+	default:
+		deleted, added := diffRecords(oldStatic, newStatic)
+		deltas = append(deltas, &journalDelta{OldSOA: oldSOA, NewSOA: newSOA, Deleted: deleted, Added: added})
+	}
+	if max := m.config.MaxJournalChanges; len(deltas) > max {
+		deltas = deltas[len(deltas)-max:]
+	}
+	journal = newZoneJournal(deltas)
+	if err := writeJournal(m.config.JournalDir, origin, deltas); err != nil {
+		return journal, ErrWriteJournal
+	}
+	return journal, nil
+}
+
+// RRs returns IXFR RRs from serial to current SOA.
+// It returns false when the journal doesn't have enough history.
+func (j *zoneJournal) RRs(serial uint32, current *dns.SOA) ([]dns.RR, bool) {
+	start, ok := j.index[serial]
+	if !ok {
+		return nil, false
+	}
+	deltas := j.deltas[start:]
+	rrs := []dns.RR{current}
+	for i, delta := range deltas {
+		if i > 0 && deltas[i-1].NewSOA.Serial != delta.OldSOA.Serial {
+			return nil, false
+		}
+		rrs = append(rrs, delta.RRs()...)
+	}
+	if deltas[len(deltas)-1].NewSOA.Serial != current.Serial {
+		return nil, false
+	}
+	return append(rrs, current), true
+}
@@ -0,0 +1,167 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/rabbitdns/rabbitdns/lib/config"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
+)
+
+func journalTestTree(t *testing.T, serial uint32, hosts ...string) *Tree {
+	zone := fmt.Sprintf("example.jp. 3600 IN SOA ns.example.jp. root.example.jp. %d 3600 900 1814400 900\n", serial)
+	zone += "example.jp. 3600 IN NS ns.example.jp.\n"
+	for _, host := range hosts {
+		zone += fmt.Sprintf("%s.example.jp. 300 IN A 192.0.2.%d\n", host, len(host))
+	}
+	tree := NewTree()
+	for x := range dns.ParseZone(strings.NewReader(zone), "example.jp.", "") {
+		if x.Error != nil {
+			t.Fatal(x.Error)
+		}
+		tree.AddRR(x.RR)
+	}
+	return tree
+}
+
+func TestZoneJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m := &zoneManager{config: &config.Config{JournalDir: dir, MaxJournalChanges: 2}}
+
+	trees := []*Tree{
+		journalTestTree(t, 1, "www"),
+		journalTestTree(t, 2, "www", "mail"),
+		journalTestTree(t, 3, "mail"),
+		journalTestTree(t, 4, "mail", "ftp"),
+	}
+	journal := loadJournal(dir, "example.jp.")
+	for i := 1; i < len(trees); i++ {
+		if journal, err = m.appendJournal("example.jp.", journal, trees[i-1], nil, trees[i], nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(journal.deltas) != 2 {
+		t.Fatalf("journal need to keep MaxJournalChanges deltas, but %d", len(journal.deltas))
+	}
+
+	current := zoneSOA(trees[3], "example.jp.")
+	for _, j := range []*zoneJournal{journal, loadJournal(dir, "example.jp.")} {
+		if _, ok := j.RRs(1, current); ok {
+			t.Errorf("dropped history need to fall back to AXFR")
+		}
+		rrs, ok := j.RRs(2, current)
+		if !ok {
+			t.Fatalf("journal need to have history from serial 2")
+		}
+		// current SOA, (SOA 2, -www, SOA 3), (SOA 3, SOA 4, +ftp), current SOA
+		expect := []string{"SOA 4", "SOA 2", "A www", "SOA 3", "SOA 3", "SOA 4", "A ftp", "SOA 4"}
+		if len(rrs) != len(expect) {
+			t.Fatalf("IXFR RRs need to be %v, but %v", expect, rrs)
+		}
+		for i, rr := range rrs {
+			var got string
+			switch rr := rr.(type) {
+			case *dns.SOA:
+				got = fmt.Sprintf("SOA %d", rr.Serial)
+			case *dns.A:
+				got = "A " + strings.TrimSuffix(rr.Hdr.Name, ".example.jp.")
+			}
+			if got != expect[i] {
+				t.Errorf("IXFR RR %d need to be %s, but %s", i, expect[i], rr)
+			}
+		}
+	}
+
+	// serial is rewound
+	journal, err = m.appendJournal("example.jp.", journal, trees[3], nil, trees[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(journal.deltas) != 0 {
+		t.Errorf("journal need to be cleared when serial is rewound")
+	}
+}
+
+// transferTexts returns SOA serials and A owner names in the transfer.
+func transferTexts(msgs []*dns.Msg) []string {
+	result := []string{}
+	for _, m := range msgs {
+		for _, rr := range m.Answer {
+			switch rr := rr.(type) {
+			case *dns.SOA:
+				result = append(result, fmt.Sprintf("SOA %d", rr.Serial))
+			case *dns.A:
+				result = append(result, "A "+strings.TrimSuffix(rr.Hdr.Name, ".example.jp."))
+			}
+		}
+	}
+	return result
+}
+
+func TestServeIXFR(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.config.Zones = []config.ZoneConfig{{Name: "example.jp", AllowTransfer: []string{"any"}}}
+	zone := func(serial int, hosts ...string) string {
+		text := fmt.Sprintf("example.jp. 3600 IN SOA ns.example.jp. root.example.jp. %d 3600 900 1814400 900\n", serial)
+		text += "example.jp. 3600 IN NS ns.example.jp.\n"
+		for _, host := range hosts {
+			text += fmt.Sprintf("%s.example.jp. 300 IN A 192.0.2.%d\n", host, len(host))
+		}
+		return text
+	}
+	s.loadZone(t, "example.jp.", zone(1, "www"))
+	s.loadZone(t, "example.jp.", zone(2, "www", "mail"))
+	s.loadZone(t, "example.jp.", zone(3, "mail"))
+
+	ixfr := func(proto string, serial uint32) []*dns.Msg {
+		req := new(dns.Msg)
+		req.SetIxfr("example.jp.", serial, "ns.example.jp.", "root.example.jp.")
+		w := &testWriter{}
+		s.worker(proto).ServeDNS(w, req)
+		return w.msgs
+	}
+	for _, tc := range []struct {
+		proto  string
+		serial uint32
+		expect []string
+	}{
+		// current SOA, (SOA 1, SOA 2, +mail), (SOA 2, -www, SOA 3), current SOA
+		{"tcp", 1, []string{"SOA 3", "SOA 1", "SOA 2", "A mail", "SOA 2", "A www", "SOA 3", "SOA 3"}},
+		{"tcp", 2, []string{"SOA 3", "SOA 2", "A www", "SOA 3", "SOA 3"}},
+		// client is up to date
+		{"tcp", 3, []string{"SOA 3"}},
+		// history is not in journal, AXFR style response
+		{"tcp", 0, []string{"SOA 3", "A mail", "SOA 3"}},
+		// client retries with TCP
+		{"udp", 1, []string{"SOA 3"}},
+	} {
+		got := transferTexts(ixfr(tc.proto, tc.serial))
+		if strings.Join(got, ",") != strings.Join(tc.expect, ",") {
+			t.Errorf("IXFR from %d over %s need to be %v, but %v", tc.serial, tc.proto, tc.expect, got)
+		}
+	}
+}
@@ -0,0 +1,566 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"crypto"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/miekg/dns"
+	"github.com/rabbitdns/rabbitdns/lib/config"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrGlobKey            = errors.New("failed to glob key files.")
+	ErrReadKey            = errors.New("failed to read DNSKEY file.")
+	ErrReadPrivateKey     = errors.New("failed to read private key file.")
+	ErrNotSigner          = errors.New("private key can't be used for signing.")
+	ErrKeyZoneMismatch    = errors.New("DNSKEY owner is not zone apex.")
+	ErrReadKeyState       = errors.New("failed to read key state file.")
+	ErrWriteKey           = errors.New("failed to write key files.")
+	ErrGenerateKey        = errors.New("failed to generate key.")
+	ErrUnknownKeyType     = errors.New("key type must be ksk or zsk.")
+	ErrRolloverInProgress = errors.New("key rollover is already in progress.")
+)
+
+const (
+	KEY_GENERATED = "generated"
+	KEY_PUBLISHED = "published"
+	KEY_ACTIVE    = "active"
+	KEY_RETIRED   = "retired"
+	KEY_REMOVED   = "removed"
+)
+
+// keyState is timing metadata of a key (RFC 7583), saved in K*.state file.
+// Zero time means the event is not scheduled.
+type keyState struct {
+	KSK       bool
+	Generated time.Time
+	Published time.Time
+	Active    time.Time
+	Retired   time.Time
+	Removed   time.Time
+}
+
+func reached(t time.Time, now time.Time) bool {
+	return !t.IsZero() && !t.After(now)
+}
+
+func (s *keyState) isPublished(now time.Time) bool {
+	return reached(s.Published, now) && !reached(s.Removed, now)
+}
+
+func (s *keyState) isActive(now time.Time) bool {
+	return reached(s.Active, now) && !reached(s.Retired, now)
+}
+
+func (s *keyState) Status(now time.Time) string {
+	switch {
+	case reached(s.Removed, now):
+		return KEY_REMOVED
+	case reached(s.Retired, now):
+		return KEY_RETIRED
+	case reached(s.Active, now):
+		return KEY_ACTIVE
+	case reached(s.Published, now):
+		return KEY_PUBLISHED
+	}
+	return KEY_GENERATED
+}
+
+// changedBetween reports whether any timing event of the key happened in (from, to].
+func (s *keyState) changedBetween(from, to time.Time) bool {
+	for _, t := range []time.Time{s.Published, s.Active, s.Retired, s.Removed} {
+		if !t.IsZero() && t.After(from) && !t.After(to) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *keyState) String(keytag uint16, origin string, algorithm uint8) string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "; This is the state of key %d, for %s\n", keytag, origin)
+	fmt.Fprintf(b, "Algorithm: %d\n", algorithm)
+	if s.KSK {
+		fmt.Fprintf(b, "KSK: yes\n")
+	} else {
+		fmt.Fprintf(b, "KSK: no\n")
+	}
+	for _, t := range []struct {
+		name string
+		time time.Time
+	}{
+		{"Generated", s.Generated},
+		{"Published", s.Published},
+		{"Active", s.Active},
+		{"Retired", s.Retired},
+		{"Removed", s.Removed},
+	} {
+		if !t.time.IsZero() {
+			fmt.Fprintf(b, "%s: %s\n", t.name, dns.TimeToString(uint32(t.time.Unix())))
+		}
+	}
+	return b.String()
+}
+
+func parseKeyState(stateFile string) (*keyState, error) {
+	f, err := os.Open(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	state := &keyState{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return nil, ErrReadKeyState
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		var t *time.Time
+		switch key {
+		case "KSK":
+			state.KSK = value == "yes"
+			continue
+		case "Generated":
+			t = &state.Generated
+		case "Published":
+			t = &state.Published
+		case "Active":
+			t = &state.Active
+		case "Retired":
+			t = &state.Retired
+		case "Removed":
+			t = &state.Removed
+		default:
+			continue
+		}
+		sec, err := dns.StringToTime(value)
+		if err != nil {
+			return nil, errors.Wrap(ErrReadKeyState, key)
+		}
+		*t = time.Unix(int64(sec), 0)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+type signingKey struct {
+	DNSKEY *dns.DNSKEY
+	Signer crypto.Signer
+	KeyTag uint16
+	State  *keyState
+	// path of key files without extension
+	File string
+}
+
+// zoneKeys is the key set of a zone at a point in time.
+type zoneKeys struct {
+	KSK       []*signingKey
+	ZSK       []*signingKey
+	Published []*signingKey
+	// KSKs which parent DS RRset should point, published as CDS/CDNSKEY
+	CDS []*signingKey
+}
+
+func newZoneKeys(keys []*signingKey, now time.Time) *zoneKeys {
+	zk := &zoneKeys{}
+	for _, key := range keys {
+		if key.State.isPublished(now) {
+			zk.Published = append(zk.Published, key)
+		}
+		if !key.State.isActive(now) {
+			continue
+		}
+		if key.State.KSK {
+			zk.KSK = append(zk.KSK, key)
+			if key.State.Retired.IsZero() {
+				zk.CDS = append(zk.CDS, key)
+			}
+		} else {
+			zk.ZSK = append(zk.ZSK, key)
+		}
+	}
+	return zk
+}
+
+// signers returns keys which sign the RRset of rrtype.
+// DNSKEY RRset is signed by KSK, others by ZSK.
+// When the zone has only one kind of key, it is used as CSK.
+func (k *zoneKeys) signers(rrtype uint16) []*signingKey {
+	if rrtype == dns.TypeDNSKEY && len(k.KSK) > 0 {
+		return k.KSK
+	}
+	if len(k.ZSK) > 0 {
+		return k.ZSK
+	}
+	return k.KSK
+}
+
+func (k *zoneKeys) DNSKEYs() []dns.RR {
+	rrs := []dns.RR{}
+	for _, key := range k.Published {
+		rrs = append(rrs, key.DNSKEY)
+	}
+	return rrs
+}
+
+// CDSs returns CDS and CDNSKEY RRs for the parent (RFC 7344).
+func (k *zoneKeys) CDSs() []dns.RR {
+	rrs := []dns.RR{}
+	for _, key := range k.CDS {
+		rrs = append(rrs, key.DNSKEY.ToDS(dns.SHA256).ToCDS(), key.DNSKEY.ToCDNSKEY())
+	}
+	return rrs
+}
+
+type keyManager struct {
+	config *config.Config
+	// last time when key states were applied to the zone
+	checked map[string]time.Time
+}
+
+func NewKeyManager(c *config.Config) *keyManager {
+	return &keyManager{
+		config:  c,
+		checked: map[string]time.Time{},
+	}
+}
+
+// LoadKeys returns the key set of the zone at now.
+// It returns nil when the zone has no keys.
+func (m *keyManager) LoadKeys(origin string) (*zoneKeys, error) {
+	now := time.Now()
+	keys, err := m.readKeys(origin)
+	if err != nil {
+		return nil, err
+	}
+	m.checked[origin] = now
+	zk := newZoneKeys(keys, now)
+	if len(zk.Published) == 0 {
+		return nil, nil
+	}
+	return zk, nil
+}
+
+// GetKeys returns all keys of the zone in KeysDir.
+func (m *keyManager) GetKeys(origin string) ([]*signingKey, error) {
+	return m.readKeys(origin)
+}
+
+// readKeys reads BIND style key files (K<zone>+<alg>+<tag>.key, .private and .state)
+// of the zone from KeysDir.
+func (m *keyManager) readKeys(origin string) ([]*signingKey, error) {
+	matches, err := filepath.Glob(filepath.Join(m.config.KeysDir, "K"+origin+"+*.key"))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Type":  "lib/server/keyManager",
+			"Func":  "readKeys",
+			"Error": err,
+		}).Warn(ErrGlobKey)
+		return nil, ErrGlobKey
+	}
+	keys := []*signingKey{}
+	for _, f := range matches {
+		key, err := m.readKey(f)
+		if err != nil {
+			return nil, errors.Wrap(err, "file:"+f)
+		}
+		if !strings.EqualFold(key.DNSKEY.Header().Name, origin) {
+			return nil, errors.Wrap(ErrKeyZoneMismatch, "file:"+f)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (m *keyManager) readKey(keyFile string) (*signingKey, error) {
+	f, err := os.Open(keyFile)
+	if err != nil {
+		return nil, ErrReadKey
+	}
+	defer f.Close()
+	rr, err := dns.ReadRR(f, keyFile)
+	if err != nil {
+		return nil, errors.Wrap(ErrReadKey, err.Error())
+	}
+	dnskey, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, ErrReadKey
+	}
+
+	base := strings.TrimSuffix(keyFile, ".key")
+	privateFile := base + ".private"
+	p, err := os.Open(privateFile)
+	if err != nil {
+		return nil, ErrReadPrivateKey
+	}
+	defer p.Close()
+	privkey, err := dnskey.ReadPrivateKey(p, privateFile)
+	if err != nil {
+		return nil, errors.Wrap(ErrReadPrivateKey, err.Error())
+	}
+	signer, ok := privkey.(crypto.Signer)
+	if !ok {
+		return nil, ErrNotSigner
+	}
+	key := &signingKey{DNSKEY: dnskey, Signer: signer, KeyTag: dnskey.KeyTag(), File: base}
+
+	state, err := parseKeyState(base + ".state")
+	if os.IsNotExist(err) {
+		// key which is put by hand is used immediately
+		stat, err := os.Stat(keyFile)
+		if err != nil {
+			return nil, ErrReadKey
+		}
+		state = &keyState{
+			KSK:       dnskey.Flags&dns.SEP != 0,
+			Generated: stat.ModTime(),
+			Published: stat.ModTime(),
+			Active:    stat.ModTime(),
+		}
+		key.State = state
+		if err := m.writeState(key); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, errors.Wrap(ErrReadKeyState, err.Error())
+	}
+	key.State = state
+	return key, nil
+}
+
+func (m *keyManager) writeState(key *signingKey) error {
+	data := key.State.String(key.KeyTag, key.DNSKEY.Header().Name, key.DNSKEY.Algorithm)
+	if err := ioutil.WriteFile(key.File+".state", []byte(data), 0644); err != nil {
+		return errors.Wrap(ErrWriteKey, err.Error())
+	}
+	return nil
+}
+
+func keyBits(algorithm uint8) int {
+	switch algorithm {
+	case dns.RSASHA256, dns.RSASHA512:
+		return 2048
+	case dns.ECDSAP384SHA384:
+		return 384
+	}
+	return 256
+}
+
+// generateKey creates a new key of the zone into KeysDir.
+func (m *keyManager) generateKey(origin string, ksk bool, published, active time.Time) (*signingKey, error) {
+	dnskey := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: origin, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE,
+		Protocol:  3,
+		Algorithm: dns.StringToAlgorithm[m.config.KeyAlgorithm],
+	}
+	if ksk {
+		dnskey.Flags |= dns.SEP
+	}
+	privkey, err := dnskey.Generate(keyBits(dnskey.Algorithm))
+	if err != nil {
+		return nil, errors.Wrap(ErrGenerateKey, err.Error())
+	}
+	signer, ok := privkey.(crypto.Signer)
+	if !ok {
+		return nil, ErrNotSigner
+	}
+	dir := m.config.KeysDir
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(ErrWriteKey, err.Error())
+	}
+	key := &signingKey{
+		DNSKEY: dnskey,
+		Signer: signer,
+		KeyTag: dnskey.KeyTag(),
+		State:  &keyState{KSK: ksk, Generated: time.Now(), Published: published, Active: active},
+		File:   filepath.Join(dir, fmt.Sprintf("K%s+%03d+%05d", origin, dnskey.Algorithm, dnskey.KeyTag())),
+	}
+	if err := ioutil.WriteFile(key.File+".key", []byte(dnskey.String()+"\n"), 0644); err != nil {
+		return nil, errors.Wrap(ErrWriteKey, err.Error())
+	}
+	if err := ioutil.WriteFile(key.File+".private", []byte(dnskey.PrivateKeyString(privkey)), 0600); err != nil {
+		return nil, errors.Wrap(ErrWriteKey, err.Error())
+	}
+	if err := m.writeState(key); err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"Type":     "lib/server/keyManager",
+		"Func":     "generateKey",
+		"zonename": origin,
+		"keytag":   key.KeyTag,
+		"flags":    dnskey.Flags,
+	}).Info("generate key")
+	return key, nil
+}
+
+// Rollover starts a key rollover of the zone.
+// ZSK is rolled by pre-publish method, KSK is rolled by double signature method
+// and new KSK is announced to the parent by CDS/CDNSKEY.
+// When the zone has no key of the type, the first key is created and used immediately.
+func (m *keyManager) Rollover(origin string, keyType string, now time.Time) error {
+	var ksk bool
+	switch keyType {
+	case "ksk":
+		ksk = true
+	case "zsk":
+		ksk = false
+	default:
+		return ErrUnknownKeyType
+	}
+	keys, err := m.readKeys(origin)
+	if err != nil {
+		return err
+	}
+	current := []*signingKey{}
+	for _, key := range keys {
+		if key.State.KSK != ksk || reached(key.State.Removed, now) {
+			continue
+		}
+		if !key.State.Retired.IsZero() || !reached(key.State.Active, now) {
+			return ErrRolloverInProgress
+		}
+		current = append(current, key)
+	}
+	if len(current) == 0 {
+		_, err := m.generateKey(origin, ksk, now, now)
+		return err
+	}
+
+	safety := time.Duration(m.config.KeyPublishSafety) * time.Second
+	var retire, remove time.Time
+	if ksk {
+		// both KSKs sign DNSKEY RRset until the parent DS RRset is replaced
+		if _, err := m.generateKey(origin, ksk, now, now); err != nil {
+			return err
+		}
+		retire = now.Add(time.Duration(m.config.DSPropagationDelay) * time.Second)
+		remove = retire
+	} else {
+		// new ZSK is published before it is used
+		if _, err := m.generateKey(origin, ksk, now, now.Add(safety)); err != nil {
+			return err
+		}
+		retire = now.Add(safety)
+		remove = retire.Add(safety)
+	}
+	for _, key := range current {
+		key.State.Retired = retire
+		key.State.Removed = remove
+		if err := m.writeState(key); err != nil {
+			return err
+		}
+	}
+	log.WithFields(log.Fields{
+		"Type":     "lib/server/keyManager",
+		"Func":     "Rollover",
+		"zonename": origin,
+		"keytype":  keyType,
+	}).Info("start key rollover")
+	return nil
+}
+
+// rolloverDue reports whether the active key of the type reaches its lifetime.
+func (m *keyManager) rolloverDue(keys []*signingKey, ksk bool, now time.Time) bool {
+	lifetime := m.config.ZSKLifetime
+	prepublish := time.Duration(m.config.KeyPublishSafety) * time.Second
+	if ksk {
+		lifetime = m.config.KSKLifetime
+		prepublish = 0
+	}
+	if lifetime == 0 {
+		return false
+	}
+	for _, key := range keys {
+		if key.State.KSK != ksk || !key.State.Retired.IsZero() || !reached(key.State.Active, now) {
+			continue
+		}
+		due := key.State.Active.Add(time.Duration(lifetime)*time.Second - prepublish)
+		if !due.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Update proceeds key lifecycle of the zone, it starts timed rollovers and purges removed keys.
+// It reports whether the key set of the zone should be applied to the zone data.
+func (m *keyManager) Update(origin string, now time.Time) (bool, error) {
+	keys, err := m.readKeys(origin)
+	if err != nil || len(keys) == 0 {
+		return false, err
+	}
+	changed := false
+	for _, ksk := range []bool{false, true} {
+		if !m.rolloverDue(keys, ksk, now) {
+			continue
+		}
+		keyType := "zsk"
+		if ksk {
+			keyType = "ksk"
+		}
+		if err := m.Rollover(origin, keyType, now); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	if changed {
+		if keys, err = m.readKeys(origin); err != nil {
+			return false, err
+		}
+	}
+	checked, ok := m.checked[origin]
+	for _, key := range keys {
+		if ok && key.State.changedBetween(checked, now) {
+			changed = true
+		}
+		if reached(key.State.Removed, now) {
+			m.purgeKey(origin, key)
+		}
+	}
+	return changed, nil
+}
+
+func (m *keyManager) purgeKey(origin string, key *signingKey) {
+	for _, ext := range []string{".key", ".private", ".state"} {
+		os.Remove(key.File + ext)
+	}
+	log.WithFields(log.Fields{
+		"Type":     "lib/server/keyManager",
+		"Func":     "purgeKey",
+		"zonename": origin,
+		"keytag":   key.KeyTag,
+	}).Info("purge removed key")
+}
@@ -0,0 +1,50 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rabbitdns/rabbitdns/lib/config"
+)
+
+func TestKeyManagerKeysDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := NewKeyManager(&config.Config{KeysDir: dir, KeyAlgorithm: "ECDSAP256SHA256", KeyPublishSafety: 3600, DSPropagationDelay: 3600})
+	if err := m.Rollover("example.jp.", "zsk", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "Kexample.jp.+013+*.key"))
+	if len(matches) != 1 {
+		t.Fatalf("key files need to be created in KeysDir: %v", matches)
+	}
+	keys, err := m.LoadKeys("example.jp.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys == nil || len(keys.ZSK) != 1 || len(keys.Published) != 1 {
+		t.Errorf("key created in KeysDir need to be loaded: %+v", keys)
+	}
+}
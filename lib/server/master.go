@@ -19,6 +19,7 @@ import (
 	"context"
 	"net"
 	"os"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -27,6 +28,7 @@ import (
 	"github.com/pkg/errors"
 	api "github.com/rabbitdns/rabbitdns/api"
 	. "github.com/rabbitdns/rabbitdns/lib/config"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 )
@@ -46,7 +48,12 @@ type Master struct {
 
 	monitoringManager *monitoringManager
 	serviceManager    *serviceManager
+	keyManager        *keyManager
+	notifyManager     *notifyManager
 	zoneManager       *zoneManager
+	secondaryManager  *secondaryManager
+	updateManager     *updateManager
+	rrlManager        *rrlManager
 	reloadCh          chan bool
 	mutex             sync.Mutex
 }
@@ -62,7 +69,9 @@ func (m *Master) StartServ(ctx context.Context, c *Config) error {
 	m.config = c
 	m.monitoringManager = NewMonitoringManager(c)
 	m.serviceManager = NewServiceManager(c, m.monitoringManager)
-	m.zoneManager = NewZoneManager(c, m.serviceManager)
+	m.keyManager = NewKeyManager(c)
+	m.notifyManager = NewNotifyManager(c)
+	m.zoneManager = NewZoneManager(c, m.serviceManager, m.keyManager, m.notifyManager)
 
 	log.WithFields(log.Fields{
 		"Type": "lib/server/Master",
@@ -90,16 +99,68 @@ func (m *Master) StartServ(ctx context.Context, c *Config) error {
 	if err := m.zoneManager.LoadZones(); err != nil {
 		return err
 	}
+	tsigSecrets, err := c.TSIGSecrets()
+	if err != nil {
+		return err
+	}
+	m.secondaryManager = NewSecondaryManager(c, m.zoneManager, &m.mutex, tsigSecrets)
+	go m.secondaryManager.Run(ctx)
+	m.updateManager = NewUpdateManager(c, m.zoneManager, &m.mutex)
+	cookieManager, err := NewCookieManager(c)
+	if err != nil {
+		return err
+	}
+	m.rrlManager = NewRRLManager(c)
+	// servers of transports which are not served by dns.Server
+	servers := []interface{ Run() }{}
 	protocols := []string{"tcp", "udp"}
 	for _, addr := range m.config.Listens {
 		for _, proto := range protocols {
-			m.workers = append(m.workers, NewWorker(m.config, m.zoneManager, m.serviceManager, addr, proto))
+			worker := NewWorker(m.config, m.zoneManager, m.serviceManager, m.secondaryManager, m.updateManager, cookieManager, m.rrlManager, tsigSecrets, addr, proto)
+			switch {
+			case !m.config.IsProxyListen(addr):
+			case proto == "udp":
+				servers = append(servers, NewProxyUDPServer(c, worker))
+				continue
+			default:
+				if err := worker.listenProxy(); err != nil {
+					return err
+				}
+			}
+			m.workers = append(m.workers, worker)
+		}
+	}
+	if len(m.config.TLSListens) > 0 || len(m.config.HTTPSListens) > 0 || len(m.config.QUICListens) > 0 {
+		certManager, err := NewCertManager(c)
+		if err != nil {
+			return err
+		}
+		for _, addr := range m.config.TLSListens {
+			worker := NewWorker(m.config, m.zoneManager, m.serviceManager, m.secondaryManager, m.updateManager, cookieManager, m.rrlManager, tsigSecrets, addr, "tcp-tls")
+			worker.listener.TLSConfig = certManager.TLSConfig("dot")
+			if m.config.IsProxyListen(addr) {
+				if err := worker.listenProxy(); err != nil {
+					return err
+				}
+			}
+			m.workers = append(m.workers, worker)
+		}
+		for _, addr := range m.config.HTTPSListens {
+			worker := NewWorker(m.config, m.zoneManager, m.serviceManager, m.secondaryManager, m.updateManager, cookieManager, m.rrlManager, tsigSecrets, addr, "https")
+			servers = append(servers, NewDoHServer(c, worker, certManager.TLSConfig("h2", "http/1.1")))
+		}
+		for _, addr := range m.config.QUICListens {
+			worker := NewWorker(m.config, m.zoneManager, m.serviceManager, m.secondaryManager, m.updateManager, cookieManager, m.rrlManager, tsigSecrets, addr, "doq")
+			servers = append(servers, NewDoQServer(c, worker, certManager.TLSConfig("doq")))
 		}
 	}
 
 	for _, worker := range m.workers {
 		worker.Run()
 	}
+	for _, server := range servers {
+		server.Run()
+	}
 
 	go m.updateConfig(ctx)
 	server := grpc.NewServer()
@@ -154,6 +215,7 @@ func (m *Master) updateConfig(ctx context.Context) {
 			if m.config.AutoMonitorReconfig {
 				m.monitoringManager.DeleteMonitors()
 			}
+			m.zoneManager.UpdateKeys()
 			m.mutex.Unlock()
 		}
 	}
@@ -254,3 +316,105 @@ func (m *Master) GetServices(context.Context, *empty.Empty) (*api.GetServicesRes
 	return response, nil
 
 }
+func (m *Master) GetKeys(ctx context.Context, request *api.KeysRequest) (*api.GetKeysResponse, error) {
+	log.WithFields(log.Fields{
+		"Type":     "lib/server/Master",
+		"Func":     "GetKeys",
+		"zonename": request.Zonename,
+	}).Info("Receive request to get keys.")
+
+	origin := FQDN(request.Zonename)
+	m.mutex.Lock()
+	keys, err := m.keyManager.GetKeys(origin)
+	m.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	response := &api.GetKeysResponse{Keys: []*api.Key{}}
+	for _, v := range keys {
+		key := &api.Key{
+			Zonename:  origin,
+			Keytag:    uint32(v.KeyTag),
+			Algorithm: uint32(v.DNSKEY.Algorithm),
+			Keytype:   "zsk",
+			State:     v.State.Status(now),
+			Published: unixTime(v.State.Published),
+			Active:    unixTime(v.State.Active),
+			Retired:   unixTime(v.State.Retired),
+			Removed:   unixTime(v.State.Removed),
+		}
+		if v.State.KSK {
+			key.Keytype = "ksk"
+		}
+		response.Keys = append(response.Keys, key)
+	}
+	return response, nil
+}
+func (m *Master) RolloverKey(ctx context.Context, request *api.RolloverRequest) (*empty.Empty, error) {
+	log.WithFields(log.Fields{
+		"Type":     "lib/server/Master",
+		"Func":     "RolloverKey",
+		"zonename": request.Zonename,
+		"keytype":  request.Keytype,
+	}).Info("Receive request to rollover a key.")
+
+	response := &empty.Empty{}
+	origin := FQDN(request.Zonename)
+	filePath := m.config.ZonesDir + "/" + strings.TrimSuffix(request.Zonename, ".")
+	secondary := m.config.GetZoneConfig(origin).IsSecondary()
+
+	if _, err := os.Stat(filePath); err != nil && !secondary {
+		return nil, ErrReloadError
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.keyManager.Rollover(origin, request.Keytype, time.Now()); err != nil {
+		return nil, err
+	}
+	if secondary {
+		err := m.zoneManager.RefreshSecondaryZone(strings.ToLower(origin))
+		if err != nil && err != ErrSecondaryEmpty {
+			return nil, ErrReloadError
+		}
+		return response, nil
+	}
+	if err := m.zoneManager.RekeyZone(filePath); err != nil {
+		return nil, ErrReloadError
+	}
+	return response, nil
+}
+func (m *Master) GetRateLimit(context.Context, *empty.Empty) (*api.GetRateLimitResponse, error) {
+	log.WithFields(log.Fields{
+		"Type": "lib/server/Master",
+		"Func": "GetRateLimit",
+	}).Info("Receive request to get rate limit counters.")
+
+	stats, limited := m.rrlManager.Stats()
+	response := &api.GetRateLimitResponse{
+		Responses: stats.Responses,
+		Dropped:   stats.Dropped,
+		Slipped:   stats.Slipped,
+		Exempted:  stats.Exempted,
+		Entries:   uint32(stats.Entries),
+		Limited:   []*api.RateLimit{},
+	}
+	for _, e := range limited {
+		response.Limited = append(response.Limited, &api.RateLimit{
+			Prefix:   e.key.prefix,
+			Category: rrlCategoryString[e.key.category],
+			Token:    e.key.token,
+			Dropped:  e.dropped,
+			Slipped:  e.slipped,
+		})
+	}
+	return response, nil
+}
+
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
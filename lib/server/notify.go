@@ -0,0 +1,264 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rabbitdns/rabbitdns/lib/config"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNotifyNoAck   = errors.New("notify is not acknowledged.")
+	ErrNotifyRefused = errors.New("notify is not allowed.")
+)
+
+const (
+	notifyTimeout = 5 * time.Second
+	// interval and count of retransmission until notify is acknowledged
+	notifyInterval = 15 * time.Second
+	notifyRetries  = 5
+)
+
+// notifyManager sends NOTIFY (RFC 1996) of zone changes to secondary servers.
+type notifyManager struct {
+	config  *config.Config
+	mutex   sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+func NewNotifyManager(c *config.Config) *notifyManager {
+	return &notifyManager{
+		config:  c,
+		cancels: map[string]context.CancelFunc{},
+	}
+}
+
+// Notify sends NOTIFY of the zone in background.
+// Retransmission for the previous serial of the zone is stopped.
+func (m *notifyManager) Notify(origin string, zoneTree *Tree) {
+	soa := zoneSOA(zoneTree, origin)
+	if soa == nil {
+		return
+	}
+	addrs, names := m.targets(origin, soa, zoneTree)
+	ctx, cancel := context.WithCancel(context.Background())
+	m.mutex.Lock()
+	if prev, ok := m.cancels[origin]; ok {
+		prev()
+	}
+	m.cancels[origin] = cancel
+	m.mutex.Unlock()
+
+	go m.send(ctx, origin, soa, addrs, names)
+}
+
+// targets returns addresses of also-notify and in-zone NS hosts,
+// and names of NS hosts which need to be resolved.
+// The primary master written in SOA MNAME is not notified.
+func (m *notifyManager) targets(origin string, soa *dns.SOA, zoneTree *Tree) ([]string, []string) {
+	addrs := []string{}
+	for _, addr := range m.config.GetZoneConfig(origin).AlsoNotify {
+		addrs = append(addrs, HostPort(addr, "53"))
+	}
+	names := []string{}
+	apex := zoneTree.SearchNode(Labels(origin), true)
+	nsRRs, _ := apex.GetRR(dns.TypeNS)
+	for _, rr := range nsRRs {
+		ns, ok := rr.(*dns.NS)
+		if !ok || strings.EqualFold(ns.Ns, soa.Ns) {
+			continue
+		}
+		if !dns.IsSubDomain(origin, ns.Ns) {
+			names = append(names, ns.Ns)
+			continue
+		}
+		node := zoneTree.SearchNode(Labels(ns.Ns), true)
+		if node == nil {
+			continue
+		}
+		for _, rrtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			rrs, _ := node.GetRR(rrtype)
+			for _, rr := range rrs {
+				switch glue := rr.(type) {
+				case *dns.A:
+					addrs = append(addrs, HostPort(glue.A.String(), "53"))
+				case *dns.AAAA:
+					addrs = append(addrs, HostPort(glue.AAAA.String(), "53"))
+				}
+			}
+		}
+	}
+	return addrs, names
+}
+
+func (m *notifyManager) send(ctx context.Context, origin string, soa *dns.SOA, addrs []string, names []string) {
+	for _, name := range names {
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Type":     "lib/server/notifyManager",
+				"Func":     "send",
+				"Error":    err,
+				"zonename": origin,
+				"ns":       name,
+			}).Debug("failed to resolve NS host")
+			continue
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, HostPort(ip.IP.String(), "53"))
+		}
+	}
+	sent := map[string]bool{}
+	local := localIPs()
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		if sent[addr] || isListenAddr(m.config.Listens, local, addr) {
+			// don't notify myself
+			continue
+		}
+		sent[addr] = true
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			m.sendTo(ctx, origin, soa, addr)
+		}(addr)
+	}
+	wg.Wait()
+}
+
+// localIPs returns addresses of the interfaces of the server.
+func localIPs() []net.IP {
+	ips := []net.IP{}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ips
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	return ips
+}
+
+// isListenAddr reports whether the server receives queries sent to addr.
+// Wildcard listen addresses are matched to addresses of the interfaces.
+func isListenAddr(listens []string, local []net.IP, addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, listen := range listens {
+		lhost, lport, err := net.SplitHostPort(HostPort(listen, "53"))
+		if err != nil || lport != port {
+			continue
+		}
+		lip := net.ParseIP(lhost)
+		if lhost != "" && lip == nil {
+			continue
+		}
+		if lhost != "" && !lip.IsUnspecified() {
+			if lip.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		for _, l := range local {
+			if l.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sendTo retransmits NOTIFY until the server responds.
+func (m *notifyManager) sendTo(ctx context.Context, origin string, soa *dns.SOA, addr string) {
+	msg := new(dns.Msg)
+	msg.SetNotify(origin)
+	msg.Authoritative = true
+	msg.Answer = []dns.RR{soa}
+	c := &dns.Client{Timeout: notifyTimeout}
+	for i := 0; i < notifyRetries; i++ {
+		r, _, err := c.Exchange(msg, addr)
+		if err == nil && r.Opcode == dns.OpcodeNotify {
+			log.WithFields(log.Fields{
+				"Type":     "lib/server/notifyManager",
+				"Func":     "sendTo",
+				"zonename": origin,
+				"serial":   soa.Serial,
+				"target":   addr,
+				"rcode":    dns.RcodeToString[r.Rcode],
+			}).Info("notify is acknowledged")
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(notifyInterval):
+		}
+	}
+	log.WithFields(log.Fields{
+		"Type":     "lib/server/notifyManager",
+		"Func":     "sendTo",
+		"zonename": origin,
+		"serial":   soa.Serial,
+		"target":   addr,
+	}).Warn(ErrNotifyNoAck)
+}
+
+// serveNotify handles NOTIFY for secondary zones, and starts refresh of the zone.
+func (s *worker) serveNotify(w dns.ResponseWriter, m *dns.Msg, req *dns.Msg) {
+	zoneName := strings.ToLower(FQDN(req.Question[0].Name))
+	ip := AddrIP(w.RemoteAddr())
+	switch {
+	case req.Question[0].Qtype != dns.TypeSOA:
+		m.Rcode = dns.RcodeFormatError
+	case !s.secondaryManager.IsSecondary(zoneName):
+		m.Rcode = dns.RcodeNotAuth
+	case !s.secondaryManager.allowNotify(zoneName, ip, requestKey(req)):
+		log.WithFields(log.Fields{
+			"Type":     "lib/server/Worker",
+			"Func":     "serveNotify",
+			"zonename": zoneName,
+			"client":   w.RemoteAddr().String(),
+		}).Warn(ErrNotifyRefused)
+		s.refused(m)
+	default:
+		log.WithFields(log.Fields{
+			"Type":     "lib/server/Worker",
+			"Func":     "serveNotify",
+			"zonename": zoneName,
+			"client":   w.RemoteAddr().String(),
+		}).Info("receive notify")
+		m.MsgHdr.Authoritative = true
+		s.secondaryManager.Refresh(zoneName)
+	}
+	s.writeMsg(w, m, req)
+}
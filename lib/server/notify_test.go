@@ -0,0 +1,44 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsListenAddr(t *testing.T) {
+	local := []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("192.0.2.53"), net.ParseIP("2001:db8::53")}
+	testcases := []struct {
+		listens []string
+		addr    string
+		self    bool
+	}{
+		{[]string{"0.0.0.0:53"}, "192.0.2.53:53", true},
+		{[]string{"[::]:53"}, "[2001:db8::53]:53", true},
+		{[]string{":53"}, "127.0.0.1:53", true},
+		{[]string{"0.0.0.0:53"}, "192.0.2.1:53", false},
+		{[]string{"0.0.0.0:53"}, "192.0.2.53:5301", false},
+		{[]string{"127.0.0.1:5300"}, "127.0.0.1:5300", true},
+		{[]string{"127.0.0.1:5300"}, "192.0.2.53:5300", false},
+		{[]string{"localhost:53"}, "192.0.2.53:53", false},
+	}
+	for _, tc := range testcases {
+		if isListenAddr(tc.listens, local, tc.addr) != tc.self {
+			t.Errorf("%s with listens %v need to be self(%v)", tc.addr, tc.listens, tc.self)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
+)
+
+// negativeTTL returns min(SOA TTL, SOA MINIMUM) of the zone (RFC 2308, RFC 9077).
+func negativeTTL(zoneName string, zoneTree *Tree) uint32 {
+	soa := zoneSOA(zoneTree, zoneName)
+	if soa == nil {
+		return 0
+	}
+	if soa.Minttl < soa.Hdr.Ttl {
+		return soa.Minttl
+	}
+	return soa.Hdr.Ttl
+}
+
+func newNSEC(owner, next string, ttl uint32, types []uint16) *dns.NSEC {
+	return &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: owner, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: ttl},
+		NextDomain: next,
+		TypeBitMap: types,
+	}
+}
+
+func removeType(types []uint16, rrtype uint16) []uint16 {
+	result := []uint16{}
+	for _, t := range types {
+		if t != rrtype {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// deniedName returns the name and type which the negative answer is about.
+// When the answer is a CNAME chain, it is the last target.
+func deniedName(m *dns.Msg, qname string, qtype uint16) (string, bool) {
+	if len(m.Answer) == 0 {
+		return qname, true
+	}
+	if qtype == dns.TypeANY {
+		// any RRset is the answer of ANY
+		return "", false
+	}
+	last := m.Answer[len(m.Answer)-1]
+	if last.Header().Rrtype == qtype {
+		return "", false
+	}
+	if cname, ok := last.(*dns.CNAME); ok {
+		return cname.Target, true
+	}
+	return "", false
+}
+
+// closestEncloser returns the deepest existing ancestor of sname.
+func closestEncloser(zoneTree *Tree, sname string) *Tree {
+	ce, _ := zoneTree.ClosestEncloser(Labels(sname))
+	return ce
+}
+
+// addDenial adds NSEC RRs which prove negative answers generated on the fly.
+// compact mode answers NXDOMAIN as NODATA with NXNAME type ("black lies"),
+// minimal mode answers with minimally covering NSEC RRs (RFC 4470).
+func (s *worker) addDenial(m *dns.Msg, req *dns.Msg, zoneName string, zoneTree *Tree) {
+	ttl := negativeTTL(zoneName, zoneTree)
+	if !m.MsgHdr.Authoritative {
+		s.addDelegationDenial(m, ttl)
+		return
+	}
+	qtype := req.Question[0].Qtype
+	sname, ok := deniedName(m, req.Question[0].Name, qtype)
+	if !ok || !dns.IsSubDomain(zoneName, sname) {
+		return
+	}
+	sname = strings.ToLower(FQDN(sname))
+
+	if m.Rcode == dns.RcodeNameError {
+		switch s.config.DenialOfExistence {
+		case "minimal":
+			m.Ns = append(m.Ns, newNSEC(PredecessorName(sname, zoneName), SuccessorName(sname), ttl, []uint16{dns.TypeRRSIG, dns.TypeNSEC}))
+			ce := closestEncloser(zoneTree, sname)
+			wildcard := strings.ToLower("*." + ce.Label)
+			if PredecessorName(wildcard, zoneName) != PredecessorName(sname, zoneName) {
+				m.Ns = append(m.Ns, newNSEC(PredecessorName(wildcard, zoneName), SuccessorName(wildcard), ttl, []uint16{dns.TypeRRSIG, dns.TypeNSEC}))
+			}
+		default:
+			m.Rcode = dns.RcodeSuccess
+			m.Ns = append(m.Ns, newNSEC(sname, SuccessorName(sname), ttl, []uint16{dns.TypeRRSIG, dns.TypeNSEC, TypeNXNAME}))
+		}
+		return
+	}
+	if m.Rcode != dns.RcodeSuccess {
+		return
+	}
+	// NODATA
+	types := []uint16{dns.TypeRRSIG, dns.TypeNSEC}
+	if node, exact := zoneTree.ClosestEncloser(Labels(sname)); exact {
+		types = node.NSECTypes()
+	} else if wildcard := node.Wildcard(); wildcard != nil {
+		types = wildcard.NSECTypes()
+	}
+	m.Ns = append(m.Ns, newNSEC(sname, SuccessorName(sname), ttl, removeType(types, qtype)))
+}
+
+// addDelegationDenial proves that the delegation has no DS.
+func (s *worker) addDelegationDenial(m *dns.Msg, ttl uint32) {
+	var cut string
+	for _, rr := range m.Ns {
+		switch rr.Header().Rrtype {
+		case dns.TypeDS:
+			return
+		case dns.TypeNS:
+			cut = rr.Header().Name
+		}
+	}
+	if cut == "" {
+		return
+	}
+	m.Ns = append(m.Ns, newNSEC(strings.ToLower(cut), SuccessorName(cut), ttl, []uint16{dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC}))
+}
@@ -0,0 +1,130 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
+)
+
+const denialTestZone = `
+example.jp. 3600 IN SOA ns.example.jp. root.example.jp. 1 3600 900 1814400 900
+example.jp. 3600 IN NS ns.example.jp.
+ns.example.jp. 300 IN A 192.0.2.53
+www.example.jp. 300 IN A 192.0.2.1
+dyn.example.jp. 300 IN DYNA dyn-a
+sub.example.jp. 3600 IN NS ns.sub.example.jp.
+ns.sub.example.jp. 3600 IN A 192.0.2.54
+`
+
+func TestCompactDenial(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.addService("dyn-a", dns.TypeA, "dyn.example.jp. 60 IN A 192.0.2.100")
+	s.signZone(t, "example.jp.")
+	s.loadZone(t, "example.jp.", denialTestZone)
+
+	runQueryTests(t, s.worker("udp"), []queryTest{
+		{
+			// NXDOMAIN is answered as NODATA with NXNAME ("black lies")
+			qname: "nx.example.jp.", qtype: dns.TypeA, do: true, rcode: dns.RcodeSuccess, aa: true,
+			ns: []string{
+				"example.jp. SOA", "example.jp. RRSIG SOA",
+				"nx.example.jp. NSEC \\000.nx.example.jp. RRSIG NSEC TYPE128", "nx.example.jp. RRSIG NSEC",
+			},
+		},
+		{
+			qname: "nx.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeNameError, aa: true,
+			ns: []string{"example.jp. SOA"},
+		},
+		{
+			qname: "www.example.jp.", qtype: dns.TypeTXT, do: true, rcode: dns.RcodeSuccess, aa: true,
+			ns: []string{
+				"example.jp. SOA", "example.jp. RRSIG SOA",
+				"www.example.jp. NSEC \\000.www.example.jp. A RRSIG NSEC", "www.example.jp. RRSIG NSEC",
+			},
+		},
+		{
+			// types generated by DYN* RRs are in the bitmap
+			qname: "dyn.example.jp.", qtype: dns.TypeAAAA, do: true, rcode: dns.RcodeSuccess, aa: true,
+			ns: []string{
+				"example.jp. SOA", "example.jp. RRSIG SOA",
+				"dyn.example.jp. NSEC \\000.dyn.example.jp. A RRSIG NSEC", "dyn.example.jp. RRSIG NSEC",
+			},
+		},
+		{
+			// insecure delegation
+			qname: "www.sub.example.jp.", qtype: dns.TypeA, do: true, rcode: dns.RcodeSuccess, aa: false,
+			ns: []string{
+				"sub.example.jp. NS ns.sub.example.jp.",
+				"sub.example.jp. NSEC \\000.sub.example.jp. NS RRSIG NSEC", "sub.example.jp. RRSIG NSEC",
+			},
+		},
+	})
+}
+
+func TestMinimalDenial(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.config.DenialOfExistence = "minimal"
+	s.addService("dyn-a", dns.TypeA, "dyn.example.jp. 60 IN A 192.0.2.100")
+	s.signZone(t, "example.jp.")
+	s.loadZone(t, "example.jp.", denialTestZone)
+	w := s.worker("udp")
+
+	runQueryTests(t, w, []queryTest{
+		{
+			qname: "nx.example.jp.", qtype: dns.TypeA, do: true, rcode: dns.RcodeNameError, aa: true,
+			ns: []string{
+				"example.jp. SOA", "example.jp. RRSIG SOA",
+				PredecessorName("nx.example.jp.", "example.jp.") + " NSEC \\000.nx.example.jp. RRSIG NSEC",
+				PredecessorName("nx.example.jp.", "example.jp.") + " RRSIG NSEC",
+				PredecessorName("*.example.jp.", "example.jp.") + " NSEC \\000.*.example.jp. RRSIG NSEC",
+				PredecessorName("*.example.jp.", "example.jp.") + " RRSIG NSEC",
+			},
+		},
+		{
+			qname: "www.example.jp.", qtype: dns.TypeTXT, do: true, rcode: dns.RcodeSuccess, aa: true,
+			ns: []string{
+				"example.jp. SOA", "example.jp. RRSIG SOA",
+				"www.example.jp. NSEC \\000.www.example.jp. A RRSIG NSEC", "www.example.jp. RRSIG NSEC",
+			},
+		},
+	})
+
+	// NSEC RRs cover qname and the wildcard, but no existing name
+	m := query(w, "nx.example.jp.", dns.TypeA, true)
+	covered := map[string]bool{}
+	for _, rr := range m.Ns {
+		nsec, ok := rr.(*dns.NSEC)
+		if !ok {
+			continue
+		}
+		for _, name := range []string{"nx.example.jp.", "*.example.jp.", "ns.example.jp.", "www.example.jp."} {
+			if CanonicalCompare(nsec.Hdr.Name, name) < 0 && CanonicalCompare(name, nsec.NextDomain) < 0 {
+				covered[name] = true
+			}
+		}
+	}
+	if !covered["nx.example.jp."] || !covered["*.example.jp."] {
+		t.Errorf("NSEC need to cover qname and wildcard: %v", m.Ns)
+	}
+	if covered["ns.example.jp."] || covered["www.example.jp."] {
+		t.Errorf("NSEC must not cover existing names: %v", m.Ns)
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
+)
+
+// signedZone holds denial of existence chain of pre-signed zone.
+type signedZone struct {
+	nsec       []*dns.NSEC
+	nsec3      []*dns.NSEC3
+	nsec3Sigs  map[string][]dns.RR
+	nsec3param *dns.NSEC3PARAM
+}
+
+func newSignedZone() *signedZone {
+	return &signedZone{
+		nsec:      []*dns.NSEC{},
+		nsec3:     []*dns.NSEC3{},
+		nsec3Sigs: map[string][]dns.RR{},
+	}
+}
+
+func nsec3Hash(owner string) string {
+	return strings.ToUpper(dns.SplitDomainName(owner)[0])
+}
+
+func (z *signedZone) addNSEC(rr *dns.NSEC) {
+	z.nsec = append(z.nsec, rr)
+}
+
+func (z *signedZone) addNSEC3(rr *dns.NSEC3) {
+	z.nsec3 = append(z.nsec3, rr)
+	if z.nsec3param == nil {
+		z.nsec3param = &dns.NSEC3PARAM{Hash: rr.Hash, Iterations: rr.Iterations, SaltLength: rr.SaltLength, Salt: rr.Salt}
+	}
+}
+
+func (z *signedZone) addNSEC3Sig(sig *dns.RRSIG) {
+	hash := nsec3Hash(sig.Header().Name)
+	z.nsec3Sigs[hash] = append(z.nsec3Sigs[hash], sig)
+}
+
+// sort sorts the chains, it must be called after all records are added.
+func (z *signedZone) sort() {
+	sort.Slice(z.nsec, func(i, j int) bool {
+		return CanonicalCompare(z.nsec[i].Header().Name, z.nsec[j].Header().Name) < 0
+	})
+	sort.Slice(z.nsec3, func(i, j int) bool {
+		return nsec3Hash(z.nsec3[i].Header().Name) < nsec3Hash(z.nsec3[j].Header().Name)
+	})
+}
+
+func (z *signedZone) isNSEC3() bool {
+	return len(z.nsec3) > 0
+}
+
+// coveringNSEC returns NSEC which matches or covers name.
+func (z *signedZone) coveringNSEC(name string) *dns.NSEC {
+	if len(z.nsec) == 0 {
+		return nil
+	}
+	i := sort.Search(len(z.nsec), func(i int) bool {
+		return CanonicalCompare(z.nsec[i].Header().Name, name) > 0
+	})
+	if i == 0 {
+		// wrap around
+		return z.nsec[len(z.nsec)-1]
+	}
+	return z.nsec[i-1]
+}
+
+func (z *signedZone) hash(name string) string {
+	return dns.HashName(strings.ToLower(name), z.nsec3param.Hash, z.nsec3param.Iterations, z.nsec3param.Salt)
+}
+
+// matchNSEC3 returns NSEC3 whose hashed owner matches name.
+func (z *signedZone) matchNSEC3(name string) *dns.NSEC3 {
+	if !z.isNSEC3() {
+		return nil
+	}
+	hash := z.hash(name)
+	i := sort.Search(len(z.nsec3), func(i int) bool {
+		return nsec3Hash(z.nsec3[i].Header().Name) >= hash
+	})
+	if i < len(z.nsec3) && nsec3Hash(z.nsec3[i].Header().Name) == hash {
+		return z.nsec3[i]
+	}
+	return nil
+}
+
+// coveringNSEC3 returns NSEC3 which covers hashed name.
+func (z *signedZone) coveringNSEC3(name string) *dns.NSEC3 {
+	if !z.isNSEC3() {
+		return nil
+	}
+	hash := z.hash(name)
+	i := sort.Search(len(z.nsec3), func(i int) bool {
+		return nsec3Hash(z.nsec3[i].Header().Name) >= hash
+	})
+	if i == 0 {
+		return z.nsec3[len(z.nsec3)-1]
+	}
+	return z.nsec3[i-1]
+}
+
+// nextCloser returns the name one label longer than closest encloser.
+func nextCloser(name, ce string) string {
+	labels := dns.SplitDomainName(name)
+	n := dns.CountLabel(ce) + 1
+	if n > len(labels) {
+		return name
+	}
+	return dns.Fqdn(strings.Join(labels[len(labels)-n:], "."))
+}
+
+// closestProof returns NSEC3 RRs of closest encloser proof (RFC 5155 section 7.2.1).
+func (z *signedZone) closestProof(name string, zoneTree *Tree) (string, []dns.RR) {
+	rrs := []dns.RR{}
+	ce := closestEncloser(zoneTree, name).Label
+	for z.matchNSEC3(ce) == nil {
+		labels := dns.SplitDomainName(ce)
+		if len(labels) <= 1 {
+			return ce, rrs
+		}
+		ce = dns.Fqdn(strings.Join(labels[1:], "."))
+	}
+	rrs = append(rrs, z.matchNSEC3(ce))
+	if covering := z.coveringNSEC3(nextCloser(name, ce)); covering != nil {
+		rrs = append(rrs, covering)
+	}
+	return ce, rrs
+}
+
+// addDenialRRs appends RRs into authority section without duplication.
+func addDenialRRs(m *dns.Msg, rrs ...dns.RR) {
+NEXT:
+	for _, rr := range rrs {
+		if rr == nil {
+			continue
+		}
+		for _, current := range m.Ns {
+			if current == rr {
+				continue NEXT
+			}
+		}
+		m.Ns = append(m.Ns, rr)
+	}
+}
+
+// wildcardSource returns wildcard node which synthesized answer of sname.
+func wildcardSource(zoneTree *Tree, sname string) *Tree {
+	ce, exact := zoneTree.ClosestEncloser(Labels(sname))
+	if exact {
+		return nil
+	}
+	return ce.Wildcard()
+}
+
+// addPreSignedDenial adds NSEC or NSEC3 RRs of pre-signed zone into the response.
+func (s *worker) addPreSignedDenial(m *dns.Msg, req *dns.Msg, zoneName string, zoneTree *Tree, z *signedZone) {
+	if !m.MsgHdr.Authoritative {
+		s.addPreSignedDelegationDenial(m, zoneTree, z)
+		return
+	}
+	// proof of no closer match for wildcard answer
+	for _, rr := range m.Answer {
+		owner := rr.Header().Name
+		if !dns.IsSubDomain(zoneName, owner) || wildcardSource(zoneTree, owner) == nil {
+			continue
+		}
+		if z.isNSEC3() {
+			ce := closestEncloser(zoneTree, owner).Label
+			addDenialRRs(m, nsec3RR(z.coveringNSEC3(nextCloser(owner, ce))))
+		} else {
+			addDenialRRs(m, nsecRR(z.coveringNSEC(owner)))
+		}
+	}
+
+	qtype := req.Question[0].Qtype
+	sname, ok := deniedName(m, req.Question[0].Name, qtype)
+	if !ok || !dns.IsSubDomain(zoneName, sname) {
+		return
+	}
+	switch m.Rcode {
+	case dns.RcodeNameError:
+		if z.isNSEC3() {
+			ce, rrs := z.closestProof(sname, zoneTree)
+			addDenialRRs(m, rrs...)
+			addDenialRRs(m, nsec3RR(z.coveringNSEC3("*."+ce)))
+		} else {
+			ce := closestEncloser(zoneTree, sname)
+			addDenialRRs(m, nsecRR(z.coveringNSEC(sname)), nsecRR(z.coveringNSEC("*."+ce.Label)))
+		}
+	case dns.RcodeSuccess:
+		// NODATA
+		wildcard := wildcardSource(zoneTree, sname)
+		if z.isNSEC3() {
+			if match := z.matchNSEC3(sname); match != nil {
+				addDenialRRs(m, match)
+			} else {
+				_, rrs := z.closestProof(sname, zoneTree)
+				addDenialRRs(m, rrs...)
+			}
+			if wildcard != nil {
+				addDenialRRs(m, nsec3RR(z.matchNSEC3(wildcard.Label)))
+			}
+		} else {
+			addDenialRRs(m, nsecRR(z.coveringNSEC(sname)))
+			if wildcard != nil {
+				addDenialRRs(m, nsecRR(z.coveringNSEC(wildcard.Label)))
+			}
+		}
+	}
+}
+
+// addPreSignedDelegationDenial proves that the delegation has no DS.
+func (s *worker) addPreSignedDelegationDenial(m *dns.Msg, zoneTree *Tree, z *signedZone) {
+	var cut string
+	for _, rr := range m.Ns {
+		switch rr.Header().Rrtype {
+		case dns.TypeDS:
+			return
+		case dns.TypeNS:
+			cut = rr.Header().Name
+		}
+	}
+	if cut == "" {
+		return
+	}
+	if z.isNSEC3() {
+		if match := z.matchNSEC3(cut); match != nil {
+			addDenialRRs(m, match)
+		} else {
+			// opt-out
+			_, rrs := z.closestProof(cut, zoneTree)
+			addDenialRRs(m, rrs...)
+		}
+		return
+	}
+	if node := zoneTree.SearchNode(Labels(cut), true); node != nil {
+		if rrs, ok := node.GetRR(dns.TypeNSEC); ok {
+			addDenialRRs(m, rrs...)
+		}
+	}
+}
+
+// nsecRR avoids typed nil in dns.RR interface.
+func nsecRR(rr *dns.NSEC) dns.RR {
+	if rr == nil {
+		return nil
+	}
+	return rr
+}
+
+func nsec3RR(rr *dns.NSEC3) dns.RR {
+	if rr == nil {
+		return nil
+	}
+	return rr
+}
+
+// preSignedSigs returns RRSIGs of rrset from zone data.
+func preSignedSigs(zoneTree *Tree, z *signedZone, rrset []dns.RR) []dns.RR {
+	hdr := rrset[0].Header()
+	if hdr.Rrtype == dns.TypeNSEC3 {
+		return z.nsec3Sigs[nsec3Hash(hdr.Name)]
+	}
+	if node := zoneTree.SearchNode(Labels(hdr.Name), true); node != nil {
+		sigs, _ := node.GetSig(hdr.Rrtype)
+		return sigs
+	}
+	// answer synthesized from wildcard
+	wildcard := wildcardSource(zoneTree, hdr.Name)
+	if wildcard == nil {
+		return nil
+	}
+	wsigs, _ := wildcard.GetSig(hdr.Rrtype)
+	sigs := []dns.RR{}
+	for _, sig := range wsigs {
+		sig = dns.Copy(sig)
+		sig.Header().Name = hdr.Name
+		sigs = append(sigs, sig)
+	}
+	return sigs
+}
+
+// attachPreSignedSigs adds RRSIGs of pre-signed zone just after each RRset.
+func attachPreSignedSigs(zoneTree *Tree, z *signedZone, section []dns.RR) []dns.RR {
+	result := []dns.RR{}
+	for _, rrset := range splitRRsets(section) {
+		result = append(result, rrset...)
+		result = append(result, preSignedSigs(zoneTree, z, rrset)...)
+	}
+	return result
+}
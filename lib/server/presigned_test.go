@@ -0,0 +1,249 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// presignedRRSIG returns RRSIG line of the RRset.
+// Signatures are not validated by the server, so they are dummy.
+func presignedRRSIG(owner string, ttl int, covered string, signer string) string {
+	labels := dns.CountLabel(owner)
+	if strings.HasPrefix(owner, "*.") {
+		labels--
+	}
+	return fmt.Sprintf("%s %d IN RRSIG %s 13 %d %d 20370101000000 20180101000000 12345 %s c2lnbmF0dXJl\n", owner, ttl, covered, labels, ttl, signer)
+}
+
+// NSEC chain: example.jp. ns sub *.wild www
+var nsecTestZone = "" +
+	"example.jp. 3600 IN SOA ns.example.jp. root.example.jp. 1 3600 900 1814400 900\n" +
+	presignedRRSIG("example.jp.", 3600, "SOA", "example.jp.") +
+	"example.jp. 3600 IN NS ns.example.jp.\n" +
+	presignedRRSIG("example.jp.", 3600, "NS", "example.jp.") +
+	"example.jp. 900 IN NSEC ns.example.jp. NS SOA RRSIG NSEC\n" +
+	presignedRRSIG("example.jp.", 900, "NSEC", "example.jp.") +
+	"ns.example.jp. 300 IN A 192.0.2.53\n" +
+	presignedRRSIG("ns.example.jp.", 300, "A", "example.jp.") +
+	"ns.example.jp. 900 IN NSEC sub.example.jp. A RRSIG NSEC\n" +
+	presignedRRSIG("ns.example.jp.", 900, "NSEC", "example.jp.") +
+	"sub.example.jp. 3600 IN NS ns.sub.example.jp.\n" +
+	"ns.sub.example.jp. 3600 IN A 192.0.2.54\n" +
+	"sub.example.jp. 900 IN NSEC *.wild.example.jp. NS RRSIG NSEC\n" +
+	presignedRRSIG("sub.example.jp.", 900, "NSEC", "example.jp.") +
+	"*.wild.example.jp. 300 IN A 192.0.2.3\n" +
+	presignedRRSIG("*.wild.example.jp.", 300, "A", "example.jp.") +
+	"*.wild.example.jp. 900 IN NSEC www.example.jp. A RRSIG NSEC\n" +
+	presignedRRSIG("*.wild.example.jp.", 900, "NSEC", "example.jp.") +
+	"www.example.jp. 300 IN A 192.0.2.1\n" +
+	presignedRRSIG("www.example.jp.", 300, "A", "example.jp.") +
+	"www.example.jp. 900 IN NSEC example.jp. A RRSIG NSEC\n" +
+	presignedRRSIG("www.example.jp.", 900, "NSEC", "example.jp.")
+
+func TestPreSignedNSEC(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.loadZone(t, "example.jp.", nsecTestZone)
+
+	runQueryTests(t, s.worker("udp"), []queryTest{
+		{
+			qname: "www.example.jp.", qtype: dns.TypeA, do: true, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"www.example.jp. A 192.0.2.1", "www.example.jp. RRSIG A"},
+		},
+		{
+			qname: "www.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"www.example.jp. A 192.0.2.1"},
+		},
+		{
+			// NSEC covers qname, and NSEC covers wildcard at closest encloser
+			qname: "nx.example.jp.", qtype: dns.TypeA, do: true, rcode: dns.RcodeNameError, aa: true,
+			ns: []string{
+				"example.jp. SOA", "example.jp. RRSIG SOA",
+				"ns.example.jp. NSEC sub.example.jp. A RRSIG NSEC", "ns.example.jp. RRSIG NSEC",
+				"example.jp. NSEC ns.example.jp. NS SOA RRSIG NSEC", "example.jp. RRSIG NSEC",
+			},
+		},
+		{
+			qname: "www.example.jp.", qtype: dns.TypeTXT, do: true, rcode: dns.RcodeSuccess, aa: true,
+			ns: []string{
+				"example.jp. SOA", "example.jp. RRSIG SOA",
+				"www.example.jp. NSEC example.jp. A RRSIG NSEC", "www.example.jp. RRSIG NSEC",
+			},
+		},
+		{
+			// empty non-terminal is covered by NSEC
+			qname: "wild.example.jp.", qtype: dns.TypeA, do: true, rcode: dns.RcodeSuccess, aa: true,
+			ns: []string{
+				"example.jp. SOA", "example.jp. RRSIG SOA",
+				"sub.example.jp. NSEC *.wild.example.jp. NS RRSIG NSEC", "sub.example.jp. RRSIG NSEC",
+			},
+		},
+		{
+			// wildcard answer has RRSIG of the source of synthesis, and proof of no closer match
+			qname: "host.wild.example.jp.", qtype: dns.TypeA, do: true, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"host.wild.example.jp. A 192.0.2.3", "host.wild.example.jp. RRSIG A"},
+			ns: []string{
+				"*.wild.example.jp. NSEC www.example.jp. A RRSIG NSEC", "*.wild.example.jp. RRSIG NSEC",
+			},
+		},
+		{
+			// NSEC of delegation proves no DS, NS of delegation is not signed
+			qname: "www.sub.example.jp.", qtype: dns.TypeA, do: true, rcode: dns.RcodeSuccess, aa: false,
+			ns: []string{
+				"sub.example.jp. NS ns.sub.example.jp.",
+				"sub.example.jp. NSEC *.wild.example.jp. NS RRSIG NSEC", "sub.example.jp. RRSIG NSEC",
+			},
+		},
+	})
+
+	m := query(s.worker("udp"), "host.wild.example.jp.", dns.TypeA, true)
+	if sig, ok := m.Answer[1].(*dns.RRSIG); !ok || sig.Labels != 3 {
+		t.Errorf("RRSIG of wildcard answer need to keep labels of the wildcard: %v", m.Answer)
+	}
+}
+
+// nsec3TestZone returns example.net. zone signed with NSEC3 chain of the names.
+func nsec3TestZone() string {
+	names := map[string]string{
+		"example.net.":        "NS SOA RRSIG NSEC3PARAM",
+		"ns.example.net.":     "A RRSIG",
+		"sub.example.net.":    "NS",
+		"wild.example.net.":   "",
+		"*.wild.example.net.": "A RRSIG",
+		"www.example.net.":    "A RRSIG",
+	}
+	hashes := []string{}
+	types := map[string]string{}
+	for name, t := range names {
+		hash := dns.HashName(name, dns.SHA1, 0, "")
+		hashes = append(hashes, hash)
+		types[hash] = t
+	}
+	sort.Strings(hashes)
+
+	zone := "" +
+		"example.net. 3600 IN SOA ns.example.net. root.example.net. 1 3600 900 1814400 900\n" +
+		presignedRRSIG("example.net.", 3600, "SOA", "example.net.") +
+		"example.net. 3600 IN NS ns.example.net.\n" +
+		presignedRRSIG("example.net.", 3600, "NS", "example.net.") +
+		"example.net. 0 IN NSEC3PARAM 1 0 0 -\n" +
+		presignedRRSIG("example.net.", 0, "NSEC3PARAM", "example.net.") +
+		"ns.example.net. 300 IN A 192.0.2.53\n" +
+		presignedRRSIG("ns.example.net.", 300, "A", "example.net.") +
+		"sub.example.net. 3600 IN NS ns.sub.example.net.\n" +
+		"ns.sub.example.net. 3600 IN A 192.0.2.54\n" +
+		"*.wild.example.net. 300 IN A 192.0.2.3\n" +
+		presignedRRSIG("*.wild.example.net.", 300, "A", "example.net.") +
+		"www.example.net. 300 IN A 192.0.2.1\n" +
+		presignedRRSIG("www.example.net.", 300, "A", "example.net.")
+	for i, hash := range hashes {
+		owner := strings.ToLower(hash) + ".example.net."
+		next := hashes[(i+1)%len(hashes)]
+		zone += fmt.Sprintf("%s 900 IN NSEC3 1 0 0 - %s %s\n", owner, next, types[hash])
+		zone += presignedRRSIG(owner, 900, "NSEC3", "example.net.")
+	}
+	return zone
+}
+
+// nsec3Proof checks NSEC3 RRs in authority section, each of them needs to be followed by RRSIG.
+// match are names which need to be matched, cover are names which need to be covered.
+func nsec3Proof(t *testing.T, name string, m *dns.Msg, match []string, cover []string) {
+	t.Helper()
+	nsec3s := []*dns.NSEC3{}
+	for i, rr := range m.Ns {
+		nsec3, ok := rr.(*dns.NSEC3)
+		if !ok {
+			continue
+		}
+		nsec3s = append(nsec3s, nsec3)
+		if i+1 >= len(m.Ns) || m.Ns[i+1].Header().Rrtype != dns.TypeRRSIG || m.Ns[i+1].Header().Name != nsec3.Hdr.Name {
+			t.Errorf("%s: NSEC3 need to be followed by its RRSIG: %v", name, m.Ns)
+		}
+	}
+	for _, n := range match {
+		found := false
+		for _, nsec3 := range nsec3s {
+			found = found || nsec3.Match(n)
+		}
+		if !found {
+			t.Errorf("%s: NSEC3 which matches %s is needed: %v", name, n, m.Ns)
+		}
+	}
+	for _, n := range cover {
+		found := false
+		for _, nsec3 := range nsec3s {
+			found = found || nsec3.Cover(n)
+		}
+		if !found {
+			t.Errorf("%s: NSEC3 which covers %s is needed: %v", name, n, m.Ns)
+		}
+	}
+}
+
+func TestPreSignedNSEC3(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.loadZone(t, "example.net.", nsec3TestZone())
+	w := s.worker("udp")
+
+	for _, tc := range []struct {
+		qname  string
+		qtype  uint16
+		rcode  int
+		aa     bool
+		answer []string
+		match  []string
+		cover  []string
+	}{
+		// closest encloser proof and wildcard
+		{"nx.example.net.", dns.TypeA, dns.RcodeNameError, true, nil,
+			[]string{"example.net."}, []string{"nx.example.net.", "*.example.net."}},
+		{"a.nx.example.net.", dns.TypeA, dns.RcodeNameError, true, nil,
+			[]string{"example.net."}, []string{"nx.example.net.", "*.example.net."}},
+		// NODATA
+		{"www.example.net.", dns.TypeTXT, dns.RcodeSuccess, true, nil,
+			[]string{"www.example.net."}, nil},
+		{"wild.example.net.", dns.TypeA, dns.RcodeSuccess, true, nil,
+			[]string{"wild.example.net."}, nil},
+		// wildcard answer, next closer name is covered
+		{"host.wild.example.net.", dns.TypeA, dns.RcodeSuccess, true,
+			[]string{"host.wild.example.net. A 192.0.2.3", "host.wild.example.net. RRSIG A"},
+			nil, []string{"host.wild.example.net."}},
+		// wildcard NODATA
+		{"host.wild.example.net.", dns.TypeTXT, dns.RcodeSuccess, true, nil,
+			[]string{"wild.example.net.", "*.wild.example.net."}, []string{"host.wild.example.net."}},
+		// delegation without DS
+		{"www.sub.example.net.", dns.TypeA, dns.RcodeSuccess, false, nil,
+			[]string{"sub.example.net."}, nil},
+	} {
+		name := tc.qname + "/" + dns.Type(tc.qtype).String()
+		m := query(w, tc.qname, tc.qtype, true)
+		if m.Rcode != tc.rcode || m.Authoritative != tc.aa {
+			t.Errorf("%s: rcode need to be %s and AA bit %v: %v", name, dns.RcodeToString[tc.rcode], tc.aa, m)
+		}
+		checkSection(t, name, "answer", m.Answer, tc.answer)
+		nsec3Proof(t, name, m, tc.match, tc.cover)
+		if tc.aa && tc.answer == nil && (len(m.Ns) < 2 || !matchRR("example.net. SOA", m.Ns[0]) || !matchRR("example.net. RRSIG SOA", m.Ns[1])) {
+			t.Errorf("%s: negative answer need to have signed SOA: %v", name, m.Ns)
+		}
+	}
+}
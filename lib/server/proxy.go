@@ -0,0 +1,228 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/rabbitdns/rabbitdns/lib/config"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
+	log "github.com/sirupsen/logrus"
+)
+
+func isTrustedProxy(c *config.Config, ip net.IP) bool {
+	for _, prefix := range c.TrustedProxies {
+		if MatchPrefix(ip, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyListener accepts TCP connections which start with PROXY protocol header,
+// when the peer is trusted proxy.
+type proxyListener struct {
+	net.Listener
+	config *config.Config
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil || !isTrustedProxy(l.config, AddrIP(conn.RemoteAddr())) {
+		return conn, err
+	}
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// proxyConn reads PROXY protocol header at the first read,
+// and reports the source address in the header as the remote address.
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		c.remote = c.Conn.RemoteAddr()
+		addr, err := ReadProxyHeader(c.reader)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Type":  "lib/server/proxyConn",
+				"Func":  "readHeader",
+				"Error": err,
+				"proxy": c.remote.String(),
+			}).Debug(ErrProxyHeader)
+			c.err = err
+			return
+		}
+		if addr != nil {
+			c.remote = addr
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if c.readHeader(); c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	return c.remote
+}
+
+// listenProxy listens TCP for the worker, which reads PROXY protocol header from trusted proxies.
+func (s *worker) listenProxy() error {
+	l, err := net.Listen("tcp", s.listener.Addr)
+	if err != nil {
+		return err
+	}
+	var listener net.Listener = &proxyListener{Listener: l, config: s.config}
+	if s.listener.TLSConfig != nil {
+		listener = tls.NewListener(listener, s.listener.TLSConfig)
+	}
+	s.listener.Listener = listener
+	return nil
+}
+
+// proxyUDPServer serves UDP queries which have PROXY protocol v2 header
+// by the query path of the worker. Responses are sent back to the proxy.
+type proxyUDPServer struct {
+	config *config.Config
+	worker *worker
+}
+
+func NewProxyUDPServer(c *config.Config, worker *worker) *proxyUDPServer {
+	return &proxyUDPServer{config: c, worker: worker}
+}
+
+func (s *proxyUDPServer) Run() {
+	addr, err := net.ResolveUDPAddr("udp", s.worker.listener.Addr)
+	var conn *net.UDPConn
+	if err == nil {
+		conn, err = net.ListenUDP("udp", addr)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Type":  "lib/server/proxyUDPServer",
+			"Func":  "Run",
+			"Error": err,
+			"addr":  s.worker.listener.Addr,
+		}).Fatal(ErrServ)
+	}
+	go s.serve(conn)
+}
+
+// udpBufferPool keeps read buffers of datagrams, which are reused after the query is served.
+var udpBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, dns.MaxMsgSize)
+		return &buf
+	},
+}
+
+func (s *proxyUDPServer) serve(conn *net.UDPConn) {
+	for {
+		buf := udpBufferPool.Get().(*[]byte)
+		n, peer, err := conn.ReadFromUDP(*buf)
+		if err != nil {
+			udpBufferPool.Put(buf)
+			log.WithFields(log.Fields{
+				"Type":  "lib/server/proxyUDPServer",
+				"Func":  "serve",
+				"Error": err,
+			}).Warn(ErrServ)
+			return
+		}
+		go func() {
+			s.serveDatagram(conn, peer, (*buf)[:n])
+			udpBufferPool.Put(buf)
+		}()
+	}
+}
+
+func (s *proxyUDPServer) serveDatagram(conn *net.UDPConn, peer *net.UDPAddr, buf []byte) {
+	var remote net.Addr = peer
+	if isTrustedProxy(s.config, peer.IP) {
+		addr, n, err := ParseProxyHeader(buf)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Type":  "lib/server/proxyUDPServer",
+				"Func":  "serveDatagram",
+				"Error": err,
+				"proxy": peer.String(),
+			}).Debug(ErrProxyHeader)
+			return
+		}
+		buf = buf[n:]
+		if addr != nil {
+			remote = addr
+		}
+	}
+	req, err := unpackRequest(buf)
+	if err != nil {
+		return
+	}
+	w := &proxyUDPWriter{
+		tsigState: tsigState{secrets: s.worker.listener.TsigSecret},
+		conn:      conn,
+		peer:      peer,
+		remote:    remote,
+	}
+	w.verify(buf, req)
+	s.worker.ServeDNS(w, req)
+}
+
+// proxyUDPWriter is dns.ResponseWriter which sends the response to the proxy.
+type proxyUDPWriter struct {
+	tsigState
+	conn   *net.UDPConn
+	peer   *net.UDPAddr
+	remote net.Addr
+}
+
+func (w *proxyUDPWriter) LocalAddr() net.Addr {
+	return w.conn.LocalAddr()
+}
+
+func (w *proxyUDPWriter) RemoteAddr() net.Addr {
+	return w.remote
+}
+
+func (w *proxyUDPWriter) WriteMsg(m *dns.Msg) error {
+	data, err := w.pack(m)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (w *proxyUDPWriter) Write(b []byte) (int, error) {
+	return w.conn.WriteToUDP(b, w.peer)
+}
+
+func (w *proxyUDPWriter) Close() error { return nil }
+func (w *proxyUDPWriter) Hijack()      {}
@@ -0,0 +1,96 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/miekg/dns"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
+)
+
+// isMetaQtype reports whether the qtype can't be answered from zone data.
+// They are meta types (RFC 6895 section 3.1) except ANY and zone transfers,
+// obsolete types (RFC 1035 section 3.3.4) and RRSIG, whose RRs are
+// returned only with the RRset they cover.
+func isMetaQtype(qtype uint16) bool {
+	switch qtype {
+	case dns.TypeANY, dns.TypeAXFR, dns.TypeIXFR:
+		return false
+	case dns.TypeNone, dns.TypeOPT, dns.TypeRRSIG, dns.TypeMD, dns.TypeMF, dns.TypeReserved:
+		return true
+	}
+	return qtype >= 128 && qtype <= 255
+}
+
+// checkQtype answers NOTIMP or REFUSED to query of meta or obsolete type
+// by the policy of the zone.
+// It returns false when the response is made.
+func (s *worker) checkQtype(m *dns.Msg, req *dns.Msg, zoneName string) bool {
+	if !isMetaQtype(req.Question[0].Qtype) {
+		return true
+	}
+	switch s.config.GetMetaQueries(zoneName) {
+	case "refused":
+		s.refused(m)
+	default:
+		s.notImplemented(m)
+	}
+	return false
+}
+
+// representativeType returns the type of RRset which is answered to ANY query,
+// which is the smallest type number in the node except DNSSEC types.
+// DYN* types are replaced by static types which they generate.
+// It returns TypeNone when the node has no such RRset.
+func representativeType(node *Tree) uint16 {
+	result := dns.TypeNone
+	for rrtype := range node.Resources {
+		switch rrtype {
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3, dns.TypeNSEC3PARAM, dns.TypeDNSKEY, dns.TypeCDNSKEY, dns.TypeCDS:
+			continue
+		}
+		if static, ok := DynamicStaticMap[rrtype]; ok {
+			rrtype = static
+		}
+		if result == dns.TypeNone || rrtype < result {
+			result = rrtype
+		}
+	}
+	return result
+}
+
+// anyResponse returns the policy of ANY query of the zone.
+// Pre-signed zone has no signature of synthesized HINFO, so minimal response is used.
+func (s *worker) anyResponse(zoneName string) string {
+	if zoneNode := s.zoneSet.SearchNode(Labels(zoneName), true); zoneNode != nil {
+		if _, ok := zoneNode.Get("PreSigned"); ok {
+			return "minimal"
+		}
+	}
+	return s.config.GetAnyResponse(zoneName)
+}
+
+// anyHINFO returns synthesized HINFO RR which is answered to ANY query (RFC 8482 section 4.2).
+func anyHINFO(sname string, zoneName string, zoneTree *Tree) dns.RR {
+	var ttl uint32
+	if soa := zoneSOA(zoneTree, zoneName); soa != nil {
+		ttl = soa.Hdr.Ttl
+	}
+	return &dns.HINFO{
+		Hdr: dns.RR_Header{Name: sname, Rrtype: dns.TypeHINFO, Class: dns.ClassINET, Ttl: ttl},
+		Cpu: "RFC8482",
+		Os:  "",
+	}
+}
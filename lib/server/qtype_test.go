@@ -0,0 +1,83 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/rabbitdns/rabbitdns/lib/config"
+)
+
+const anyTestZone = `
+@ 3600 IN SOA ns root 1 3600 900 1814400 300
+@ 3600 IN NS ns
+ns 300 IN A 192.0.2.53
+www 300 IN TXT "www"
+www 300 IN AAAA 2001:db8::1
+www 300 IN A 192.0.2.1
+mail 300 IN TXT "mail"
+mail 300 IN MX 10 mail
+dyn 300 IN DYNAAAA dyn-aaaa
+alias 300 IN CNAME www
+`
+
+func TestQtypePolicy(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.config.Zones = []config.ZoneConfig{{Name: "example.net", AnyResponse: "hinfo", MetaQueries: "refused"}}
+	s.addService("dyn-aaaa", dns.TypeAAAA, "dyn 60 IN AAAA 2001:db8::100")
+	s.loadZone(t, "example.jp.", anyTestZone)
+	s.loadZone(t, "example.net.", anyTestZone)
+
+	runQueryTests(t, s.worker("udp"), []queryTest{
+		// one RRset of the smallest type (RFC 8482 section 4.1)
+		{qname: "www.example.jp.", qtype: dns.TypeANY, rcode: dns.RcodeSuccess, aa: true, answer: []string{"www.example.jp. A 192.0.2.1"}},
+		{qname: "mail.example.jp.", qtype: dns.TypeANY, rcode: dns.RcodeSuccess, aa: true, answer: []string{"mail.example.jp. MX 10 mail.example.jp."}},
+		{qname: "dyn.example.jp.", qtype: dns.TypeANY, rcode: dns.RcodeSuccess, aa: true, answer: []string{"dyn.example.jp. AAAA 2001:db8::100"}},
+		{qname: "alias.example.jp.", qtype: dns.TypeANY, rcode: dns.RcodeSuccess, aa: true, answer: []string{"alias.example.jp. CNAME www.example.jp."}},
+		{qname: "nx.example.jp.", qtype: dns.TypeANY, rcode: dns.RcodeNameError, aa: true, ns: []string{"example.jp. SOA"}},
+		{qname: "www.example.jp.", qtype: dns.TypeRRSIG, rcode: dns.RcodeNotImplemented},
+		{qname: "www.example.jp.", qtype: dns.TypeMAILA, rcode: dns.RcodeNotImplemented},
+		{qname: "www.example.jp.", qtype: dns.TypeMD, rcode: dns.RcodeNotImplemented},
+		// synthesized HINFO (RFC 8482 section 4.2) and REFUSED by zone policy
+		{qname: "www.example.net.", qtype: dns.TypeANY, rcode: dns.RcodeSuccess, aa: true, answer: []string{"www.example.net. HINFO \"RFC8482\" \"\""}},
+		{qname: "www.example.net.", qtype: dns.TypeRRSIG, rcode: dns.RcodeRefused},
+		{qname: "www.example.net.", qtype: dns.TypeOPT, rcode: dns.RcodeRefused},
+	})
+}
+
+func TestQtypePolicySigned(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.config.AnyResponse = "hinfo"
+	s.addService("dyn-aaaa", dns.TypeAAAA, "dyn 60 IN AAAA 2001:db8::100")
+	s.signZone(t, "example.net.")
+	s.loadZone(t, "example.net.", anyTestZone)
+	// pre-signed zone has no RRSIG of synthesized HINFO
+	s.loadZone(t, "example.jp.", nsecTestZone)
+
+	runQueryTests(t, s.worker("udp"), []queryTest{
+		{
+			qname: "www.example.net.", qtype: dns.TypeANY, do: true, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"www.example.net. HINFO \"RFC8482\" \"\"", "www.example.net. RRSIG HINFO"},
+		},
+		{
+			qname: "www.example.jp.", qtype: dns.TypeANY, do: true, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"www.example.jp. A 192.0.2.1", "www.example.jp. RRSIG A"},
+		},
+	})
+}
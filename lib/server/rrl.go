@@ -0,0 +1,251 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"container/list"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rabbitdns/rabbitdns/lib/config"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrRateLimited = errors.New("responses to client are rate limited.")
+)
+
+// response categories which have their own rate
+const (
+	rrlResponse int = iota
+	rrlNXDomain
+	rrlError
+)
+
+var rrlCategoryString = map[int]string{
+	rrlResponse: "response",
+	rrlNXDomain: "nxdomain",
+	rrlError:    "error",
+}
+
+// results of rate limiting
+const (
+	rrlPass int = iota
+	rrlDrop
+	rrlSlip
+)
+
+type rrlKey struct {
+	prefix   string
+	category int
+	token    string
+}
+
+// rrlEntry is the credit account of identical responses to the client prefix.
+type rrlEntry struct {
+	key       rrlKey
+	balance   float64
+	last      time.Time
+	limited   bool
+	slipCount int
+	dropped   uint64
+	slipped   uint64
+}
+
+// rrlStats is counters of rate limiting.
+type rrlStats struct {
+	Responses uint64
+	Dropped   uint64
+	Slipped   uint64
+	Exempted  uint64
+	Entries   int
+}
+
+// rrlManager limits rate of identical responses to each client prefix
+// in the manner of BIND response rate limiting.
+// Accounts are kept in the LRU table which is bounded by RRLMaxEntries.
+type rrlManager struct {
+	config  *config.Config
+	mutex   sync.Mutex
+	entries map[rrlKey]*list.Element
+	lru     *list.List
+	stats   rrlStats
+}
+
+func NewRRLManager(c *config.Config) *rrlManager {
+	return &rrlManager{
+		config:  c,
+		entries: map[rrlKey]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// Enabled reports whether response rate limiting is configured.
+func (m *rrlManager) Enabled() bool {
+	return m.config.RRLResponses > 0
+}
+
+func (m *rrlManager) rate(category int) int {
+	switch {
+	case category == rrlNXDomain && m.config.RRLNXDomains > 0:
+		return m.config.RRLNXDomains
+	case category == rrlError && m.config.RRLErrors > 0:
+		return m.config.RRLErrors
+	}
+	return m.config.RRLResponses
+}
+
+func (m *rrlManager) exempt(ip net.IP) bool {
+	for _, prefix := range m.config.RRLExempt {
+		if MatchPrefix(ip, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// entry returns the account of the key, and evicts the least recently used one when the table is full.
+func (m *rrlManager) entry(key rrlKey, now time.Time) *rrlEntry {
+	if elem, ok := m.entries[key]; ok {
+		m.lru.MoveToFront(elem)
+		return elem.Value.(*rrlEntry)
+	}
+	for m.lru.Len() >= m.config.RRLMaxEntries {
+		oldest := m.lru.Back()
+		delete(m.entries, oldest.Value.(*rrlEntry).key)
+		m.lru.Remove(oldest)
+	}
+	e := &rrlEntry{key: key, balance: float64(m.rate(key.category)), last: now}
+	m.entries[key] = m.lru.PushFront(e)
+	return e
+}
+
+// Check accounts the response to the client, and returns whether it is passed, dropped or slipped.
+func (m *rrlManager) Check(ip net.IP, category int, token string, now time.Time) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.stats.Responses++
+	if m.exempt(ip) {
+		m.stats.Exempted++
+		return rrlPass
+	}
+	prefix := MaskIP(ip, m.config.RRLIPv4Prefix, m.config.RRLIPv6Prefix).String()
+	e := m.entry(rrlKey{prefix: prefix, category: category, token: token}, now)
+
+	// credit is added at the rate per second, and debt is kept for the window
+	rate := float64(m.rate(category))
+	e.balance += now.Sub(e.last).Seconds() * rate
+	e.last = now
+	if e.balance > rate {
+		e.balance = rate
+	}
+	e.balance--
+	if floor := -rate * float64(m.config.RRLWindow); e.balance < floor {
+		e.balance = floor
+	}
+	if e.balance >= 0 {
+		e.limited = false
+		return rrlPass
+	}
+	if !e.limited {
+		e.limited = true
+		log.WithFields(log.Fields{
+			"Type":     "lib/server/rrlManager",
+			"Func":     "Check",
+			"prefix":   prefix,
+			"category": rrlCategoryString[category],
+			"token":    token,
+		}).Info(ErrRateLimited)
+	}
+	if slip := m.config.RRLSlip; slip > 0 {
+		e.slipCount++
+		if e.slipCount%slip == 0 {
+			e.slipped++
+			m.stats.Slipped++
+			return rrlSlip
+		}
+	}
+	e.dropped++
+	m.stats.Dropped++
+	return rrlDrop
+}
+
+// Stats returns the counters and copies of accounts which are limited now.
+func (m *rrlManager) Stats() (rrlStats, []rrlEntry) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stats := m.stats
+	stats.Entries = m.lru.Len()
+	limited := []rrlEntry{}
+	for elem := m.lru.Front(); elem != nil; elem = elem.Next() {
+		if e := elem.Value.(*rrlEntry); e.limited {
+			limited = append(limited, *e)
+		}
+	}
+	return stats, limited
+}
+
+// rrlToken returns the category and the token which identifies the response.
+// Positive answers are identified by the question, other responses by the zone or delegation.
+func rrlToken(m *dns.Msg) (int, string) {
+	switch m.Rcode {
+	case dns.RcodeSuccess:
+		if len(m.Answer) > 0 || len(m.Ns) == 0 {
+			q := m.Question[0]
+			return rrlResponse, strings.ToLower(q.Name) + "/" + dns.TypeToString[q.Qtype]
+		}
+		// no data or referral
+		hdr := m.Ns[0].Header()
+		return rrlResponse, strings.ToLower(hdr.Name) + "/" + dns.TypeToString[hdr.Rrtype]
+	case dns.RcodeNameError:
+		if len(m.Ns) > 0 {
+			return rrlNXDomain, strings.ToLower(m.Ns[0].Header().Name)
+		}
+		return rrlNXDomain, strings.ToLower(m.Question[0].Name)
+	}
+	return rrlError, ""
+}
+
+// rateLimit applies RRL to UDP responses of queries.
+// Limited responses are dropped, or slipped as empty response with TC bit.
+// It returns false when the response is dropped.
+func (s *worker) rateLimit(w dns.ResponseWriter, m *dns.Msg, req *dns.Msg) bool {
+	if !s.rrlManager.Enabled() || s.listener.Net != "udp" {
+		return true
+	}
+	ip := AddrIP(w.RemoteAddr())
+	if s.config.Cookies {
+		// the client which has valid server cookie is not spoofed
+		client, server, ok, err := requestCookie(req)
+		if ok && err == nil && s.cookieManager.Verify(client, server, ip, time.Now()) {
+			return true
+		}
+	}
+	category, token := rrlToken(m)
+	switch s.rrlManager.Check(ip, category, token, time.Now()) {
+	case rrlDrop:
+		return false
+	case rrlSlip:
+		m.Answer, m.Ns, m.Extra = nil, nil, nil
+		m.Truncated = true
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestRRLCheck(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.config.RRLResponses = 2
+	s.config.RRLNXDomains = 1
+	s.config.RRLExempt = []string{"198.51.100.0/24", "2001:db8::53"}
+	m := NewRRLManager(s.config)
+	now := time.Now()
+	ip := net.ParseIP("192.0.2.1")
+	token := "www.example.jp./A"
+
+	// responses over the rate are dropped and slipped alternately
+	expect := []int{rrlPass, rrlPass, rrlDrop, rrlSlip, rrlDrop, rrlSlip}
+	for i, result := range expect {
+		if got := m.Check(ip, rrlResponse, token, now); got != result {
+			t.Errorf("response %d: result need to be %d, but %d", i, result, got)
+		}
+	}
+	testcases := []struct {
+		name     string
+		ip       string
+		category int
+		token    string
+		at       time.Duration
+		result   int
+	}{
+		{name: "same prefix", ip: "192.0.2.200", category: rrlResponse, token: token, result: rrlDrop},
+		{name: "other prefix", ip: "192.0.3.1", category: rrlResponse, token: token, result: rrlPass},
+		{name: "other token", ip: "192.0.2.1", category: rrlResponse, token: "mail.example.jp./A", result: rrlPass},
+		{name: "other category", ip: "192.0.2.1", category: rrlNXDomain, token: token, result: rrlPass},
+		{name: "rate of category", ip: "192.0.2.1", category: rrlNXDomain, token: token, result: rrlDrop},
+		{name: "exempt prefix", ip: "198.51.100.1", category: rrlResponse, token: token, result: rrlPass},
+		{name: "exempt address", ip: "2001:db8::53", category: rrlResponse, token: token, result: rrlPass},
+		// the debt of 5 responses is paid after 3 seconds
+		{name: "debt", ip: "192.0.2.1", category: rrlResponse, token: token, at: 2 * time.Second, result: rrlSlip},
+		{name: "credit", ip: "192.0.2.1", category: rrlResponse, token: token, at: 5 * time.Second, result: rrlPass},
+	}
+	for _, tc := range testcases {
+		if got := m.Check(net.ParseIP(tc.ip), tc.category, tc.token, now.Add(tc.at)); got != tc.result {
+			t.Errorf("%s: result need to be %d, but %d", tc.name, tc.result, got)
+		}
+	}
+	stats, limited := m.Stats()
+	if stats.Responses != 15 || stats.Exempted != 2 || stats.Dropped != 4 || stats.Slipped != 3 {
+		t.Errorf("counters need to be updated: %+v", stats)
+	}
+	if len(limited) != 1 || limited[0].key.category != rrlNXDomain {
+		t.Errorf("only nxdomain account need to be limited: %+v", limited)
+	}
+
+	// all responses over the rate are dropped without slip
+	s.config.RRLSlip = 0
+	for i, result := range []int{rrlPass, rrlPass, rrlDrop, rrlDrop, rrlDrop} {
+		if got := m.Check(net.ParseIP("192.0.4.1"), rrlResponse, token, now); got != result {
+			t.Errorf("response %d without slip: result need to be %d, but %d", i, result, got)
+		}
+	}
+}
+
+func TestRRLMaxEntries(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.config.RRLResponses = 1
+	s.config.RRLMaxEntries = 2
+	m := NewRRLManager(s.config)
+	now := time.Now()
+	for _, token := range []string{"a", "a", "b", "c"} {
+		m.Check(net.ParseIP("192.0.2.1"), rrlResponse, token, now)
+	}
+	if stats, _ := m.Stats(); stats.Entries != 2 {
+		t.Errorf("accounts need to be bounded by RRLMaxEntries: %d", stats.Entries)
+	}
+	// account of "a" is evicted, and its debt is forgotten
+	if got := m.Check(net.ParseIP("192.0.2.1"), rrlResponse, "a", now); got != rrlPass {
+		t.Errorf("evicted account need to be created again, but %d", got)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.config.RRLResponses = 1
+	s.config.Cookies = true
+	s.addService("dyn-a", dns.TypeA, "dyn.example.jp. 60 IN A 192.0.2.100")
+	s.loadZone(t, "example.jp.", denialTestZone)
+	cm, err := NewCookieManager(s.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rrl := NewRRLManager(s.config)
+	udp := NewWorker(s.config, s.zoneManager, s.serviceManager, nil, nil, cm, rrl, nil, "127.0.0.1:0", "udp")
+	tcp := NewWorker(s.config, s.zoneManager, s.serviceManager, nil, nil, cm, rrl, nil, "127.0.0.1:0", "tcp")
+	client := []byte("01234567")
+	valid := cm.Make(client, net.ParseIP("198.51.100.1"), time.Now())
+	exchange := func(w *worker, cookie []byte) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion("www.example.jp.", dns.TypeA)
+		if cookie != nil {
+			req.SetEdns0(1232, false)
+			opt := req.IsEdns0()
+			opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: hex.EncodeToString(cookie)})
+		}
+		tw := &testWriter{}
+		w.ServeDNS(tw, req)
+		return tw.msg
+	}
+
+	if m := exchange(udp, nil); m == nil || len(m.Answer) != 1 || m.Truncated {
+		t.Fatalf("response in the rate need to be answered: %v", m)
+	}
+	if m := exchange(udp, nil); m != nil {
+		t.Errorf("response over the rate need to be dropped: %v", m)
+	}
+	m := exchange(udp, nil)
+	if m == nil || !m.Truncated || len(m.Answer)+len(m.Ns)+len(m.Extra) > 0 || m.Rcode != dns.RcodeSuccess {
+		t.Errorf("slipped response need to be empty with TC bit: %v", m)
+	}
+	// client cookie only doesn't prove the address
+	if m := exchange(udp, client); m != nil {
+		t.Errorf("response to client cookie only need to be dropped: %v", m)
+	}
+	if m := exchange(udp, append(append([]byte{}, client...), valid...)); m == nil || len(m.Answer) != 1 || m.Truncated {
+		t.Errorf("client with valid server cookie need not to be limited: %v", m)
+	}
+	if m := exchange(tcp, nil); m == nil || len(m.Answer) != 1 || m.Truncated {
+		t.Errorf("response over TCP need not to be limited: %v", m)
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rabbitdns/rabbitdns/lib/config"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNoPrimary      = errors.New("no primary server answered SOA query.")
+	ErrTransferIn     = errors.New("failed to transfer zone from primary server.")
+	ErrInvalidIXFR    = errors.New("IXFR response is inconsistent with zone data.")
+	ErrSecondaryEmpty = errors.New("secondary zone is not transferred yet.")
+)
+
+const (
+	secondaryTimeout = 10 * time.Second
+	// interval of SOA check until the first transfer succeeds
+	secondaryInitialRetry = 60 * time.Second
+)
+
+// secondaryZone is transfer state of the zone served as secondary.
+type secondaryZone struct {
+	origin    string
+	primaries []string
+	soa       *dns.SOA
+	// zone data in AXFR order without trailing SOA
+	rrs    []dns.RR
+	next   time.Time
+	expire time.Time
+}
+
+// secondaryManager transfers secondary zones from primary servers
+// following refresh, retry and expire timers of SOA.
+type secondaryManager struct {
+	config      *config.Config
+	zoneManager *zoneManager
+	mutex       *sync.Mutex
+	zones       map[string]*secondaryZone
+	refreshCh   chan string
+	tsigSecrets map[string]string
+}
+
+func NewSecondaryManager(c *config.Config, z *zoneManager, mutex *sync.Mutex, tsigSecrets map[string]string) *secondaryManager {
+	m := &secondaryManager{
+		config:      c,
+		zoneManager: z,
+		mutex:       mutex,
+		zones:       map[string]*secondaryZone{},
+		refreshCh:   make(chan string, 16),
+		tsigSecrets: tsigSecrets,
+	}
+	for _, zone := range c.Zones {
+		if !zone.IsSecondary() {
+			continue
+		}
+		primaries := []string{}
+		for _, primary := range zone.Primaries {
+			primaries = append(primaries, HostPort(primary, "53"))
+		}
+		origin := strings.ToLower(FQDN(zone.Name))
+		m.zones[origin] = &secondaryZone{origin: origin, primaries: primaries}
+	}
+	return m
+}
+
+func (m *secondaryManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case origin := <-m.refreshCh:
+			if zone, ok := m.zones[origin]; ok {
+				m.refresh(zone, time.Now())
+			}
+		case now := <-ticker.C:
+			for _, zone := range m.zones {
+				if !now.Before(zone.next) {
+					m.refresh(zone, now)
+				}
+			}
+		}
+	}
+}
+
+// IsSecondary reports whether the zone is transferred from primary servers.
+func (m *secondaryManager) IsSecondary(origin string) bool {
+	_, ok := m.zones[origin]
+	return ok
+}
+
+// Refresh requests SOA check of the zone immediately.
+func (m *secondaryManager) Refresh(origin string) {
+	select {
+	case m.refreshCh <- origin:
+	default:
+		// refresh is already queued
+	}
+}
+
+// allowNotify checks ip is one of primary servers or allowed by allow-notify ACL of the zone.
+func (m *secondaryManager) allowNotify(origin string, ip net.IP, key string) bool {
+	zone, ok := m.zones[origin]
+	if !ok {
+		return false
+	}
+	for _, primary := range zone.primaries {
+		if host, _, err := net.SplitHostPort(primary); err == nil && MatchPrefix(ip, host) {
+			return true
+		}
+	}
+	allowed, _ := m.config.MatchACL(m.config.GetZoneConfig(origin).AllowNotify, ip, key)
+	return allowed
+}
+
+// refresh checks SOA serial of primary servers, and transfers the zone when it is updated.
+func (m *secondaryManager) refresh(zone *secondaryZone, now time.Time) {
+	if zone.soa != nil && now.After(zone.expire) {
+		log.WithFields(log.Fields{
+			"Type":     "lib/server/secondaryManager",
+			"Func":     "refresh",
+			"zonename": zone.origin,
+		}).Warn("secondary zone is expired")
+		m.mutex.Lock()
+		m.zoneManager.ExpireZone(zone.origin)
+		m.mutex.Unlock()
+		zone.soa = nil
+		zone.rrs = nil
+	}
+	serial, primary, err := m.querySOA(zone)
+	if err != nil {
+		m.retry(zone, now, err)
+		return
+	}
+	if zone.soa != nil && !SerialLess(zone.soa.Serial, serial) {
+		m.schedule(zone, now)
+		return
+	}
+	rrs, err := m.transfer(zone, primary)
+	if err != nil {
+		m.retry(zone, now, err)
+		return
+	}
+	m.mutex.Lock()
+	err = m.zoneManager.LoadSecondaryZone(zone.origin, rrs)
+	m.mutex.Unlock()
+	if err != nil {
+		m.retry(zone, now, err)
+		return
+	}
+	zone.rrs = rrs
+	zone.soa = rrs[0].(*dns.SOA)
+	m.schedule(zone, now)
+
+	log.WithFields(log.Fields{
+		"Type":     "lib/server/secondaryManager",
+		"Func":     "refresh",
+		"zonename": zone.origin,
+		"primary":  primary,
+		"serial":   zone.soa.Serial,
+	}).Info("transfer zone")
+}
+
+func (m *secondaryManager) schedule(zone *secondaryZone, now time.Time) {
+	zone.next = now.Add(time.Duration(zone.soa.Refresh) * time.Second)
+	zone.expire = now.Add(time.Duration(zone.soa.Expire) * time.Second)
+}
+
+func (m *secondaryManager) retry(zone *secondaryZone, now time.Time, err error) {
+	log.WithFields(log.Fields{
+		"Type":     "lib/server/secondaryManager",
+		"Func":     "refresh",
+		"Error":    err,
+		"zonename": zone.origin,
+	}).Warn(ErrTransferIn)
+	if zone.soa == nil {
+		zone.next = now.Add(secondaryInitialRetry)
+		return
+	}
+	zone.next = now.Add(time.Duration(zone.soa.Retry) * time.Second)
+}
+
+// querySOA returns SOA serial of the first primary server which answers authoritatively.
+func (m *secondaryManager) querySOA(zone *secondaryZone) (uint32, string, error) {
+	c := &dns.Client{Timeout: secondaryTimeout, TsigSecret: m.tsigSecrets}
+	for _, primary := range zone.primaries {
+		q := new(dns.Msg)
+		q.SetQuestion(zone.origin, dns.TypeSOA)
+		signByKey(m.config, q, zone.origin)
+		r, _, err := c.Exchange(q, primary)
+		if err != nil || r.Rcode != dns.RcodeSuccess || !r.Authoritative {
+			continue
+		}
+		for _, rr := range r.Answer {
+			if soa, ok := rr.(*dns.SOA); ok && strings.EqualFold(soa.Header().Name, zone.origin) {
+				return soa.Serial, primary, nil
+			}
+		}
+	}
+	return 0, "", ErrNoPrimary
+}
+
+// transfer gets zone data from the primary server.
+// IXFR is tried when the zone has been transferred, and AXFR is used as fallback.
+func (m *secondaryManager) transfer(zone *secondaryZone, primary string) ([]dns.RR, error) {
+	if zone.soa != nil {
+		q := new(dns.Msg)
+		q.SetIxfr(zone.origin, zone.soa.Serial, zone.soa.Ns, zone.soa.Mbox)
+		signByKey(m.config, q, zone.origin)
+		rrs, err := m.transferIn(q, primary)
+		if err == nil && len(rrs) > 1 {
+			if _, ok := rrs[1].(*dns.SOA); !ok {
+				// AXFR style response
+				return rrs[:len(rrs)-1], nil
+			}
+			if rrs, err = applyIXFR(zone.rrs, rrs); err == nil {
+				return rrs, nil
+			}
+		}
+		log.WithFields(log.Fields{
+			"Type":     "lib/server/secondaryManager",
+			"Func":     "transfer",
+			"Error":    err,
+			"zonename": zone.origin,
+			"primary":  primary,
+		}).Info("IXFR is failed, fallback to AXFR")
+	}
+	q := new(dns.Msg)
+	q.SetAxfr(zone.origin)
+	signByKey(m.config, q, zone.origin)
+	rrs, err := m.transferIn(q, primary)
+	if err != nil {
+		return nil, err
+	}
+	if len(rrs) < 2 {
+		return nil, ErrTransferIn
+	}
+	return rrs[:len(rrs)-1], nil
+}
+
+// transferIn returns all RRs of AXFR or IXFR response.
+func (m *secondaryManager) transferIn(q *dns.Msg, primary string) ([]dns.RR, error) {
+	t := &dns.Transfer{DialTimeout: secondaryTimeout, ReadTimeout: secondaryTimeout, TsigSecret: m.tsigSecrets}
+	ch, err := t.In(q, primary)
+	if err != nil {
+		return nil, err
+	}
+	rrs := []dns.RR{}
+	for env := range ch {
+		if env.Error != nil {
+			err = env.Error
+			continue
+		}
+		rrs = append(rrs, env.RR...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rrs) == 0 {
+		return nil, ErrTransferIn
+	}
+	if _, ok := rrs[0].(*dns.SOA); !ok {
+		return nil, ErrTransferIn
+	}
+	return rrs, nil
+}
+
+// applyIXFR applies difference sequences of IXFR response to the current zone data.
+func applyIXFR(current []dns.RR, rrs []dns.RR) ([]dns.RR, error) {
+	if len(current) == 0 {
+		return nil, ErrSecondaryEmpty
+	}
+	newSOA := rrs[0].(*dns.SOA)
+	if last, ok := rrs[len(rrs)-1].(*dns.SOA); !ok || last.Serial != newSOA.Serial {
+		return nil, ErrInvalidIXFR
+	}
+	if rrs[1].(*dns.SOA).Serial != current[0].(*dns.SOA).Serial {
+		return nil, ErrInvalidIXFR
+	}
+	records := map[string]dns.RR{}
+	order := []string{}
+	for _, rr := range current[1:] {
+		key := strings.ToLower(rr.String())
+		records[key] = rr
+		order = append(order, key)
+	}
+	// each sequence is old SOA, deleted RRs, new SOA and added RRs
+	adding := true
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		if _, ok := rr.(*dns.SOA); ok {
+			adding = !adding
+			continue
+		}
+		key := strings.ToLower(rr.String())
+		if adding {
+			if _, ok := records[key]; !ok {
+				order = append(order, key)
+			}
+			records[key] = rr
+		} else {
+			delete(records, key)
+		}
+	}
+	if !adding {
+		return nil, ErrInvalidIXFR
+	}
+	result := []dns.RR{newSOA}
+	for _, key := range order {
+		if rr, ok := records[key]; ok {
+			result = append(result, rr)
+			delete(records, key)
+		}
+	}
+	return result, nil
+}
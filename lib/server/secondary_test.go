@@ -0,0 +1,183 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rabbitdns/rabbitdns/lib/config"
+)
+
+func secondaryTestRRs(t *testing.T, serial int, hosts ...string) []dns.RR {
+	rrs := []dns.RR{
+		mustRR(fmt.Sprintf("example.jp. 3600 IN SOA ns.example.jp. root.example.jp. %d 3600 900 1814400 900", serial)),
+		mustRR("example.jp. 3600 IN NS ns.example.jp."),
+	}
+	for _, host := range hosts {
+		rrs = append(rrs, mustRR(fmt.Sprintf("%s.example.jp. 300 IN A 192.0.2.%d", host, len(host))))
+	}
+	return rrs
+}
+
+func TestApplyIXFR(t *testing.T) {
+	current := secondaryTestRRs(t, 1, "www", "mail")
+	soa := func(serial int) dns.RR {
+		return secondaryTestRRs(t, serial)[0]
+	}
+	a := func(host string) dns.RR {
+		return secondaryTestRRs(t, 0, host)[2]
+	}
+	testcases := []struct {
+		ixfr   []dns.RR
+		expect []dns.RR
+		err    error
+	}{
+		{
+			// two sequences, www is deleted and added again
+			ixfr:   []dns.RR{soa(3), soa(1), a("www"), soa(2), a("ftp"), soa(2), a("mail"), soa(3), a("www"), soa(3)},
+			expect: secondaryTestRRs(t, 3, "www", "ftp"),
+		},
+		{ixfr: []dns.RR{soa(3), soa(2), soa(3), soa(3)}, err: ErrInvalidIXFR},
+		{ixfr: []dns.RR{soa(3), soa(1), soa(3), soa(2)}, err: ErrInvalidIXFR},
+		{ixfr: []dns.RR{soa(3), soa(1), a("www"), soa(3)}, err: ErrInvalidIXFR},
+	}
+	for i, tc := range testcases {
+		rrs, err := applyIXFR(current, tc.ixfr)
+		if err != tc.err {
+			t.Errorf("case %d: error need to be %v, but %v", i, tc.err, err)
+			continue
+		}
+		if fmt.Sprint(rrs) != fmt.Sprint(tc.expect) {
+			t.Errorf("case %d: zone need to be %v, but %v", i, tc.expect, rrs)
+		}
+	}
+	if _, err := applyIXFR(nil, testcases[0].ixfr); err != ErrSecondaryEmpty {
+		t.Errorf("IXFR need to be applied to transferred zone, but %v", err)
+	}
+}
+
+// startTestPrimary serves zones of the server over UDP and TCP of the same port.
+func startTestPrimary(t *testing.T, s *testServer) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, err := net.ListenPacket("udp", l.Addr().String())
+	if err != nil {
+		l.Close()
+		t.Fatal(err)
+	}
+	servers := []*dns.Server{
+		{Listener: l, Handler: s.worker("tcp")},
+		{PacketConn: pc, Handler: s.worker("udp")},
+	}
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		started := make(chan struct{})
+		server.NotifyStartedFunc = func() { close(started) }
+		wg.Add(1)
+		go func(server *dns.Server) {
+			defer wg.Done()
+			server.ActivateAndServe()
+		}(server)
+		<-started
+	}
+	return l.Addr().String(), func() {
+		for _, server := range servers {
+			server.Shutdown()
+		}
+		wg.Wait()
+	}
+}
+
+func TestSecondaryRefresh(t *testing.T) {
+	primary := newTestServer(t)
+	defer primary.Close()
+	primary.config.Zones = []config.ZoneConfig{{Name: "example.jp", AllowTransfer: []string{"127.0.0.1"}}}
+	zone := func(serial int, hosts ...string) string {
+		text := ""
+		for _, rr := range secondaryTestRRs(t, serial, hosts...) {
+			text += rr.String() + "\n"
+		}
+		return text
+	}
+	primary.loadZone(t, "example.jp.", zone(1, "www"))
+	addr, stop := startTestPrimary(t, primary)
+	defer func() { stop() }()
+
+	s := newTestServer(t)
+	defer s.Close()
+	s.config.Zones = []config.ZoneConfig{{Name: "example.jp", Primaries: []string{addr}}}
+	m := NewSecondaryManager(s.config, s.zoneManager, &sync.Mutex{}, nil)
+	if !m.IsSecondary("example.jp.") {
+		t.Fatalf("zone which has primaries need to be secondary")
+	}
+	w := s.worker("udp")
+	zone1 := m.zones["example.jp."]
+	answer := func(qname string) int {
+		return query(w, qname, dns.TypeA, false).Rcode
+	}
+
+	// AXFR at first
+	now := time.Now()
+	m.refresh(zone1, now)
+	if zone1.soa == nil || zone1.soa.Serial != 1 || answer("www.example.jp.") != dns.RcodeSuccess {
+		t.Fatalf("zone need to be transferred by AXFR: %v", zone1.rrs)
+	}
+	if !zone1.next.Equal(now.Add(3600*time.Second)) || !zone1.expire.Equal(now.Add(1814400*time.Second)) {
+		t.Errorf("refresh and expire timers need to be set by SOA")
+	}
+
+	// serial is not changed
+	primary.loadZone(t, "example.jp.", zone(1, "www", "mail"))
+	m.refresh(zone1, now)
+	if answer("mail.example.jp.") != dns.RcodeNameError {
+		t.Errorf("zone need not to be transferred when serial is not changed")
+	}
+	primary.loadZone(t, "example.jp.", zone(1, "www"))
+
+	// IXFR from the journal of primary
+	primary.loadZone(t, "example.jp.", zone(2, "www", "mail"))
+	primary.loadZone(t, "example.jp.", zone(3, "mail"))
+	m.refresh(zone1, now)
+	if zone1.soa.Serial != 3 || answer("mail.example.jp.") != dns.RcodeSuccess || answer("www.example.jp.") != dns.RcodeNameError {
+		t.Errorf("zone need to be updated by IXFR: %v", zone1.rrs)
+	}
+	if len(zone1.rrs) != 3 {
+		t.Errorf("zone need to have SOA, NS and mail: %v", zone1.rrs)
+	}
+
+	// primary is down, zone is expired after retries
+	stop()
+	stop = func() {}
+	m.refresh(zone1, now)
+	if !zone1.next.Equal(now.Add(900*time.Second)) || answer("mail.example.jp.") != dns.RcodeSuccess {
+		t.Errorf("zone need to be served and retried after retry interval")
+	}
+	m.refresh(zone1, zone1.expire.Add(time.Second))
+	if zone1.soa != nil || answer("mail.example.jp.") != dns.RcodeServerFailure {
+		t.Errorf("expired zone need not to be answered")
+	}
+	if !strings.Contains(fmt.Sprint(s.zoneManager.GetZones()), "example.jp") {
+		t.Errorf("secondary zone need to be listed")
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rabbitdns/rabbitdns/lib/config"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrTLSCertificate = errors.New("failed to load TLS certificate.")
+)
+
+// interval to check modification of certificate files
+const certCheckInterval = 10 * time.Second
+
+// certManager serves the certificate and key pair to TLS listeners,
+// and reloads them when the files are modified.
+type certManager struct {
+	certFile string
+	keyFile  string
+	mutex    sync.Mutex
+	cert     *tls.Certificate
+	modTime  time.Time
+	checked  time.Time
+}
+
+func NewCertManager(c *config.Config) (*certManager, error) {
+	m := &certManager{certFile: c.TLSCertFile, keyFile: c.TLSKeyFile}
+	if err := m.reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// modified returns the latest modification time of the certificate and key files.
+func (m *certManager) modified() (time.Time, error) {
+	var modTime time.Time
+	for _, file := range []string{m.certFile, m.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTime, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+// reload loads the certificate when the files are modified.
+// The current certificate is kept when new files are broken.
+func (m *certManager) reload(now time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.cert != nil && now.Sub(m.checked) < certCheckInterval {
+		return nil
+	}
+	m.checked = now
+	modTime, err := m.modified()
+	if err == nil && m.cert != nil && !modTime.After(m.modTime) {
+		return nil
+	}
+	var cert tls.Certificate
+	if err == nil {
+		cert, err = tls.LoadX509KeyPair(m.certFile, m.keyFile)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Type":  "lib/server/certManager",
+			"Func":  "reload",
+			"Error": err,
+			"cert":  m.certFile,
+			"key":   m.keyFile,
+		}).Warn(ErrTLSCertificate)
+		return err
+	}
+	m.cert = &cert
+	m.modTime = modTime
+	log.WithFields(log.Fields{
+		"Type": "lib/server/certManager",
+		"Func": "reload",
+		"cert": m.certFile,
+	}).Info("load TLS certificate")
+	return nil
+}
+
+func (m *certManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.reload(time.Now())
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.cert, nil
+}
+
+// TLSConfig returns config of TLS 1.2 and 1.3 listeners which negotiate the protocols by ALPN.
+func (m *certManager) TLSConfig(protocols ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		MaxVersion:     tls.VersionTLS13,
+		GetCertificate: m.GetCertificate,
+		NextProtos:     protocols,
+	}
+}
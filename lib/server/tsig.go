@@ -0,0 +1,163 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rabbitdns/rabbitdns/lib/config"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
+)
+
+var (
+	ErrTSIGAlgorithm = errors.New("TSIG algorithm is not matched to the key.")
+)
+
+const tsigFudge = 300
+
+// tsigWriter signs all responses by the TSIG key of the request.
+// Messages after the first one of zone transfer are signed with timers only.
+type tsigWriter struct {
+	dns.ResponseWriter
+	tsig    *dns.TSIG
+	written bool
+}
+
+func (w *tsigWriter) WriteMsg(m *dns.Msg) error {
+	if w.written {
+		w.ResponseWriter.TsigTimersOnly(true)
+	}
+	w.written = true
+	m.SetTsig(w.tsig.Hdr.Name, w.tsig.Algorithm, tsigFudge, time.Now().Unix())
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// tsigState verifies TSIG of the request and signs responses,
+// for transports which are not served by dns.Server.
+type tsigState struct {
+	secrets    map[string]string
+	status     error
+	timersOnly bool
+	requestMAC string
+}
+
+// verify checks TSIG of the request in wire format as dns.Server does.
+func (t *tsigState) verify(buf []byte, req *dns.Msg) {
+	tsig := req.IsTsig()
+	if tsig == nil {
+		return
+	}
+	t.requestMAC = tsig.MAC
+	if secret, ok := t.secrets[tsig.Hdr.Name]; ok {
+		t.status = dns.TsigVerify(buf, secret, "", false)
+	} else {
+		t.status = dns.ErrSecret
+	}
+}
+
+// pack returns the message in wire format, which is signed when it has TSIG.
+// The MAC is kept to chain the signature of the next message of zone transfer.
+func (t *tsigState) pack(m *dns.Msg) ([]byte, error) {
+	if tsig := m.IsTsig(); tsig != nil {
+		var data []byte
+		var err error
+		data, t.requestMAC, err = dns.TsigGenerate(m, t.secrets[tsig.Hdr.Name], t.requestMAC, t.timersOnly)
+		return data, err
+	}
+	return m.Pack()
+}
+
+func (t *tsigState) TsigStatus() error     { return t.status }
+func (t *tsigState) TsigTimersOnly(b bool) { t.timersOnly = b }
+
+// verifyTSIG checks TSIG of the request which is verified by dns.Server.
+func (s *worker) verifyTSIG(w dns.ResponseWriter, req *dns.Msg) error {
+	t := req.IsTsig()
+	if t == nil {
+		return nil
+	}
+	if err := w.TsigStatus(); err != nil {
+		return err
+	}
+	key := s.config.GetTSIGKey(t.Hdr.Name)
+	if key == nil || !strings.EqualFold(t.Algorithm, dns.Fqdn(key.Algorithm)) {
+		return ErrTSIGAlgorithm
+	}
+	return nil
+}
+
+// requestKey returns the FQDN of TSIG key which signed the request,
+// or empty string for unsigned request.
+func requestKey(req *dns.Msg) string {
+	if t := req.IsTsig(); t != nil {
+		return strings.ToLower(t.Hdr.Name)
+	}
+	return ""
+}
+
+func matchKey(key string, keys []string) bool {
+	if key == "" {
+		return false
+	}
+	for _, name := range keys {
+		if strings.EqualFold(FQDN(name), key) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowClient checks the client by ACL and TSIG keys of the zone policy.
+// When both are set, the client needs to match both.
+// When both are empty, nobody is allowed.
+func allowClient(c *config.Config, ip net.IP, key string, acl []string, keys []string) bool {
+	if len(acl) == 0 && len(keys) == 0 {
+		return false
+	}
+	if len(keys) > 0 && !matchKey(key, keys) {
+		return false
+	}
+	if len(acl) == 0 {
+		return true
+	}
+	allowed, _ := c.MatchACL(acl, ip, key)
+	return allowed
+}
+
+// allowQuery checks the client by allow-query ACL and the keys which the zone requires.
+// Everyone is allowed when both are empty.
+func (s *worker) allowQuery(w dns.ResponseWriter, req *dns.Msg, zoneName string) bool {
+	zone := s.config.GetZoneConfig(zoneName)
+	if len(zone.AllowQuery) == 0 && len(zone.QueryKeys) == 0 {
+		return true
+	}
+	return allowClient(s.config, AddrIP(w.RemoteAddr()), requestKey(req), zone.AllowQuery, zone.QueryKeys)
+}
+
+// signByKey adds TSIG to the message when the zone uses key to talk to primary servers.
+func signByKey(c *config.Config, m *dns.Msg, zoneName string) {
+	name := c.GetZoneConfig(zoneName).PrimaryKey
+	if name == "" {
+		return
+	}
+	if key := c.GetTSIGKey(name); key != nil {
+		m.SetTsig(strings.ToLower(FQDN(key.Name)), dns.Fqdn(strings.ToLower(key.Algorithm)), tsigFudge, time.Now().Unix())
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestTsigStatePackChain(t *testing.T) {
+	secrets := map[string]string{"key.": "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"}
+	req := new(dns.Msg)
+	req.SetAxfr("example.jp.")
+	req.SetTsig("key.", dns.HmacSHA256, tsigFudge, time.Now().Unix())
+	buf, reqMAC, err := dns.TsigGenerate(req, secrets["key."], "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req = new(dns.Msg)
+	if err := req.Unpack(buf); err != nil {
+		t.Fatal(err)
+	}
+	state := &tsigState{secrets: secrets}
+	state.verify(buf, req)
+	if state.TsigStatus() != nil {
+		t.Fatalf("request need to be verified: %v", state.TsigStatus())
+	}
+
+	prevMAC := reqMAC
+	for i := 0; i < 3; i++ {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		m.SetTsig("key.", dns.HmacSHA256, tsigFudge, time.Now().Unix())
+		state.TsigTimersOnly(i > 0)
+		data, err := state.pack(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := new(dns.Msg)
+		if err := resp.Unpack(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := dns.TsigVerify(data, secrets["key."], prevMAC, i > 0); err != nil {
+			t.Fatalf("message %d need to be signed after the previous MAC: %v", i, err)
+		}
+		prevMAC = resp.IsTsig().MAC
+	}
+}
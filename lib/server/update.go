@@ -0,0 +1,440 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/rabbitdns/rabbitdns/lib/config"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrUpdateRefused = errors.New("dynamic update is not allowed.")
+	ErrUpdateZone    = errors.New("updated zone is invalid.")
+	ErrWriteZone     = errors.New("failed to write zone file.")
+)
+
+// acceptMsg accepts UPDATE messages in addition to the default of miekg/dns,
+// sections of UPDATE can contain many RRs.
+func acceptMsg(dh dns.Header) dns.MsgAcceptAction {
+	opcode := int(dh.Bits>>11) & 0xF
+	if opcode != dns.OpcodeUpdate || dh.Bits&(1<<15) != 0 {
+		return dns.DefaultMsgAcceptFunc(dh)
+	}
+	if dh.Qdcount != 1 {
+		return dns.MsgReject
+	}
+	return dns.MsgAccept
+}
+
+// updateManager applies dynamic updates (RFC 2136) to primary zones,
+// and writes the result back to the zone file.
+type updateManager struct {
+	config      *config.Config
+	zoneManager *zoneManager
+	mutex       *sync.Mutex
+}
+
+func NewUpdateManager(c *config.Config, z *zoneManager, mutex *sync.Mutex) *updateManager {
+	return &updateManager{
+		config:      c,
+		zoneManager: z,
+		mutex:       mutex,
+	}
+}
+
+// Update applies the UPDATE message to the zone, and returns rcode of the response.
+func (m *updateManager) Update(origin string, req *dns.Msg) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.zoneManager.secondaries[origin] {
+		// updates are not forwarded to primary server
+		return dns.RcodeRefused
+	}
+	zoneFile, ok := m.zoneManager.zoneFile(origin)
+	if !ok {
+		return dns.RcodeNotAuth
+	}
+	zoneNode := m.zoneManager.zoneSet.SearchNode(Labels(origin), true)
+	if zoneNode == nil {
+		return dns.RcodeNotAuth
+	}
+	if _, ok := zoneNode.Get("PreSigned"); ok {
+		// signatures can't be made for pre-signed zone
+		return dns.RcodeRefused
+	}
+	v, ok := zoneNode.Get("Records")
+	if !ok {
+		return dns.RcodeServerFailure
+	}
+	rrs, ok := v.([]dns.RR)
+	if !ok {
+		return dns.RcodeServerFailure
+	}
+	if rcode := checkPrerequisites(origin, rrs, req.Answer); rcode != dns.RcodeSuccess {
+		return rcode
+	}
+	if rcode := checkUpdates(origin, req.Ns); rcode != dns.RcodeSuccess {
+		return rcode
+	}
+	updated, changed := applyUpdates(origin, rrs, req.Ns)
+	if !changed {
+		return dns.RcodeSuccess
+	}
+	if err := m.verify(origin, updated); err != nil {
+		log.WithFields(log.Fields{
+			"Type":     "lib/server/updateManager",
+			"Func":     "Update",
+			"Error":    err,
+			"zonename": origin,
+		}).Warn(ErrUpdateZone)
+		return dns.RcodeRefused
+	}
+	if err := writeZoneFile(zoneFile, origin, updated); err != nil {
+		log.WithFields(log.Fields{
+			"Type":     "lib/server/updateManager",
+			"Func":     "Update",
+			"Error":    err,
+			"zonename": origin,
+		}).Warn(ErrWriteZone)
+		return dns.RcodeServerFailure
+	}
+	if err := m.zoneManager.RefreshZone(zoneFile); err != nil {
+		log.WithFields(log.Fields{
+			"Type":     "lib/server/updateManager",
+			"Func":     "Update",
+			"Error":    err,
+			"zonename": origin,
+		}).Warn(err)
+		return dns.RcodeServerFailure
+	}
+	return dns.RcodeSuccess
+}
+
+// verify builds a new zone tree from updated RRs and checks it.
+func (m *updateManager) verify(origin string, rrs []dns.RR) error {
+	zoneTree := NewTree()
+	zoneTree.Auth = true
+	for _, rr := range rrs {
+		if dyn, ok := rr.(*dns.PrivateRR); ok {
+			if rdata, ok := dyn.Data.(*DYNRR); ok {
+				if _, ok := m.zoneManager.serviceManager.GetService(rdata.Resource); !ok {
+					return ErrServiceNotFount
+				}
+			}
+		}
+		zoneTree.AddRR(rr)
+	}
+	return zoneTree.VerifyZone(Labels(origin))
+}
+
+// rrKey identifies RR by owner, type and RDATA.
+func rrKey(rr dns.RR) string {
+	rr = dns.Copy(rr)
+	rr.Header().Ttl = 0
+	return strings.ToLower(rr.String())
+}
+
+func sameName(a, b string) bool {
+	return strings.EqualFold(FQDN(a), FQDN(b))
+}
+
+func rrsetOf(rrs []dns.RR, name string, rrtype uint16) []dns.RR {
+	rrset := []dns.RR{}
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == rrtype && sameName(rr.Header().Name, name) {
+			rrset = append(rrset, rr)
+		}
+	}
+	return rrset
+}
+
+func nameInUse(rrs []dns.RR, name string) bool {
+	for _, rr := range rrs {
+		if sameName(rr.Header().Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func filterRRs(rrs []dns.RR, remove func(rr dns.RR) bool) ([]dns.RR, bool) {
+	result := []dns.RR{}
+	for _, rr := range rrs {
+		if !remove(rr) {
+			result = append(result, rr)
+		}
+	}
+	return result, len(result) != len(rrs)
+}
+
+// checkPrerequisites checks prerequisite section (RFC 2136 section 3.2).
+func checkPrerequisites(origin string, rrs []dns.RR, prereqs []dns.RR) int {
+	valueSets := map[string][]dns.RR{}
+	for _, rr := range prereqs {
+		hdr := rr.Header()
+		if hdr.Ttl != 0 {
+			return dns.RcodeFormatError
+		}
+		if !dns.IsSubDomain(origin, hdr.Name) {
+			return dns.RcodeNotZone
+		}
+		switch hdr.Class {
+		case dns.ClassANY:
+			if hdr.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+			if hdr.Rrtype == dns.TypeANY {
+				if !nameInUse(rrs, hdr.Name) {
+					return dns.RcodeNameError
+				}
+			} else if len(rrsetOf(rrs, hdr.Name, hdr.Rrtype)) == 0 {
+				return dns.RcodeNXRrset
+			}
+		case dns.ClassNONE:
+			if hdr.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+			if hdr.Rrtype == dns.TypeANY {
+				if nameInUse(rrs, hdr.Name) {
+					return dns.RcodeYXDomain
+				}
+			} else if len(rrsetOf(rrs, hdr.Name, hdr.Rrtype)) > 0 {
+				return dns.RcodeYXRrset
+			}
+		case dns.ClassINET:
+			key := strings.ToLower(FQDN(hdr.Name)) + "/" + dns.Type(hdr.Rrtype).String()
+			valueSets[key] = append(valueSets[key], rr)
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+	// value dependent RRset exists
+	for _, set := range valueSets {
+		hdr := set[0].Header()
+		expect := map[string]bool{}
+		for _, rr := range set {
+			expect[rrKey(rr)] = true
+		}
+		current := map[string]bool{}
+		for _, rr := range rrsetOf(rrs, hdr.Name, hdr.Rrtype) {
+			current[rrKey(rr)] = true
+		}
+		if len(expect) != len(current) {
+			return dns.RcodeNXRrset
+		}
+		for key := range expect {
+			if !current[key] {
+				return dns.RcodeNXRrset
+			}
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// checkUpdates prescans update section (RFC 2136 section 3.4.1).
+func checkUpdates(origin string, updates []dns.RR) int {
+	for _, rr := range updates {
+		hdr := rr.Header()
+		if !dns.IsSubDomain(origin, hdr.Name) {
+			return dns.RcodeNotZone
+		}
+		switch hdr.Rrtype {
+		case dns.TypeAXFR, dns.TypeIXFR, dns.TypeMAILA, dns.TypeMAILB, dns.TypeOPT, dns.TypeTSIG:
+			return dns.RcodeFormatError
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+			// DNSSEC records are maintained by rabbitdns
+			return dns.RcodeRefused
+		}
+		switch hdr.Class {
+		case dns.ClassINET:
+			if hdr.Rrtype == dns.TypeANY {
+				return dns.RcodeFormatError
+			}
+		case dns.ClassANY:
+			if hdr.Ttl != 0 || hdr.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+		case dns.ClassNONE:
+			if hdr.Ttl != 0 || hdr.Rrtype == dns.TypeANY {
+				return dns.RcodeFormatError
+			}
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// applyUpdates returns zone RRs after update section is applied (RFC 2136 section 3.4.2).
+// SOA serial is increased when the zone is changed.
+func applyUpdates(origin string, rrs []dns.RR, updates []dns.RR) ([]dns.RR, bool) {
+	rrs = append([]dns.RR{}, rrs...)
+	changed := false
+	serialUpdated := false
+	for _, update := range updates {
+		hdr := update.Header()
+		apex := sameName(hdr.Name, origin)
+		var removed bool
+		switch hdr.Class {
+		case dns.ClassINET:
+			if hdr.Rrtype == dns.TypeSOA {
+				if !apex {
+					continue
+				}
+				soa := update.(*dns.SOA)
+				for i, rr := range rrs {
+					if current, ok := rr.(*dns.SOA); ok && SerialLess(current.Serial, soa.Serial) {
+						rrs[i] = update
+						changed, serialUpdated = true, true
+					}
+				}
+				continue
+			}
+			// CNAME and other data can't coexist
+			others := false
+			for _, rr := range rrs {
+				if sameName(rr.Header().Name, hdr.Name) && rr.Header().Rrtype != dns.TypeCNAME {
+					others = true
+				}
+			}
+			cnames := rrsetOf(rrs, hdr.Name, dns.TypeCNAME)
+			if hdr.Rrtype == dns.TypeCNAME && others || hdr.Rrtype != dns.TypeCNAME && len(cnames) > 0 {
+				continue
+			}
+			replaced := false
+			key := rrKey(update)
+			for i, rr := range rrs {
+				if rrKey(rr) == key || hdr.Rrtype == dns.TypeCNAME && rr.Header().Rrtype == dns.TypeCNAME && sameName(rr.Header().Name, hdr.Name) {
+					if rr.String() != update.String() {
+						rrs[i] = update
+						changed = true
+					}
+					replaced = true
+				}
+			}
+			if !replaced {
+				rrs = append(rrs, update)
+				changed = true
+			}
+		case dns.ClassANY:
+			rrs, removed = filterRRs(rrs, func(rr dns.RR) bool {
+				if !sameName(rr.Header().Name, hdr.Name) {
+					return false
+				}
+				rrtype := rr.Header().Rrtype
+				if apex && (rrtype == dns.TypeSOA || rrtype == dns.TypeNS) {
+					return false
+				}
+				return hdr.Rrtype == dns.TypeANY || hdr.Rrtype == rrtype
+			})
+		case dns.ClassNONE:
+			if hdr.Rrtype == dns.TypeSOA {
+				continue
+			}
+			if apex && hdr.Rrtype == dns.TypeNS && len(rrsetOf(rrs, origin, dns.TypeNS)) <= 1 {
+				continue
+			}
+			target := dns.Copy(update)
+			target.Header().Class = dns.ClassINET
+			key := rrKey(target)
+			rrs, removed = filterRRs(rrs, func(rr dns.RR) bool {
+				return rrKey(rr) == key
+			})
+		}
+		changed = changed || removed
+	}
+	if changed && !serialUpdated {
+		rrs = increaseSerial(rrs)
+	}
+	return rrs, changed
+}
+
+// increaseSerial returns zone RRs whose SOA serial is increased by one.
+func increaseSerial(rrs []dns.RR) []dns.RR {
+	rrs = append([]dns.RR{}, rrs...)
+	for i, rr := range rrs {
+		if soa, ok := rr.(*dns.SOA); ok {
+			soa = dns.Copy(soa).(*dns.SOA)
+			soa.Serial++
+			rrs[i] = soa
+		}
+	}
+	return rrs
+}
+
+// writeZoneFile replaces the zone file by RRs.
+func writeZoneFile(path string, origin string, rrs []dns.RR) error {
+	mode := os.FileMode(0644)
+	if stat, err := os.Stat(path); err == nil {
+		mode = stat.Mode()
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "$ORIGIN %s\n", origin)
+	for _, rr := range rrs {
+		buf.WriteString(rr.String() + "\n")
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// allowUpdate checks the client by allow-update ACL and update keys of the zone.
+func (s *worker) allowUpdate(w dns.ResponseWriter, req *dns.Msg, zoneName string) bool {
+	zone := s.config.GetZoneConfig(zoneName)
+	return allowClient(s.config, AddrIP(w.RemoteAddr()), requestKey(req), zone.AllowUpdate, zone.UpdateKeys)
+}
+
+// serveUpdate handles dynamic update message.
+func (s *worker) serveUpdate(w dns.ResponseWriter, m *dns.Msg, req *dns.Msg) {
+	zone := req.Question[0]
+	zoneName := strings.ToLower(FQDN(zone.Name))
+	switch {
+	case zone.Qtype != dns.TypeSOA || zone.Qclass != dns.ClassINET:
+		m.Rcode = dns.RcodeFormatError
+	case !s.allowUpdate(w, req, zoneName):
+		log.WithFields(log.Fields{
+			"Type":     "lib/server/Worker",
+			"Func":     "serveUpdate",
+			"zonename": zoneName,
+			"client":   w.RemoteAddr().String(),
+		}).Warn(ErrUpdateRefused)
+		s.refused(m)
+	default:
+		m.Rcode = s.updateManager.Update(zoneName, req)
+		log.WithFields(log.Fields{
+			"Type":     "lib/server/Worker",
+			"Func":     "serveUpdate",
+			"zonename": zoneName,
+			"client":   w.RemoteAddr().String(),
+			"rcode":    dns.RcodeToString[m.Rcode],
+		}).Info("dynamic update")
+	}
+	s.writeMsg(w, m, req)
+}
@@ -0,0 +1,295 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/rabbitdns/rabbitdns/lib/config"
+)
+
+var updateTestRRs = []dns.RR{
+	mustRR("example.jp. 3600 IN SOA ns.example.jp. root.example.jp. 1 3600 900 1814400 900"),
+	mustRR("example.jp. 3600 IN NS ns.example.jp."),
+	mustRR("ns.example.jp. 300 IN A 192.0.2.53"),
+	mustRR("www.example.jp. 300 IN A 192.0.2.1"),
+	mustRR("www.example.jp. 300 IN A 192.0.2.2"),
+	mustRR("alias.example.jp. 300 IN CNAME www.example.jp."),
+}
+
+// emptyRR returns RR without RDATA used in prerequisite and update sections.
+func emptyRR(name string, class uint16, rrtype uint16) dns.RR {
+	return &dns.ANY{Hdr: dns.RR_Header{Name: name, Rrtype: rrtype, Class: class}}
+}
+
+// valueRR returns RR of the value dependent prerequisite.
+func valueRR(s string) dns.RR {
+	rr := mustRR(s)
+	rr.Header().Ttl = 0
+	return rr
+}
+
+func TestCheckPrerequisites(t *testing.T) {
+	testcases := []struct {
+		prereqs []dns.RR
+		rcode   int
+	}{
+		{nil, dns.RcodeSuccess},
+		{[]dns.RR{emptyRR("www.example.jp.", dns.ClassANY, dns.TypeANY)}, dns.RcodeSuccess},
+		{[]dns.RR{emptyRR("nx.example.jp.", dns.ClassANY, dns.TypeANY)}, dns.RcodeNameError},
+		{[]dns.RR{emptyRR("www.example.jp.", dns.ClassANY, dns.TypeA)}, dns.RcodeSuccess},
+		{[]dns.RR{emptyRR("www.example.jp.", dns.ClassANY, dns.TypeAAAA)}, dns.RcodeNXRrset},
+		{[]dns.RR{emptyRR("nx.example.jp.", dns.ClassNONE, dns.TypeANY)}, dns.RcodeSuccess},
+		{[]dns.RR{emptyRR("WWW.example.jp.", dns.ClassNONE, dns.TypeANY)}, dns.RcodeYXDomain},
+		{[]dns.RR{emptyRR("www.example.jp.", dns.ClassNONE, dns.TypeAAAA)}, dns.RcodeSuccess},
+		{[]dns.RR{emptyRR("www.example.jp.", dns.ClassNONE, dns.TypeA)}, dns.RcodeYXRrset},
+		{
+			// value dependent RRset needs to be the same set
+			[]dns.RR{valueRR("www.example.jp. IN A 192.0.2.2"), valueRR("www.example.jp. IN A 192.0.2.1")},
+			dns.RcodeSuccess,
+		},
+		{[]dns.RR{valueRR("www.example.jp. IN A 192.0.2.1")}, dns.RcodeNXRrset},
+		{
+			[]dns.RR{valueRR("www.example.jp. IN A 192.0.2.1"), valueRR("www.example.jp. IN A 192.0.2.2"), valueRR("www.example.jp. IN A 192.0.2.3")},
+			dns.RcodeNXRrset,
+		},
+		{[]dns.RR{valueRR("nx.example.jp. IN A 192.0.2.1")}, dns.RcodeNXRrset},
+		{[]dns.RR{mustRR("www.example.jp. 300 IN A 192.0.2.1")}, dns.RcodeFormatError},
+		{[]dns.RR{emptyRR("www.example.com.", dns.ClassANY, dns.TypeANY)}, dns.RcodeNotZone},
+		{[]dns.RR{emptyRR("www.example.jp.", dns.ClassCHAOS, dns.TypeA)}, dns.RcodeFormatError},
+	}
+	for i, tc := range testcases {
+		if rcode := checkPrerequisites("example.jp.", updateTestRRs, tc.prereqs); rcode != tc.rcode {
+			t.Errorf("case %d: rcode need to be %s, but %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[rcode])
+		}
+	}
+}
+
+func TestCheckUpdates(t *testing.T) {
+	deleteRR := func(s string) dns.RR {
+		rr := valueRR(s)
+		rr.Header().Class = dns.ClassNONE
+		return rr
+	}
+	testcases := []struct {
+		updates []dns.RR
+		rcode   int
+	}{
+		{[]dns.RR{mustRR("new.example.jp. 300 IN A 192.0.2.10")}, dns.RcodeSuccess},
+		{[]dns.RR{emptyRR("www.example.jp.", dns.ClassANY, dns.TypeA)}, dns.RcodeSuccess},
+		{[]dns.RR{emptyRR("www.example.jp.", dns.ClassANY, dns.TypeANY)}, dns.RcodeSuccess},
+		{[]dns.RR{deleteRR("www.example.jp. IN A 192.0.2.1")}, dns.RcodeSuccess},
+		{[]dns.RR{mustRR("www.example.com. 300 IN A 192.0.2.10")}, dns.RcodeNotZone},
+		{[]dns.RR{emptyRR("www.example.jp.", dns.ClassINET, dns.TypeANY)}, dns.RcodeFormatError},
+		{[]dns.RR{emptyRR("www.example.jp.", dns.ClassINET, dns.TypeAXFR)}, dns.RcodeFormatError},
+		{[]dns.RR{mustRR("www.example.jp. 300 IN A 192.0.2.10"), emptyRR("www.example.jp.", dns.ClassANY, dns.TypeMAILB)}, dns.RcodeFormatError},
+		{[]dns.RR{mustRR("www.example.jp. 300 IN NSEC www2.example.jp. A")}, dns.RcodeRefused},
+		{[]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "www.example.jp.", Rrtype: dns.TypeA, Class: dns.ClassANY, Ttl: 300}}}, dns.RcodeFormatError},
+		{[]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "www.example.jp.", Rrtype: dns.TypeA, Class: dns.ClassANY, Rdlength: 4}}}, dns.RcodeFormatError},
+		{[]dns.RR{emptyRR("www.example.jp.", dns.ClassNONE, dns.TypeANY)}, dns.RcodeFormatError},
+		{[]dns.RR{emptyRR("www.example.jp.", dns.ClassCHAOS, dns.TypeA)}, dns.RcodeFormatError},
+	}
+	for i, tc := range testcases {
+		if rcode := checkUpdates("example.jp.", tc.updates); rcode != tc.rcode {
+			t.Errorf("case %d: rcode need to be %s, but %s", i, dns.RcodeToString[tc.rcode], dns.RcodeToString[rcode])
+		}
+	}
+}
+
+func TestApplyUpdates(t *testing.T) {
+	deleteRR := func(s string) dns.RR {
+		rr := valueRR(s)
+		rr.Header().Class = dns.ClassNONE
+		return rr
+	}
+	testcases := []struct {
+		updates []dns.RR
+		changed bool
+		serial  uint32
+		expect  []string
+		missing []string
+	}{
+		{
+			updates: []dns.RR{mustRR("new.example.jp. 300 IN A 192.0.2.10")},
+			changed: true, serial: 2,
+			expect: []string{"new.example.jp. A 192.0.2.10", "www.example.jp. A 192.0.2.1"},
+		},
+		{
+			// the same RR is not added twice
+			updates: []dns.RR{mustRR("www.example.jp. 300 IN A 192.0.2.1")},
+			changed: false, serial: 1,
+		},
+		{
+			// TTL of the same RR is replaced
+			updates: []dns.RR{mustRR("www.example.jp. 600 IN A 192.0.2.1")},
+			changed: true, serial: 2,
+			expect: []string{"www.example.jp. A 192.0.2.1", "www.example.jp. A 192.0.2.2"},
+		},
+		{
+			// CNAME and other data can't coexist, CNAME is replaced
+			updates: []dns.RR{
+				mustRR("alias.example.jp. 300 IN A 192.0.2.10"),
+				mustRR("www.example.jp. 300 IN CNAME ns.example.jp."),
+				mustRR("alias.example.jp. 300 IN CNAME ns.example.jp."),
+			},
+			changed: true, serial: 2,
+			expect:  []string{"alias.example.jp. CNAME ns.example.jp.", "www.example.jp. A 192.0.2.1"},
+			missing: []string{"alias.example.jp. A 192.0.2.10", "alias.example.jp. CNAME www.example.jp.", "www.example.jp. CNAME ns.example.jp."},
+		},
+		{
+			updates: []dns.RR{emptyRR("www.example.jp.", dns.ClassANY, dns.TypeA)},
+			changed: true, serial: 2,
+			missing: []string{"www.example.jp. A 192.0.2.1", "www.example.jp. A 192.0.2.2"},
+		},
+		{
+			// SOA and NS of apex are not deleted by name
+			updates: []dns.RR{emptyRR("example.jp.", dns.ClassANY, dns.TypeANY), emptyRR("www.example.jp.", dns.ClassANY, dns.TypeANY)},
+			changed: true, serial: 2,
+			expect:  []string{"example.jp. NS ns.example.jp."},
+			missing: []string{"www.example.jp. A 192.0.2.1"},
+		},
+		{
+			updates: []dns.RR{deleteRR("www.example.jp. IN A 192.0.2.1")},
+			changed: true, serial: 2,
+			expect:  []string{"www.example.jp. A 192.0.2.2"},
+			missing: []string{"www.example.jp. A 192.0.2.1"},
+		},
+		{
+			// the last NS of apex is not deleted
+			updates: []dns.RR{deleteRR("example.jp. IN NS ns.example.jp."), deleteRR("nx.example.jp. IN A 192.0.2.1")},
+			changed: false, serial: 1,
+			expect: []string{"example.jp. NS ns.example.jp."},
+		},
+		{
+			// SOA is replaced only by larger serial
+			updates: []dns.RR{mustRR("example.jp. 3600 IN SOA ns.example.jp. root.example.jp. 10 3600 900 1814400 900")},
+			changed: true, serial: 10,
+		},
+		{
+			updates: []dns.RR{mustRR("example.jp. 3600 IN SOA ns.example.jp. root.example.jp. 1 7200 900 1814400 900")},
+			changed: false, serial: 1,
+		},
+		{
+			updates: []dns.RR{
+				mustRR("example.jp. 3600 IN SOA ns.example.jp. root.example.jp. 10 3600 900 1814400 900"),
+				mustRR("new.example.jp. 300 IN A 192.0.2.10"),
+			},
+			changed: true, serial: 10,
+		},
+	}
+	for i, tc := range testcases {
+		rrs, changed := applyUpdates("example.jp.", updateTestRRs, tc.updates)
+		if changed != tc.changed {
+			t.Errorf("case %d: changed need to be %v", i, tc.changed)
+		}
+		if serial := rrs[0].(*dns.SOA).Serial; serial != tc.serial {
+			t.Errorf("case %d: serial need to be %d, but %d", i, tc.serial, serial)
+		}
+		for _, expect := range tc.expect {
+			if !matchAnyRR(rrs, expect) {
+				t.Errorf("case %d: %s need to be in the zone: %v", i, expect, rrs)
+			}
+		}
+		for _, missing := range tc.missing {
+			if matchAnyRR(rrs, missing) {
+				t.Errorf("case %d: %s need not to be in the zone: %v", i, missing, rrs)
+			}
+		}
+	}
+	if updateTestRRs[0].(*dns.SOA).Serial != 1 || len(updateTestRRs) != 6 {
+		t.Errorf("original zone need not to be changed: %v", updateTestRRs)
+	}
+}
+
+func matchAnyRR(rrs []dns.RR, expect string) bool {
+	for _, rr := range rrs {
+		if matchRR(expect, rr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestUpdate(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.config.ZonesDir = filepath.Join(s.dir, "zones")
+	s.config.Zones = []config.ZoneConfig{{Name: "example.jp", AllowUpdate: []string{"198.51.100.1"}}}
+	if err := os.Mkdir(s.config.ZonesDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	zoneFile := filepath.Join(s.config.ZonesDir, "example.jp")
+	text := ""
+	for _, rr := range updateTestRRs {
+		text += rr.String() + "\n"
+	}
+	if err := ioutil.WriteFile(zoneFile, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.zoneManager.LoadZones(); err != nil {
+		t.Fatal(err)
+	}
+	um := NewUpdateManager(s.config, s.zoneManager, &sync.Mutex{})
+	w := NewWorker(s.config, s.zoneManager, s.serviceManager, nil, um, nil, NewRRLManager(s.config), nil, "127.0.0.1:0", "udp")
+	update := func(remote string, prereqs []dns.RR, updates []dns.RR) int {
+		req := new(dns.Msg)
+		req.SetUpdate("example.jp.")
+		req.Answer = prereqs
+		req.Ns = updates
+		tw := &testWriter{}
+		if remote != "" {
+			tw.remote = &net.UDPAddr{IP: net.ParseIP(remote), Port: 10053}
+		}
+		w.ServeDNS(tw, req)
+		return tw.msg.Rcode
+	}
+
+	if rcode := update("", []dns.RR{emptyRR("new.example.jp.", dns.ClassNONE, dns.TypeANY)}, []dns.RR{mustRR("new.example.jp. 300 IN A 192.0.2.10")}); rcode != dns.RcodeSuccess {
+		t.Fatalf("update need to be applied, but %s", dns.RcodeToString[rcode])
+	}
+	runQueryTests(t, w, []queryTest{
+		{qname: "new.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: true, answer: []string{"new.example.jp. A 192.0.2.10"}},
+		{qname: "example.jp.", qtype: dns.TypeSOA, rcode: dns.RcodeSuccess, aa: true, answer: []string{"example.jp. SOA ns.example.jp. root.example.jp. 2 3600 900 1814400 900"}},
+	})
+	buf, err := ioutil.ReadFile(zoneFile)
+	if err != nil || !strings.Contains(string(buf), "new.example.jp.\t300\tIN\tA\t192.0.2.10") {
+		t.Errorf("updated zone need to be written to zone file: %s", buf)
+	}
+
+	// prerequisite is not satisfied, update is not applied
+	if rcode := update("", []dns.RR{emptyRR("new.example.jp.", dns.ClassNONE, dns.TypeANY)}, []dns.RR{emptyRR("www.example.jp.", dns.ClassANY, dns.TypeANY)}); rcode != dns.RcodeYXDomain {
+		t.Errorf("rcode need to be YXDOMAIN, but %s", dns.RcodeToString[rcode])
+	}
+	// client is not allowed
+	if rcode := update("198.51.100.2", nil, []dns.RR{emptyRR("www.example.jp.", dns.ClassANY, dns.TypeANY)}); rcode != dns.RcodeRefused {
+		t.Errorf("rcode need to be REFUSED, but %s", dns.RcodeToString[rcode])
+	}
+	// updated zone is invalid
+	if rcode := update("", nil, []dns.RR{mustRR("www.example.jp. 300 IN DYNA dyn-a")}); rcode != dns.RcodeRefused {
+		t.Errorf("rcode need to be REFUSED, but %s", dns.RcodeToString[rcode])
+	}
+	runQueryTests(t, w, []queryTest{
+		{qname: "www.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: true, answer: []string{"www.example.jp. A 192.0.2.1", "www.example.jp. A 192.0.2.2"}},
+		{qname: "example.jp.", qtype: dns.TypeSOA, rcode: dns.RcodeSuccess, aa: true, answer: []string{"example.jp. SOA ns.example.jp. root.example.jp. 2 3600 900 1814400 900"}},
+	})
+}
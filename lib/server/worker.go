@@ -16,6 +16,7 @@
 package server
 
 import (
+	"encoding/binary"
 	"errors"
 	"strings"
 
@@ -29,6 +30,9 @@ var (
 	ErrServ             = errors.New("failed to start dns server.")
 	ErrZoneCutFind      = errors.New("failed to find zone cut.")
 	ErrNotFoundZoneData = errors.New("faild to find zone data in zone node.")
+	ErrQueryRefused     = errors.New("query is not allowed.")
+	ErrRejectedMsg      = errors.New("message is rejected by header.")
+	ErrCNAMELoop        = errors.New("CNAME chain loops.")
 )
 
 const (
@@ -43,24 +47,34 @@ const (
 )
 
 type worker struct {
-	listener       *dns.Server
-	mux            *dns.ServeMux
-	config         *config.Config
-	zoneSet        *Tree
-	serviceManager *serviceManager
+	listener         *dns.Server
+	mux              *dns.ServeMux
+	config           *config.Config
+	zoneSet          *Tree
+	serviceManager   *serviceManager
+	secondaryManager *secondaryManager
+	updateManager    *updateManager
+	cookieManager    *cookieManager
+	rrlManager       *rrlManager
 }
 
-func NewWorker(config *config.Config, zoneManager *zoneManager, serviceManager *serviceManager, addr string, proto string) *worker {
+func NewWorker(config *config.Config, zoneManager *zoneManager, serviceManager *serviceManager, secondaryManager *secondaryManager, updateManager *updateManager, cookieManager *cookieManager, rrlManager *rrlManager, tsigSecrets map[string]string, addr string, proto string) *worker {
 	var worker worker
 	worker.config = config
 	worker.zoneSet = zoneManager.zoneSet
 	worker.serviceManager = serviceManager
+	worker.secondaryManager = secondaryManager
+	worker.updateManager = updateManager
+	worker.cookieManager = cookieManager
+	worker.rrlManager = rrlManager
 	worker.mux = dns.NewServeMux()
 	worker.mux.Handle(".", &worker)
 	worker.listener = &dns.Server{Addr: addr,
 		Net:           proto,
 		Handler:       worker.mux,
 		MaxTCPQueries: worker.config.MaxTCPQueries,
+		MsgAcceptFunc: acceptMsg,
+		TsigSecret:    tsigSecrets,
 	}
 
 	return &worker
@@ -68,7 +82,12 @@ func NewWorker(config *config.Config, zoneManager *zoneManager, serviceManager *
 
 func (s *worker) Run() {
 	go func(l *dns.Server) {
-		if err := l.ListenAndServe(); err != nil {
+		serve := l.ListenAndServe
+		if l.Listener != nil {
+			// listener is made by listenProxy
+			serve = l.ActivateAndServe
+		}
+		if err := serve(); err != nil {
 			log.WithFields(log.Fields{
 				"Type":   "lib/server/Worker",
 				"Func":   "Run",
@@ -79,29 +98,68 @@ func (s *worker) Run() {
 	}(s.listener)
 }
 
-func (s *worker) serverDNSCAHOS(m *dns.Msg, req *dns.Msg) {
+// encrypted reports whether the worker serves on encrypted transport.
+func (s *worker) encrypted() bool {
+	switch s.listener.Net {
+	case "tcp-tls", "https", "doq":
+		return true
+	}
+	return false
+}
+
+// stream reports whether the transport can carry multiple messages of zone transfer.
+func (s *worker) stream() bool {
+	switch s.listener.Net {
+	case "tcp", "tcp-tls", "doq":
+		return true
+	}
+	return false
+}
+
+// serverDNSCAHOS answers version and identity of the server to CHAOS TXT queries
+// (RFC 4892), unless they are hidden or the client is not allowed.
+func (s *worker) serverDNSCAHOS(w dns.ResponseWriter, m *dns.Msg, req *dns.Msg) {
 	qname := req.Question[0].Name
 	m.MsgHdr.AuthenticatedData = false
 	m.MsgHdr.CheckingDisabled = false
 	m.MsgHdr.Authoritative = true
 
-	if req.Question[0].Qtype != dns.TypeTXT {
-		m.Rcode = dns.RcodeNXRrset
-		return
-	}
-	switch qname {
-	case "version.bind.", "version.server":
-		hdr := dns.RR_Header{Name: qname, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0}
-		m.Answer = []dns.RR{&dns.TXT{Hdr: hdr, Txt: []string{"1.0.0"}}}
-		m.Rcode = dns.RcodeSuccess
+	var txt string
+	switch strings.ToLower(qname) {
+	case "version.bind.", "version.server.":
+		if s.config.HideVersion {
+			s.refused(m)
+			return
+		}
+		txt = "rabbitdns " + RabbitdnsVersion
 	case "hostname.bind.", "id.server.":
-		hdr := dns.RR_Header{Name: qname, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0}
-		m.Answer = []dns.RR{&dns.TXT{Hdr: hdr, Txt: []string{"localhost."}}}
-		m.Rcode = dns.RcodeSuccess
+		if s.config.HideIdentity || s.config.Identity == "" {
+			s.refused(m)
+			return
+		}
+		txt = s.config.Identity
 	default:
-		m.Rcode = dns.RcodeNXRrset
+		s.refused(m)
+		return
+	}
+	if len(s.config.AllowChaos) > 0 && !allowClient(s.config, AddrIP(w.RemoteAddr()), requestKey(req), s.config.AllowChaos, nil) {
+		log.WithFields(log.Fields{
+			"Type":   "lib/server/Worker",
+			"Func":   "serverDNSCAHOS",
+			"client": w.RemoteAddr().String(),
+			"qname":  qname,
+		}).Warn(ErrQueryRefused)
+		s.refused(m)
+		return
 	}
+	m.Rcode = dns.RcodeSuccess
+	if req.Question[0].Qtype != dns.TypeTXT && req.Question[0].Qtype != dns.TypeANY {
+		return
+	}
+	hdr := dns.RR_Header{Name: qname, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS, Ttl: 0}
+	m.Answer = []dns.RR{&dns.TXT{Hdr: hdr, Txt: []string{txt}}}
 }
+
 func (s *worker) SearchZone(labels []string) *Tree {
 	tree := s.zoneSet.SearchNode(labels, false)
 	for tree.Label != "" {
@@ -121,14 +179,29 @@ func (s *worker) serveDNSINET(w dns.ResponseWriter, m *dns.Msg, req *dns.Msg) er
 		s.refused(m)
 		return nil
 	}
+	if !s.allowQuery(w, req, zoneNode.Label) {
+		log.WithFields(log.Fields{
+			"Type":     "lib/server/Worker",
+			"Func":     "serveDNSINET",
+			"zonename": zoneNode.Label,
+			"client":   w.RemoteAddr().String(),
+			"qname":    qname,
+		}).Warn(ErrQueryRefused)
+		s.refused(m)
+		return nil
+	}
+	if !s.checkQtype(m, req, zoneNode.Label) {
+		return nil
+	}
 	if v, ok := zoneNode.Get("ZoneTree"); ok == true {
 		m.Rcode = dns.RcodeNameError
 		if zoneTree, ok := v.(*Tree); ok {
-			err := s.servZoneResponse(w, m, req, qname, qname, req.Question[0].Qtype, zoneNode.Label, zoneTree, 16, false)
+			err := s.servZoneResponse(w, m, req, qname, req.Question[0].Qtype, zoneNode.Label, zoneTree, s.config.MaxCNAMEChain)
 			if err != nil {
 				return err
 			}
-			if len(m.Answer) > 0 {
+			negative := s.negativeZone(w, m, req)
+			if len(m.Answer) > 0 && negative == nil {
 				if s.config.MinimumResponse == false {
 					if qname != zoneNode.Label || req.Question[0].Qtype != dns.TypeNS {
 						s.addRR(m, zoneNode.Label, zoneTree, Authoritative, dns.TypeNS)
@@ -136,25 +209,141 @@ func (s *worker) serveDNSINET(w dns.ResponseWriter, m *dns.Msg, req *dns.Msg) er
 				}
 			}
 
-			if len(m.Ns) > 0 {
-				if s.config.MinimumResponse == false {
-					for _, rr := range m.Ns {
-						if ns, ok := rr.(*dns.NS); ok {
-							s.addRR(m, ns.Ns, zoneTree, Additional, dns.TypeA)
-							s.addRR(m, ns.Ns, zoneTree, Additional, dns.TypeAAAA)
-						}
-					}
+			if s.config.MinimumResponse == false {
+				s.addAdditional(w, m, req, zoneNode.Label, zoneTree)
+			}
+			if negative != nil {
+				s.addRR(m, negative.Label, zoneTreeOf(negative), Authoritative, dns.TypeSOA)
+			}
+			for _, zone := range s.responseZones(m, zoneNode, negative) {
+				if err = s.secureResponse(m, req, zone); err != nil {
+					break
 				}
 			}
-			if len(m.Ns) == 0 && len(m.Answer) == 0 {
-				s.addRR(m, zoneNode.Label, zoneTree, Authoritative, dns.TypeSOA)
+			if negative != nil {
+				limitNegativeTTL(m, negativeTTL(negative.Label, zoneTreeOf(negative)))
 			}
-			return nil
+			return err
 		}
 	}
 	return ErrNotFoundZoneData
 }
 
+// zoneTreeOf returns zone data of the zone node.
+func zoneTreeOf(zoneNode *Tree) *Tree {
+	if v, ok := zoneNode.Get("ZoneTree"); ok {
+		if zoneTree, ok := v.(*Tree); ok {
+			return zoneTree
+		}
+	}
+	return nil
+}
+
+// negativeZone returns the zone node, when the response is NXDOMAIN or NODATA of the zone,
+// including a CNAME chain which ends with a name of the zone having no data (RFC 2308).
+// It returns nil when the response is not negative, or the client is not allowed to query the zone.
+func (s *worker) negativeZone(w dns.ResponseWriter, m *dns.Msg, req *dns.Msg) *Tree {
+	if !m.MsgHdr.Authoritative || len(m.Ns) > 0 {
+		return nil
+	}
+	if m.Rcode != dns.RcodeSuccess && m.Rcode != dns.RcodeNameError {
+		return nil
+	}
+	sname, ok := deniedName(m, req.Question[0].Name, req.Question[0].Qtype)
+	if !ok {
+		return nil
+	}
+	if len(m.Answer) > 0 && (inSection(m.Answer, sname, dns.TypeCNAME) || chainLength(m) >= s.config.MaxCNAMEChain) {
+		// the chain is stopped by a loop or the limit, the last target is not looked up
+		return nil
+	}
+	if zoneNode := s.SearchZone(Labels(sname)); zoneNode != nil && zoneTreeOf(zoneNode) != nil && s.allowQuery(w, req, zoneNode.Label) {
+		return zoneNode
+	}
+	return nil
+}
+
+// chainLength returns the number of CNAME RRs in answer section.
+func chainLength(m *dns.Msg) int {
+	n := 0
+	for _, rr := range m.Answer {
+		if rr.Header().Rrtype == dns.TypeCNAME {
+			n++
+		}
+	}
+	return n
+}
+
+// responseZones returns zone nodes which the response is made from,
+// the zone of qname comes first.
+func (s *worker) responseZones(m *dns.Msg, zoneNode *Tree, negative *Tree) []*Tree {
+	zones := []*Tree{zoneNode}
+	add := func(zone *Tree) {
+		if zone == nil {
+			return
+		}
+		for _, z := range zones {
+			if z == zone {
+				return
+			}
+		}
+		zones = append(zones, zone)
+	}
+	for _, rr := range m.Answer {
+		add(s.SearchZone(Labels(rr.Header().Name)))
+	}
+	add(negative)
+	return zones
+}
+
+// secureResponse adds denial of existence and RRSIGs of the zone,
+// when the client requests DNSSEC.
+func (s *worker) secureResponse(m *dns.Msg, req *dns.Msg, zoneNode *Tree) error {
+	zoneTree := zoneTreeOf(zoneNode)
+	if !isDNSSECOK(req) || zoneTree == nil {
+		return nil
+	}
+	if v, ok := zoneNode.Get("DNSSEC"); ok {
+		if keys, ok := v.(*zoneKeys); ok {
+			s.addDenial(m, req, zoneNode.Label, zoneTree)
+			if err := s.signResponse(m, zoneNode.Label, zoneTree, keys); err != nil {
+				return err
+			}
+		}
+	}
+	if v, ok := zoneNode.Get("PreSigned"); ok {
+		if signed, ok := v.(*signedZone); ok {
+			s.addPreSignedDenial(m, req, zoneNode.Label, zoneTree, signed)
+			m.Answer = attachPreSignedSigs(zoneTree, signed, m.Answer)
+			m.Ns = attachPreSignedSigs(zoneTree, signed, m.Ns)
+			m.Extra = attachPreSignedSigs(zoneTree, signed, m.Extra)
+		}
+	}
+	return nil
+}
+
+// limitNegativeTTL sets TTL of SOA and its RRSIGs in authority section
+// to min(SOA TTL, SOA MINIMUM), which is the TTL of negative caching (RFC 2308 section 3).
+// It is done after signing, because RRSIG keeps the original TTL.
+func limitNegativeTTL(m *dns.Msg, ttl uint32) {
+	for i, rr := range m.Ns {
+		switch rr := rr.(type) {
+		case *dns.SOA:
+		case *dns.RRSIG:
+			if rr.TypeCovered != dns.TypeSOA {
+				continue
+			}
+		default:
+			continue
+		}
+		if rr.Header().Ttl > ttl {
+			rr = dns.Copy(rr)
+			rr.Header().Ttl = ttl
+			m.Ns[i] = rr
+		}
+	}
+}
+
 func (s *worker) addRR(m *dns.Msg, sname string, zoneTree *Tree, section int, rrType uint16) int {
 	labels := Labels(sname)
 	node := zoneTree.SearchNode(labels, true)
@@ -188,66 +377,17 @@ func isAuth(t *Tree, zoneName string, rrtype uint16) bool {
 	}
 	return t.Auth
 }
-func (s *worker) servZoneResponse(w dns.ResponseWriter, m *dns.Msg, req *dns.Msg, qname, sname string, stype uint16, zoneName string, zoneTree *Tree, count int, isWildcard bool) (err error) {
-	labels := Labels(sname)
-	if count <= 0 {
+func (s *worker) servZoneResponse(w dns.ResponseWriter, m *dns.Msg, req *dns.Msg, sname string, stype uint16, zoneName string, zoneTree *Tree, count int) (err error) {
+	if count <= 0 || !dns.IsSubDomain(zoneName, sname) {
 		return
 	}
 
-	node := zoneTree.SearchNode(labels, isWildcard)
-	if node == nil {
-		return
+	node, exact := zoneTree.ClosestEncloser(Labels(sname))
+	auth := node.Auth
+	if exact {
+		auth = isAuth(node, zoneName, stype)
 	}
-	if isAuth(node, zoneName, stype) {
-		if node.Label == sname {
-			// found name
-			m.Rcode = dns.RcodeSuccess
-			if rrs, exist := node.GetRR(stype); exist {
-				// found RR
-				for _, rr := range rrs {
-					if isWildcard {
-						rr.Header().Name = qname
-					}
-					m.Answer = append(m.Answer, rr)
-				}
-			} else if rrs, exist := node.GetRR(dns.TypeCNAME); exist {
-				// found CNAME
-				m.Answer = append(m.Answer, rrs[0])
-				if cname, ok := rrs[0].(*dns.CNAME); ok {
-					err = s.servZoneResponse(w, m, req, cname.Target, cname.Target, stype, zoneName, zoneTree, count-1, false)
-				}
-			} else if dynamicRR, exist := StaticDynamicMap[stype]; exist {
-				if rrs, ok := node.GetRR(dynamicRR); ok {
-					if dyn, ok := rrs[0].(*dns.PrivateRR); ok {
-						if rdata, ok := dyn.Data.(*DYNRR); ok {
-							if resources, err := s.serviceManager.GetResources(w, req, stype, rdata.Resource); err == nil {
-								for _, rr := range resources {
-									rr.Header().Name = qname
-									rr.Header().Rrtype = stype
-									rr.Header().Class = dyn.Header().Class
-									rr.Header().Ttl = dyn.Header().Ttl
-									m.Answer = append(m.Answer, rr)
-								}
-							} else {
-								return err
-							}
-						}
-					}
-				}
-			}
-		} else {
-			if rrs, exist := node.GetRR(dns.TypeDNAME); exist {
-				dname := rrs[0]
-				dname.Header().Name = qname
-				dname.Header().Ttl = 0
-				m.Ns = append(m.Ns, dname)
-			} else if !isWildcard {
-				wildcard := FQDN("*." + strings.Join(labels[1:], "."))
-				err = s.servZoneResponse(w, m, req, qname, wildcard, stype, zoneName, zoneTree, count-1, true)
-			}
-		}
-		m.MsgHdr.Authoritative = true
-	} else {
+	if !auth {
 		// found Delegation
 		zoneCut := node.FindZoneCut()
 		if zoneCut == nil {
@@ -263,11 +403,172 @@ func (s *worker) servZoneResponse(w dns.ResponseWriter, m *dns.Msg, req *dns.Msg
 				m.Ns = append(m.Ns, rr)
 			}
 		}
+		m.Rcode = dns.RcodeSuccess
 		m.MsgHdr.Authoritative = false
+		return
+	}
+
+	m.MsgHdr.Authoritative = true
+	if !exact {
+		if rrs, exist := node.GetRR(dns.TypeDNAME); exist {
+			return s.substitute(w, m, req, rrs[0], sname, stype, zoneName, zoneTree, count)
+		}
+		// node is closest encloser, and the name is synthesized
+		// from its wildcard child as source of synthesis (RFC 4592 section 3.3.1).
+		if node = node.Wildcard(); node == nil {
+			m.Rcode = dns.RcodeNameError
+			return
+		}
+	}
+	m.Rcode = dns.RcodeSuccess
+	if stype == dns.TypeANY {
+		// minimal response to ANY query (RFC 8482)
+		if s.anyResponse(zoneName) == "hinfo" {
+			m.Answer = append(m.Answer, anyHINFO(sname, zoneName, zoneTree))
+			return
+		}
+		if stype = representativeType(node); stype == dns.TypeNone {
+			return
+		}
+	}
+	if rrs, exist := node.GetRR(stype); exist {
+		// found RR
+		m.Answer = append(m.Answer, synthesize(rrs, sname, exact)...)
+	} else if rrs, exist := node.GetRR(dns.TypeCNAME); exist {
+		// found CNAME
+		m.Answer = append(m.Answer, synthesize(rrs[:1], sname, exact)...)
+		if cname, ok := rrs[0].(*dns.CNAME); ok {
+			err = s.chase(w, m, req, cname.Target, stype, zoneName, zoneTree, count)
+		}
+	} else if rrs, exist, err := s.dynamicRRs(w, req, node, sname, stype); exist {
+		if err != nil {
+			return err
+		}
+		m.Answer = append(m.Answer, rrs...)
+	} else if rrs, exist, err := s.dynamicRRs(w, req, node, sname, dns.TypeCNAME); exist {
+		// found DYNC
+		if err != nil {
+			return err
+		}
+		if len(rrs) > 0 {
+			m.Answer = append(m.Answer, rrs[0])
+			if cname, ok := rrs[0].(*dns.CNAME); ok {
+				return s.chase(w, m, req, cname.Target, stype, zoneName, zoneTree, count)
+			}
+		}
 	}
 	return
 }
 
+// chase continues the lookup at the target of CNAME, which may be
+// in any zone served by this server, so that the response has the whole chain.
+// It stops at a loop, a name out of served zones, or a zone which the client
+// is not allowed to query. Authoritative answer bit is kept for the zone of qname.
+func (s *worker) chase(w dns.ResponseWriter, m *dns.Msg, req *dns.Msg, target string, stype uint16, zoneName string, zoneTree *Tree, count int) error {
+	if inSection(m.Answer, target, dns.TypeCNAME) {
+		log.WithFields(log.Fields{
+			"Type":   "lib/server/Worker",
+			"Func":   "chase",
+			"qname":  req.Question[0].Name,
+			"target": target,
+		}).Debug(ErrCNAMELoop)
+		return nil
+	}
+	zoneNode := s.SearchZone(Labels(target))
+	if zoneNode == nil {
+		return nil
+	}
+	if zoneNode.Label != zoneName {
+		if zoneTree = zoneTreeOf(zoneNode); zoneTree == nil || !s.allowQuery(w, req, zoneNode.Label) {
+			return nil
+		}
+		zoneName = zoneNode.Label
+	}
+	aa := m.MsgHdr.Authoritative
+	err := s.servZoneResponse(w, m, req, target, stype, zoneName, zoneTree, count-1)
+	m.MsgHdr.Authoritative = aa
+	return err
+}
+
+// dynamicRRs returns RRs of stype whose owner is sname, generated by DYN* RR of the node.
+// It returns false when the node has no DYN* RR for stype.
+func (s *worker) dynamicRRs(w dns.ResponseWriter, req *dns.Msg, node *Tree, sname string, stype uint16) ([]dns.RR, bool, error) {
+	dynamicRR, exist := StaticDynamicMap[stype]
+	if !exist {
+		return nil, false, nil
+	}
+	rrs, ok := node.GetRR(dynamicRR)
+	if !ok {
+		return nil, false, nil
+	}
+	dyn, ok := rrs[0].(*dns.PrivateRR)
+	if !ok {
+		return nil, false, nil
+	}
+	rdata, ok := dyn.Data.(*DYNRR)
+	if !ok {
+		return nil, false, nil
+	}
+	resources, err := s.serviceManager.GetResources(w, req, stype, rdata.Resource)
+	if err != nil {
+		return nil, true, err
+	}
+	for _, rr := range resources {
+		rr.Header().Name = sname
+		rr.Header().Rrtype = stype
+		rr.Header().Class = dyn.Header().Class
+		rr.Header().Ttl = dyn.Header().Ttl
+	}
+	return resources, true, nil
+}
+
+// substitute answers the DNAME and the CNAME synthesized from it,
+// and continues the lookup at the substituted name (RFC 6672 section 3.2).
+func (s *worker) substitute(w dns.ResponseWriter, m *dns.Msg, req *dns.Msg, rr dns.RR, sname string, stype uint16, zoneName string, zoneTree *Tree, count int) error {
+	dname, ok := rr.(*dns.DNAME)
+	if !ok {
+		return nil
+	}
+	m.Rcode = dns.RcodeSuccess
+	m.Answer = append(m.Answer, dname)
+
+	labels := dns.SplitDomainName(sname)
+	prefix := labels[:len(labels)-dns.CountLabel(dname.Hdr.Name)]
+	target := dns.Fqdn(strings.Join(append(prefix, dname.Target), "."))
+	if dname.Target == "." {
+		target = dns.Fqdn(strings.Join(prefix, "."))
+	}
+	if _, ok := dns.IsDomainName(target); !ok {
+		// the substituted name is longer than 255 octets
+		m.Rcode = dns.RcodeYXDomain
+		return nil
+	}
+	cname := &dns.CNAME{
+		Hdr:    dns.RR_Header{Name: sname, Rrtype: dns.TypeCNAME, Class: dname.Hdr.Class, Ttl: dname.Hdr.Ttl},
+		Target: target,
+	}
+	m.Answer = append(m.Answer, cname)
+	if stype == dns.TypeCNAME {
+		return nil
+	}
+	return s.chase(w, m, req, target, stype, zoneName, zoneTree, count)
+}
+
+// synthesize returns RRs whose owner is replaced by sname,
+// when they are taken from wildcard.
+func synthesize(rrs []dns.RR, sname string, exact bool) []dns.RR {
+	if exact {
+		return rrs
+	}
+	result := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		rr = dns.Copy(rr)
+		rr.Header().Name = sname
+		result = append(result, rr)
+	}
+	return result
+}
+
 func (s *worker) servfail(m *dns.Msg) {
 	m.Rcode = dns.RcodeServerFailure
 }
@@ -282,10 +583,49 @@ func (s *worker) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
 	m := new(dns.Msg)
 	m.SetReply(req)
 
+	if err := s.verifyTSIG(w, req); err != nil {
+		log.WithFields(log.Fields{
+			"Type":   "lib/server/Worker",
+			"Func":   "ServeDNS",
+			"Error":  err,
+			"client": w.RemoteAddr().String(),
+		}).Warn("failed to verify TSIG")
+		m.Rcode = dns.RcodeNotAuth
+		s.writeMsg(w, m, req)
+		return
+	}
+	if t := req.IsTsig(); t != nil {
+		w = &tsigWriter{ResponseWriter: w, tsig: t}
+	}
+
+	if !s.checkEdns0(m, req) || !s.checkCookie(w, m, req) {
+		s.writeMsg(w, m, req)
+		return
+	}
+
+	switch req.Opcode {
+	case dns.OpcodeQuery:
+	case dns.OpcodeNotify:
+		s.serveNotify(w, m, req)
+		return
+	case dns.OpcodeUpdate:
+		s.serveUpdate(w, m, req)
+		return
+	default:
+		s.notImplemented(m)
+		s.writeMsg(w, m, req)
+		return
+	}
+
 	switch req.Question[0].Qclass {
 	case dns.ClassCHAOS:
-		s.serverDNSCAHOS(m, req)
+		s.serverDNSCAHOS(w, m, req)
 	case dns.ClassINET:
+		switch req.Question[0].Qtype {
+		case dns.TypeAXFR, dns.TypeIXFR:
+			s.serveTransfer(w, m, req)
+			return
+		}
 		err := s.serveDNSINET(w, m, req)
 		if err != nil {
 			s.servfail(m)
@@ -293,5 +633,36 @@ func (s *worker) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
 	default:
 		s.notImplemented(m)
 	}
-	w.WriteMsg(m)
+	if !s.rateLimit(w, m, req) {
+		return
+	}
+	s.writeMsg(w, m, req)
+}
+
+// unpackRequest checks the header by acceptMsg and unpacks the request,
+// for transports which are not served by dns.Server.
+func unpackRequest(buf []byte) (*dns.Msg, error) {
+	if len(buf) < 12 {
+		return nil, dns.ErrShortRead
+	}
+	if acceptMsg(header(buf)) != dns.MsgAccept {
+		return nil, ErrRejectedMsg
+	}
+	req := new(dns.Msg)
+	if err := req.Unpack(buf); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// header returns the header of the message in wire format.
+func header(buf []byte) dns.Header {
+	return dns.Header{
+		Id:      binary.BigEndian.Uint16(buf[0:]),
+		Bits:    binary.BigEndian.Uint16(buf[2:]),
+		Qdcount: binary.BigEndian.Uint16(buf[4:]),
+		Ancount: binary.BigEndian.Uint16(buf[6:]),
+		Nscount: binary.BigEndian.Uint16(buf[8:]),
+		Arcount: binary.BigEndian.Uint16(buf[10:]),
+	}
 }
@@ -0,0 +1,497 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rabbitdns/rabbitdns/lib/config"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
+	"github.com/rabbitdns/rabbitdns/lib/service"
+)
+
+// testWriter keeps the responses which worker writes.
+type testWriter struct {
+	remote net.Addr
+	msg    *dns.Msg
+	msgs   []*dns.Msg
+}
+
+func (w *testWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP("192.0.2.53"), Port: 53}
+}
+func (w *testWriter) RemoteAddr() net.Addr {
+	if w.remote != nil {
+		return w.remote
+	}
+	return &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 10053}
+}
+func (w *testWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	w.msgs = append(w.msgs, m)
+	return nil
+}
+func (w *testWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+	return len(b), w.WriteMsg(m)
+}
+func (w *testWriter) Close() error        { return nil }
+func (w *testWriter) TsigStatus() error   { return nil }
+func (w *testWriter) TsigTimersOnly(bool) {}
+func (w *testWriter) Hijack()             {}
+
+// testService generates fixed RRs as a service of DYN* RRs.
+type testService []dns.RR
+
+func (s testService) GetRR(w dns.ResponseWriter, req *dns.Msg) ([]dns.RR, error) {
+	rrs := []dns.RR{}
+	for _, rr := range s {
+		rrs = append(rrs, dns.Copy(rr))
+	}
+	return rrs, nil
+}
+func (s testService) Path() string { return "service" }
+
+// testServer serves zones which are loaded from zone texts in memory.
+type testServer struct {
+	config         *config.Config
+	dir            string
+	keyManager     *keyManager
+	serviceManager *serviceManager
+	zoneManager    *zoneManager
+}
+
+func newTestServer(t *testing.T) *testServer {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &config.Config{
+		KeysDir:            dir,
+		JournalDir:         dir,
+		MaxJournalChanges:  100,
+		MaxUDPSize:         1232,
+		MaxTCPQueries:      1000,
+		CookieRotation:     86400,
+		RRLWindow:          15,
+		RRLSlip:            2,
+		RRLIPv4Prefix:      24,
+		RRLIPv6Prefix:      56,
+		RRLMaxEntries:      100000,
+		SignatureValidity:  1209600,
+		DenialOfExistence:  "compact",
+		KeyAlgorithm:       "ECDSAP256SHA256",
+		KeyPublishSafety:   86400,
+		DSPropagationDelay: 172800,
+		DynamicTransfer:    "resolve",
+		AnyResponse:        "minimal",
+		MetaQueries:        "notimp",
+		MaxCNAMEChain:      16,
+		MinimumResponse:    true,
+	}
+	s := &testServer{config: c, dir: dir}
+	s.keyManager = NewKeyManager(c)
+	s.serviceManager = NewServiceManager(c, NewMonitoringManager(c))
+	s.zoneManager = NewZoneManager(c, s.serviceManager, s.keyManager, NewNotifyManager(c))
+	return s
+}
+
+func (s *testServer) Close() {
+	os.RemoveAll(s.dir)
+}
+
+// addService registers the service which DYN* RRs of zones refer.
+func (s *testServer) addService(name string, rrtype uint16, rrs ...string) {
+	svc := testService{}
+	for _, rr := range rrs {
+		svc = append(svc, mustRR(rr))
+	}
+	s.serviceManager.services[name] = &service.Config{Name: name, RRType: rrtype, Service: svc}
+	s.serviceManager.using[name] = map[string]bool{}
+}
+
+// signZone creates KSK and ZSK of the zone, which are used from the next load.
+func (s *testServer) signZone(t *testing.T, origin string) {
+	now := time.Now().Add(-time.Minute)
+	for _, keyType := range []string{"ksk", "zsk"} {
+		if err := s.keyManager.Rollover(origin, keyType, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// loadZone parses the zone text and publishes it as the zone of origin.
+func (s *testServer) loadZone(t *testing.T, origin string, zone string) {
+	rrs := []dns.RR{}
+	for x := range dns.ParseZone(strings.NewReader(zone), origin, "") {
+		if x.Error != nil {
+			t.Fatal(x.Error)
+		}
+		rrs = append(rrs, x.RR)
+	}
+	zoneNode := s.zoneManager.zoneSet.AddNode(Labels(origin))
+	zoneNode.Set("provide", true)
+	if err := s.zoneManager.loadZone(zoneNode, origin, rrs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *testServer) worker(proto string) *worker {
+	return NewWorker(s.config, s.zoneManager, s.serviceManager, nil, nil, nil, NewRRLManager(s.config), nil, "127.0.0.1:0", proto)
+}
+
+func mustRR(s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		panic(err)
+	}
+	return rr
+}
+
+// query sends the query to the worker, and returns the response.
+func query(s *worker, qname string, qtype uint16, do bool) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion(qname, qtype)
+	if do {
+		req.SetEdns0(4096, true)
+	}
+	w := &testWriter{}
+	s.ServeDNS(w, req)
+	return w.msg
+}
+
+// rrString returns "owner TYPE rdata" of the RR, and "owner RRSIG covered" of RRSIG.
+func rrString(rr dns.RR) string {
+	hdr := rr.Header()
+	if sig, ok := rr.(*dns.RRSIG); ok {
+		return hdr.Name + " RRSIG " + dns.Type(sig.TypeCovered).String()
+	}
+	fields := strings.SplitN(rr.String(), "\t", 5)
+	return hdr.Name + " " + fields[3] + " " + fields[4]
+}
+
+// matchRR reports whether the RR is expected one.
+// The expected string without rdata matches any RR of the owner and type.
+func matchRR(expect string, rr dns.RR) bool {
+	got := strings.Fields(rrString(rr))
+	if len(strings.Fields(expect)) == 2 {
+		got = got[:2]
+	}
+	return strings.EqualFold(strings.Join(got, " "), strings.Join(strings.Fields(expect), " "))
+}
+
+func checkSection(t *testing.T, name string, section string, rrs []dns.RR, expect []string) {
+	t.Helper()
+	filtered := []dns.RR{}
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			filtered = append(filtered, rr)
+		}
+	}
+	if len(filtered) != len(expect) {
+		t.Errorf("%s: %s section need to be %v, but %v", name, section, expect, filtered)
+		return
+	}
+	for i, rr := range filtered {
+		if !matchRR(expect[i], rr) {
+			t.Errorf("%s: %s section need to be %v, but %v", name, section, expect, filtered)
+			return
+		}
+	}
+}
+
+// queryTest is a query and the response which is expected.
+type queryTest struct {
+	qname  string
+	qtype  uint16
+	do     bool
+	rcode  int
+	aa     bool
+	answer []string
+	ns     []string
+	extra  []string
+}
+
+func runQueryTests(t *testing.T, s *worker, tests []queryTest) {
+	t.Helper()
+	for _, tc := range tests {
+		name := tc.qname + "/" + dns.Type(tc.qtype).String()
+		if tc.do {
+			name += "/DO"
+		}
+		m := query(s, tc.qname, tc.qtype, tc.do)
+		if m == nil {
+			t.Errorf("%s: response need to be written", name)
+			continue
+		}
+		if m.Rcode != tc.rcode {
+			t.Errorf("%s: rcode need to be %s, but %s", name, dns.RcodeToString[tc.rcode], dns.RcodeToString[m.Rcode])
+		}
+		if m.Authoritative != tc.aa {
+			t.Errorf("%s: AA bit need to be %v", name, tc.aa)
+		}
+		checkSection(t, name, "answer", m.Answer, tc.answer)
+		checkSection(t, name, "authority", m.Ns, tc.ns)
+		checkSection(t, name, "additional", m.Extra, tc.extra)
+	}
+}
+
+const negativeTestZone = `
+example.jp. 3600 IN SOA ns.example.jp. root.example.jp. 1 3600 900 1814400 300
+example.jp. 3600 IN NS ns.example.jp.
+ns.example.jp. 300 IN A 192.0.2.53
+www.example.jp. 300 IN A 192.0.2.1
+a.b.c.example.jp. 300 IN A 192.0.2.2
+alias.example.jp. 300 IN CNAME www.example.jp.
+dangling.example.jp. 300 IN CNAME nx.example.jp.
+`
+
+func TestNegativeResponse(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.loadZone(t, "example.jp.", negativeTestZone)
+	w := s.worker("udp")
+
+	runQueryTests(t, w, []queryTest{
+		// empty non-terminals exist
+		{qname: "b.c.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: true, ns: []string{"example.jp. SOA"}},
+		{qname: "c.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: true, ns: []string{"example.jp. SOA"}},
+		{qname: "x.c.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeNameError, aa: true, ns: []string{"example.jp. SOA"}},
+		{qname: "x.a.b.c.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeNameError, aa: true, ns: []string{"example.jp. SOA"}},
+		{qname: "www.example.jp.", qtype: dns.TypeTXT, rcode: dns.RcodeSuccess, aa: true, ns: []string{"example.jp. SOA"}},
+		// rcode is about the last name of CNAME chain
+		{
+			qname: "alias.example.jp.", qtype: dns.TypeTXT, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"alias.example.jp. CNAME www.example.jp."},
+			ns:     []string{"example.jp. SOA"},
+		},
+		{
+			qname: "dangling.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeNameError, aa: true,
+			answer: []string{"dangling.example.jp. CNAME nx.example.jp."},
+			ns:     []string{"example.jp. SOA"},
+		},
+	})
+
+	// TTL of SOA is min(SOA TTL, SOA MINIMUM), RRSIG keeps the original TTL in RDATA
+	s.signZone(t, "example.jp.")
+	s.loadZone(t, "example.jp.", negativeTestZone)
+	for _, qname := range []string{"nx.example.jp.", "b.c.example.jp.", "dangling.example.jp."} {
+		m := query(w, qname, dns.TypeA, true)
+		found := false
+		for _, rr := range m.Ns {
+			switch rr := rr.(type) {
+			case *dns.SOA:
+				found = true
+				if rr.Hdr.Ttl != 300 {
+					t.Errorf("%s: TTL of SOA need to be 300, but %d", qname, rr.Hdr.Ttl)
+				}
+			case *dns.RRSIG:
+				if rr.TypeCovered == dns.TypeSOA && (rr.Hdr.Ttl != 300 || rr.OrigTtl != 3600) {
+					t.Errorf("%s: TTL of RRSIG SOA need to be 300 and original TTL 3600: %s", qname, rr)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s: negative answer need to have SOA: %v", qname, m.Ns)
+		}
+	}
+	// the cached SOA in zone is not changed
+	if m := query(w, "example.jp.", dns.TypeSOA, false); len(m.Answer) != 1 || m.Answer[0].Header().Ttl != 3600 {
+		t.Errorf("TTL of SOA answer need to be 3600: %v", m.Answer)
+	}
+}
+
+// wildcard examples of RFC 4592 section 2.2.1
+const wildcardTestZone = `
+example.jp. 3600 IN SOA ns.example.jp. root.example.jp. 1 3600 900 1814400 300
+example.jp. 3600 IN NS ns.example.jp.
+ns.example.jp. 300 IN A 192.0.2.53
+*.example.jp. 300 IN TXT "this is a wildcard"
+*.example.jp. 300 IN MX 10 host1.example.jp.
+sub.*.example.jp. 300 IN TXT "this is not a wildcard"
+host1.example.jp. 300 IN A 192.0.2.1
+_ssh._tcp.host1.example.jp. 300 IN SRV 0 0 22 host1.example.jp.
+_ssh._tcp.host2.example.jp. 300 IN SRV 0 0 22 host2.example.jp.
+subdel.example.jp. 300 IN NS ns.example.com.
+`
+
+func TestWildcard(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.loadZone(t, "example.jp.", wildcardTestZone)
+
+	runQueryTests(t, s.worker("udp"), []queryTest{
+		{qname: "host3.example.jp.", qtype: dns.TypeMX, rcode: dns.RcodeSuccess, aa: true, answer: []string{"host3.example.jp. MX 10 host1.example.jp."}},
+		{qname: "host3.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: true, ns: []string{"example.jp. SOA"}},
+		{qname: "foo.bar.example.jp.", qtype: dns.TypeTXT, rcode: dns.RcodeSuccess, aa: true, answer: []string{"foo.bar.example.jp. TXT \"this is a wildcard\""}},
+		// existing names and empty non-terminals block the wildcard
+		{qname: "host1.example.jp.", qtype: dns.TypeMX, rcode: dns.RcodeSuccess, aa: true, ns: []string{"example.jp. SOA"}},
+		{qname: "_tcp.host1.example.jp.", qtype: dns.TypeTXT, rcode: dns.RcodeSuccess, aa: true, ns: []string{"example.jp. SOA"}},
+		{qname: "sub.*.example.jp.", qtype: dns.TypeMX, rcode: dns.RcodeSuccess, aa: true, ns: []string{"example.jp. SOA"}},
+		{qname: "_telnet._tcp.host1.example.jp.", qtype: dns.TypeSRV, rcode: dns.RcodeNameError, aa: true, ns: []string{"example.jp. SOA"}},
+		{qname: "ghost.*.example.jp.", qtype: dns.TypeMX, rcode: dns.RcodeNameError, aa: true, ns: []string{"example.jp. SOA"}},
+		// "*" in qname is not a wildcard
+		{qname: "*.example.jp.", qtype: dns.TypeMX, rcode: dns.RcodeSuccess, aa: true, answer: []string{"*.example.jp. MX 10 host1.example.jp."}},
+		// names under delegation are not synthesized
+		{qname: "host.subdel.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: false, ns: []string{"subdel.example.jp. NS ns.example.com."}},
+	})
+}
+
+func TestDNAME(t *testing.T) {
+	long := strings.Repeat(strings.Repeat("a", 63)+".", 3) + "example.jp."
+	s := newTestServer(t)
+	defer s.Close()
+	s.loadZone(t, "example.jp.", `
+example.jp. 3600 IN SOA ns.example.jp. root.example.jp. 1 3600 900 1814400 300
+example.jp. 3600 IN NS ns.example.jp.
+ns.example.jp. 300 IN A 192.0.2.53
+old.example.jp. 300 IN DNAME new.example.jp.
+www.new.example.jp. 300 IN A 192.0.2.1
+ext.example.jp. 300 IN DNAME example.com.
+long.example.jp. 300 IN DNAME `+long+`
+`)
+	prefix := strings.Repeat("b", 60)
+
+	runQueryTests(t, s.worker("udp"), []queryTest{
+		{
+			qname: "www.old.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{
+				"old.example.jp. DNAME new.example.jp.",
+				"www.old.example.jp. CNAME www.new.example.jp.",
+				"www.new.example.jp. A 192.0.2.1",
+			},
+		},
+		{
+			qname: "x.old.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeNameError, aa: true,
+			answer: []string{"old.example.jp. DNAME new.example.jp.", "x.old.example.jp. CNAME x.new.example.jp."},
+			ns:     []string{"example.jp. SOA"},
+		},
+		{
+			// synthesized CNAME is the answer of CNAME query
+			qname: "www.old.example.jp.", qtype: dns.TypeCNAME, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"old.example.jp. DNAME new.example.jp.", "www.old.example.jp. CNAME www.new.example.jp."},
+		},
+		// owner of DNAME is not substituted
+		{qname: "old.example.jp.", qtype: dns.TypeDNAME, rcode: dns.RcodeSuccess, aa: true, answer: []string{"old.example.jp. DNAME new.example.jp."}},
+		{qname: "old.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: true, ns: []string{"example.jp. SOA"}},
+		{
+			qname: "www.ext.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"ext.example.jp. DNAME example.com.", "www.ext.example.jp. CNAME www.example.com."},
+		},
+		{
+			// substituted name is longer than 255 octets
+			qname: prefix + ".long.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeYXDomain, aa: true,
+			answer: []string{"long.example.jp. DNAME " + long},
+		},
+	})
+}
+
+func TestChase(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	s.config.Zones = []config.ZoneConfig{{Name: "example.org", AllowQuery: []string{"192.0.2.0/24"}}}
+	s.addService("dync", dns.TypeCNAME, "dync.example.jp. 60 IN CNAME www.example.net.")
+	s.loadZone(t, "example.jp.", `
+example.jp. 3600 IN SOA ns.example.jp. root.example.jp. 1 3600 900 1814400 300
+example.jp. 3600 IN NS ns.example.jp.
+ns.example.jp. 300 IN A 192.0.2.53
+www.example.jp. 300 IN A 192.0.2.1
+alias.example.jp. 300 IN CNAME www.example.net.
+dync.example.jp. 300 IN DYNC dync
+loop1.example.jp. 300 IN CNAME loop2.example.net.
+out.example.jp. 300 IN CNAME www.example.com.
+nx.example.jp. 300 IN CNAME nx.example.net.
+private.example.jp. 300 IN CNAME www.example.org.
+chain1.example.jp. 300 IN CNAME chain2.example.jp.
+chain2.example.jp. 300 IN CNAME chain3.example.jp.
+chain3.example.jp. 300 IN CNAME chain4.example.jp.
+chain4.example.jp. 300 IN CNAME www.example.jp.
+`)
+	s.loadZone(t, "example.net.", `
+example.net. 3600 IN SOA ns.example.net. root.example.net. 1 3600 900 1814400 600
+example.net. 3600 IN NS ns.example.net.
+ns.example.net. 300 IN A 192.0.2.53
+www.example.net. 300 IN A 192.0.2.2
+loop2.example.net. 300 IN CNAME loop1.example.jp.
+`)
+	s.loadZone(t, "example.org.", `
+example.org. 3600 IN SOA ns.example.org. root.example.org. 1 3600 900 1814400 600
+example.org. 3600 IN NS ns.example.org.
+ns.example.org. 300 IN A 192.0.2.53
+www.example.org. 300 IN A 192.0.2.3
+`)
+	w := s.worker("udp")
+
+	runQueryTests(t, w, []queryTest{
+		{
+			qname: "alias.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"alias.example.jp. CNAME www.example.net.", "www.example.net. A 192.0.2.2"},
+		},
+		{
+			qname: "dync.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"dync.example.jp. CNAME www.example.net.", "www.example.net. A 192.0.2.2"},
+		},
+		{
+			// loop is stopped, the chain is not negative answer
+			qname: "loop1.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"loop1.example.jp. CNAME loop2.example.net.", "loop2.example.net. CNAME loop1.example.jp."},
+		},
+		{
+			// target out of served zones or not allowed to query is not looked up
+			qname: "out.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"out.example.jp. CNAME www.example.com."},
+		},
+		{
+			qname: "private.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{"private.example.jp. CNAME www.example.org."},
+		},
+		{
+			// negative answer is of the zone of the last target
+			qname: "nx.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeNameError, aa: true,
+			answer: []string{"nx.example.jp. CNAME nx.example.net."},
+			ns:     []string{"example.net. SOA"},
+		},
+		{
+			qname: "chain1.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{
+				"chain1.example.jp. CNAME chain2.example.jp.", "chain2.example.jp. CNAME chain3.example.jp.",
+				"chain3.example.jp. CNAME chain4.example.jp.", "chain4.example.jp. CNAME www.example.jp.",
+				"www.example.jp. A 192.0.2.1",
+			},
+		},
+	})
+
+	// the chain is stopped at the limit
+	s.config.MaxCNAMEChain = 3
+	runQueryTests(t, w, []queryTest{
+		{
+			qname: "chain1.example.jp.", qtype: dns.TypeA, rcode: dns.RcodeSuccess, aa: true,
+			answer: []string{
+				"chain1.example.jp. CNAME chain2.example.jp.", "chain2.example.jp. CNAME chain3.example.jp.",
+				"chain3.example.jp. CNAME chain4.example.jp.",
+			},
+		},
+	})
+}
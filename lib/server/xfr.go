@@ -0,0 +1,210 @@
+// Copyright (C) 2018 Manabu Sonoda.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/miekg/dns"
+	. "github.com/rabbitdns/rabbitdns/lib/misc"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrTransferRefused = errors.New("zone transfer is not allowed.")
+	ErrTransfer        = errors.New("failed to send zone transfer.")
+	ErrResolveDynamic  = errors.New("failed to resolve dynamic RR for zone transfer.")
+)
+
+// room for TSIG RR
+const maxTransferMsgSize = dns.MaxMsgSize - 1024
+
+// allowTransfer checks the client by allow-transfer ACL and transfer keys of the zone.
+func (s *worker) allowTransfer(w dns.ResponseWriter, req *dns.Msg, zoneName string) bool {
+	zone := s.config.GetZoneConfig(zoneName)
+	return allowClient(s.config, AddrIP(w.RemoteAddr()), requestKey(req), zone.AllowTransfer, zone.TransferKeys)
+}
+
+// serveTransfer answers AXFR (RFC 5936) and IXFR (RFC 1995).
+// IXFR is answered from the journal, or by AXFR style response
+// when the journal doesn't have the history.
+func (s *worker) serveTransfer(w dns.ResponseWriter, m *dns.Msg, req *dns.Msg) {
+	qname := req.Question[0].Name
+	qtype := req.Question[0].Qtype
+	zoneNode := s.SearchZone(Labels(qname))
+	if zoneNode == nil || !strings.EqualFold(zoneNode.Label, FQDN(qname)) {
+		m.Rcode = dns.RcodeNotAuth
+		s.writeMsg(w, m, req)
+		return
+	}
+	v, ok := zoneNode.Get("ZoneTree")
+	if !ok {
+		s.servfail(m)
+		s.writeMsg(w, m, req)
+		return
+	}
+	zoneTree := v.(*Tree)
+	if !s.allowTransfer(w, req, zoneNode.Label) {
+		log.WithFields(log.Fields{
+			"Type":     "lib/server/Worker",
+			"Func":     "serveTransfer",
+			"zonename": zoneNode.Label,
+			"client":   w.RemoteAddr().String(),
+		}).Warn(ErrTransferRefused)
+		s.refused(m)
+		s.writeMsg(w, m, req)
+		return
+	}
+	if qtype == dns.TypeIXFR {
+		serial, ok := ixfrSerial(req)
+		if !ok {
+			m.Rcode = dns.RcodeFormatError
+			s.writeMsg(w, m, req)
+			return
+		}
+		current := zoneSOA(zoneTree, zoneNode.Label)
+		if !SerialLess(serial, current.Serial) || !s.stream() {
+			// client is up to date, or client retries with TCP by SOA only response.
+			m.MsgHdr.Authoritative = true
+			m.Answer = []dns.RR{current}
+			s.writeMsg(w, m, req)
+			return
+		}
+		if v, ok := zoneNode.Get("Journal"); ok {
+			if rrs, ok := v.(*zoneJournal).RRs(serial, current); ok {
+				s.writeTransfer(w, req, zoneNode.Label, rrs, "incremental zone transfer")
+				return
+			}
+		}
+	}
+	if !s.stream() {
+		// AXFR is not defined over UDP and HTTPS
+		s.refused(m)
+		s.writeMsg(w, m, req)
+		return
+	}
+	s.writeTransfer(w, req, zoneNode.Label, s.transferRRs(w, req, zoneNode, zoneTree), "zone transfer")
+}
+
+func (s *worker) writeTransfer(w dns.ResponseWriter, req *dns.Msg, zoneName string, rrs []dns.RR, message string) {
+	if err := s.sendTransfer(w, req, rrs); err != nil {
+		log.WithFields(log.Fields{
+			"Type":     "lib/server/Worker",
+			"Func":     "writeTransfer",
+			"Error":    err,
+			"zonename": zoneName,
+			"client":   w.RemoteAddr().String(),
+		}).Warn(ErrTransfer)
+		return
+	}
+	log.WithFields(log.Fields{
+		"Type":     "lib/server/Worker",
+		"Func":     "writeTransfer",
+		"zonename": zoneName,
+		"client":   w.RemoteAddr().String(),
+		"records":  len(rrs),
+	}).Info(message)
+}
+
+// transferRRs returns all RRs of the zone, starting and ending with SOA.
+// Dynamic RRs are resolved into current static RRs or omitted by DynamicTransfer setting.
+// RRSIGs are transferred only for pre-signed zones, online signed zones are signed on the fly.
+func (s *worker) transferRRs(w dns.ResponseWriter, req *dns.Msg, zoneNode *Tree, zoneTree *Tree) []dns.RR {
+	soa := zoneSOA(zoneTree, zoneNode.Label)
+	var signed *signedZone
+	if v, ok := zoneNode.Get("PreSigned"); ok {
+		signed, _ = v.(*signedZone)
+	}
+	static, dynamic := zoneRecords(zoneTree, zoneNode.Label, signed)
+	rrs := []dns.RR{soa}
+	rrs = append(rrs, static...)
+	rrs = append(rrs, s.resolveDynamic(w, req, dynamic)...)
+	return append(rrs, soa)
+}
+
+// ixfrSerial returns the serial of client, which is in authority section of IXFR query.
+func ixfrSerial(req *dns.Msg) (uint32, bool) {
+	for _, rr := range req.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Serial, true
+		}
+	}
+	return 0, false
+}
+
+// resolveDynamic returns static RRs which dynamic RRs generate now.
+func (s *worker) resolveDynamic(w dns.ResponseWriter, req *dns.Msg, dyns []dns.RR) []dns.RR {
+	rrs := []dns.RR{}
+	if s.config.DynamicTransfer == "omit" {
+		return rrs
+	}
+	for _, rr := range dyns {
+		dyn, ok := rr.(*dns.PrivateRR)
+		if !ok {
+			continue
+		}
+		rdata, ok := dyn.Data.(*DYNRR)
+		if !ok {
+			continue
+		}
+		stype := DynamicStaticMap[dyn.Header().Rrtype]
+		resources, err := s.serviceManager.GetResources(w, req, stype, rdata.Resource)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Type":    "lib/server/Worker",
+				"Func":    "resolveDynamic",
+				"Error":   err,
+				"name":    dyn.Header().Name,
+				"service": rdata.Resource,
+			}).Warn(ErrResolveDynamic)
+			continue
+		}
+		for _, resource := range resources {
+			resource.Header().Name = dyn.Header().Name
+			resource.Header().Rrtype = stype
+			resource.Header().Class = dyn.Header().Class
+			resource.Header().Ttl = dyn.Header().Ttl
+			rrs = append(rrs, resource)
+		}
+	}
+	return rrs
+}
+
+func newTransferMsg(req *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.MsgHdr.Authoritative = true
+	m.Compress = true
+	return m
+}
+
+// sendTransfer writes RRs by multiple messages which fit in TCP message size.
+func (s *worker) sendTransfer(w dns.ResponseWriter, req *dns.Msg, rrs []dns.RR) error {
+	m := newTransferMsg(req)
+	for _, rr := range rrs {
+		m.Answer = append(m.Answer, rr)
+		if len(m.Answer) > 1 && m.Len() > maxTransferMsgSize {
+			m.Answer = m.Answer[:len(m.Answer)-1]
+			if err := w.WriteMsg(m); err != nil {
+				return err
+			}
+			m = newTransferMsg(req)
+			m.Answer = append(m.Answer, rr)
+		}
+	}
+	return w.WriteMsg(m)
+}
@@ -18,6 +18,7 @@ package server
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -39,15 +40,21 @@ type zoneManager struct {
 	config         *config.Config
 	zoneSet        *Tree
 	loading        map[string]bool
+	secondaries    map[string]bool
 	serviceManager *serviceManager
+	keyManager     *keyManager
+	notifyManager  *notifyManager
 }
 
-func NewZoneManager(c *config.Config, s *serviceManager) *zoneManager {
+func NewZoneManager(c *config.Config, s *serviceManager, k *keyManager, n *notifyManager) *zoneManager {
 	return &zoneManager{
 		config:         c,
 		zoneSet:        NewTree(),
 		loading:        map[string]bool{},
+		secondaries:    map[string]bool{},
 		serviceManager: s,
+		keyManager:     k,
+		notifyManager:  n,
 	}
 }
 func (m *zoneManager) GetZones() []map[string]string {
@@ -59,6 +66,12 @@ func (m *zoneManager) GetZones() []map[string]string {
 		}
 		results = append(results, result)
 	}
+	for origin, _ := range m.secondaries {
+		result := map[string]string{
+			"name": strings.TrimSuffix(origin, "."),
+		}
+		results = append(results, result)
+	}
 	return results
 }
 
@@ -86,11 +99,7 @@ func (m *zoneManager) ReadZone(zoneFile string) error {
 		}
 	}
 
-	services := []string{}
-	zoneTree := NewTree()
-	zoneTree.Auth = true
-	var RRs []dns.RR
-	success := true
+	rrs := []dns.RR{}
 	for x := range dns.ParseZone(file, origin, "") {
 		if x.Error != nil {
 			log.WithFields(log.Fields{
@@ -98,48 +107,127 @@ func (m *zoneManager) ReadZone(zoneFile string) error {
 				"Func":  "LoadZones",
 				"Error": x.Error,
 			}).Warn(ErrParseRR)
-			success = false
-
+			zoneNode.Set("state", LOAD_ERROR)
 			return ErrParseZone
-		} else {
-			if dyn, ok := x.RR.(*dns.PrivateRR); ok {
-				if rdata, ok := dyn.Data.(*DYNRR); ok {
-					if _, ok := m.serviceManager.GetService(rdata.Resource); ok == false {
-						return errors.Wrap(ErrServiceNotFount, "name:"+dyn.Header().Name+",ServiceName:"+rdata.Resource)
-					}
-					services = append(services, rdata.Resource)
+		}
+		rrs = append(rrs, x.RR)
+	}
+	if err := m.loadZone(zoneNode, origin, rrs); err != nil {
+		return err
+	}
+	zoneNode.Set("ModTime", stat.ModTime())
+
+	log.WithFields(log.Fields{
+		"Type":     "lib/server/zoneManager",
+		"Func":     "readZone",
+		"zonename": origin,
+	}).Info("load zone")
+
+	return nil
+}
+
+// loadZone builds zone tree from RRs and replaces zone data of zoneNode.
+func (m *zoneManager) loadZone(zoneNode *Tree, origin string, rrs []dns.RR) error {
+	origin_labels := Labels(origin)
+	services := []string{}
+	zoneTree := NewTree()
+	zoneTree.Auth = true
+	presigned := false
+	signed := newSignedZone()
+	for _, rr := range rrs {
+		if dyn, ok := rr.(*dns.PrivateRR); ok {
+			if rdata, ok := dyn.Data.(*DYNRR); ok {
+				if _, ok := m.serviceManager.GetService(rdata.Resource); ok == false {
+					return errors.Wrap(ErrServiceNotFount, "name:"+dyn.Header().Name+",ServiceName:"+rdata.Resource)
 				}
+				services = append(services, rdata.Resource)
 			}
-			node := zoneTree.AddRR(x.RR)
-			if x.RR.Header().Rrtype == dns.TypeNS && FQDN(x.RR.Header().Name) != origin {
-				node.Auth = false
+		}
+		switch rr := rr.(type) {
+		case *dns.RRSIG:
+			if rr.TypeCovered == dns.TypeNSEC3 {
+				signed.addNSEC3Sig(rr)
+			} else {
+				zoneTree.AddSig(rr)
 			}
+			presigned = true
+			continue
+		case *dns.NSEC3:
+			// hashed owner name is not a part of zone tree
+			signed.addNSEC3(rr)
+			continue
+		case *dns.NSEC:
+			signed.addNSEC(rr)
+		}
+		node := zoneTree.AddRR(rr)
+		if rr.Header().Rrtype == dns.TypeNS && FQDN(rr.Header().Name) != origin {
+			node.Auth = false
 		}
 	}
+	signed.sort()
 	if err := zoneTree.VerifyZone(origin_labels); err != nil {
 		zoneNode.Set("state", LOAD_ERROR)
 		return err
 
 	}
-	if success != true {
+	keys, err := m.keyManager.LoadKeys(origin)
+	if err != nil {
 		zoneNode.Set("state", LOAD_ERROR)
-		return ErrParseZone
+		return err
 	}
-	zoneNode.Set("ModTime", stat.ModTime())
-	zoneNode.Set("Records", RRs)
-	zoneNode.Set("state", OK)
-	zoneNode.Set("ZoneTree", zoneTree)
-	zoneNode.Set("services", services)
+	var oldTree *Tree
+	var oldSigned *signedZone
+	var journal *zoneJournal
+	if v, ok := zoneNode.Get("ZoneTree"); ok {
+		oldTree, _ = v.(*Tree)
+	}
+	if v, ok := zoneNode.Get("PreSigned"); ok {
+		oldSigned, _ = v.(*signedZone)
+	}
+	if v, ok := zoneNode.Get("Journal"); ok {
+		journal, _ = v.(*zoneJournal)
+	}
+	if journal == nil {
+		// journal file is read only at the first load
+		journal = loadJournal(m.config.JournalDir, origin)
+	}
+	params := map[string]interface{}{
+		"Records":  rrs,
+		"state":    OK,
+		"ZoneTree": zoneTree,
+		"services": services,
+	}
+	if presigned {
+		// zone is signed by external tooling, keys are not used.
+		params["PreSigned"] = signed
+	} else if keys != nil {
+		addKeyRRs(zoneTree, origin_labels, keys)
+		params["DNSSEC"] = keys
+	}
+	if !presigned {
+		signed = nil
+	}
+	if oldTree != nil {
+		if journal, err = m.appendJournal(origin, journal, oldTree, oldSigned, zoneTree, signed); err != nil {
+			log.WithFields(log.Fields{
+				"Type":     "lib/server/zoneManager",
+				"Func":     "readZone",
+				"Error":    err,
+				"zonename": origin,
+			}).Warn(err)
+		}
+		oldSOA, newSOA := zoneSOA(oldTree, origin), zoneSOA(zoneTree, origin)
+		if oldSOA != nil && newSOA != nil && oldSOA.Serial != newSOA.Serial {
+			m.notifyManager.Notify(origin, zoneTree)
+		}
+	}
+	params["Journal"] = journal
+	// new zone data is published at once, signed zone is never answered without keys
+	zoneNode.Replace(params, "DNSSEC", "PreSigned")
 	for _, service_name := range services {
 		m.serviceManager.RegisterService(service_name, origin)
 	}
 
-	log.WithFields(log.Fields{
-		"Type":     "lib/server/zoneManager",
-		"Func":     "readZone",
-		"zonename": origin,
-	}).Info("load zone")
-
 	return nil
 }
 
@@ -157,6 +245,10 @@ func (m *zoneManager) LoadZones() error {
 		return ErrGlobZone
 	}
 	for _, f := range matches {
+		if m.config.GetZoneConfig(filepath.Base(f)).IsSecondary() {
+			// secondary zone is loaded by zone transfer
+			continue
+		}
 		err := m.ReadZone(f)
 		m.loading[f] = true
 		if err != nil {
@@ -193,3 +285,136 @@ func (m *zoneManager) DeleteZones() {
 		}
 	}
 }
+
+// zoneFile returns path of the zone file which is loaded as origin.
+func (m *zoneManager) zoneFile(origin string) (string, bool) {
+	for file, loaded := range m.loading {
+		if loaded && strings.EqualFold(FQDN(filepath.Base(file)), origin) {
+			return file, true
+		}
+	}
+	return "", false
+}
+
+// RefreshZone reads the zone file even if it is not modified.
+func (m *zoneManager) RefreshZone(zoneFile string) error {
+	origin := FQDN(filepath.Base(zoneFile))
+	if node := m.zoneSet.SearchNode(Labels(origin), true); node != nil {
+		node.Delete("ModTime")
+	}
+	return m.ReadZone(zoneFile)
+}
+
+// LoadSecondaryZone replaces zone data by RRs transferred from primary server.
+func (m *zoneManager) LoadSecondaryZone(origin string, rrs []dns.RR) error {
+	zoneNode := m.zoneSet.AddNode(Labels(origin))
+	zoneNode.Set("provide", true)
+	m.secondaries[origin] = true
+	return m.loadZone(zoneNode, origin, rrs)
+}
+
+// RefreshSecondaryZone rebuilds the secondary zone from the last transferred RRs.
+func (m *zoneManager) RefreshSecondaryZone(origin string) error {
+	zoneNode := m.zoneSet.SearchNode(Labels(origin), true)
+	if zoneNode == nil {
+		return ErrSecondaryEmpty
+	}
+	v, ok := zoneNode.Get("Records")
+	if !ok {
+		return ErrSecondaryEmpty
+	}
+	rrs, ok := v.([]dns.RR)
+	if !ok || len(rrs) == 0 {
+		return ErrSecondaryEmpty
+	}
+	return m.loadZone(zoneNode, origin, rrs)
+}
+
+// ExpireZone stops answering the secondary zone until it is transferred again.
+func (m *zoneManager) ExpireZone(origin string) {
+	if zoneNode := m.zoneSet.SearchNode(Labels(origin), true); zoneNode != nil {
+		zoneNode.Replace(map[string]interface{}{"state": LOAD_ERROR}, "ZoneTree", "Records", "DNSSEC", "PreSigned")
+	}
+}
+
+// RekeyZone reloads the primary zone whose key set is changed.
+// SOA serial is increased in the zone file, so that secondaries find
+// the new DNSKEY, CDS and CDNSKEY RRsets by SOA refresh and IXFR.
+func (m *zoneManager) RekeyZone(zoneFile string) error {
+	origin := FQDN(filepath.Base(zoneFile))
+	if zoneNode := m.zoneSet.SearchNode(Labels(origin), true); zoneNode != nil {
+		_, presigned := zoneNode.Get("PreSigned")
+		v, ok := zoneNode.Get("Records")
+		if rrs, isRRs := v.([]dns.RR); ok && isRRs && !presigned {
+			if err := writeZoneFile(zoneFile, origin, increaseSerial(rrs)); err != nil {
+				return err
+			}
+		}
+	}
+	return m.RefreshZone(zoneFile)
+}
+
+// UpdateKeys proceeds DNSSEC key lifecycle of zones,
+// and reloads zones whose key set is changed.
+// Serial of secondary zones is owned by the primary server, so it is not changed.
+func (m *zoneManager) UpdateKeys() {
+	now := time.Now()
+	for file, _ := range m.loading {
+		origin := FQDN(filepath.Base(file))
+		if m.updateKeys(origin, now) {
+			m.logKeyError(origin, m.RekeyZone(file))
+		}
+	}
+	for origin, _ := range m.secondaries {
+		if m.updateKeys(origin, now) {
+			m.logKeyError(origin, m.RefreshSecondaryZone(origin))
+		}
+	}
+}
+
+func (m *zoneManager) updateKeys(origin string, now time.Time) bool {
+	changed, err := m.keyManager.Update(origin, now)
+	m.logKeyError(origin, err)
+	return err == nil && changed
+}
+
+func (m *zoneManager) logKeyError(origin string, err error) {
+	if err == nil {
+		return
+	}
+	log.WithFields(log.Fields{
+		"Type":     "lib/server/zoneManager",
+		"Func":     "UpdateKeys",
+		"Error":    err,
+		"zonename": origin,
+	}).Warn(err)
+}
+
+// addKeyRRs publishes DNSKEY, CDS and CDNSKEY RRs at zone apex, unless the zone file already has it.
+func addKeyRRs(zoneTree *Tree, origin_labels []string, keys *zoneKeys) {
+	apex := zoneTree.SearchNode(origin_labels, true)
+	ttl := uint32(3600)
+	if soa, ok := apex.GetRR(dns.TypeSOA); ok {
+		ttl = soa[0].Header().Ttl
+	}
+	current, _ := apex.GetRR(dns.TypeDNSKEY)
+NEXT:
+	for _, rr := range keys.DNSKEYs() {
+		for _, c := range current {
+			if dnskey, ok := c.(*dns.DNSKEY); ok && dnskey.PublicKey == rr.(*dns.DNSKEY).PublicKey {
+				continue NEXT
+			}
+		}
+		rr.Header().Ttl = ttl
+		apex.SetRR(rr)
+	}
+	_, hasCDS := apex.GetRR(dns.TypeCDS)
+	_, hasCDNSKEY := apex.GetRR(dns.TypeCDNSKEY)
+	if hasCDS || hasCDNSKEY {
+		return
+	}
+	for _, rr := range keys.CDSs() {
+		rr.Header().Ttl = ttl
+		apex.SetRR(rr)
+	}
+}